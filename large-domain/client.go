@@ -6,14 +6,25 @@ import (
 
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
 	"github.com/tuneinsight/lattigo/v5/he/heint"
-	"github.com/tuneinsight/lattigo/v5/utils"
+	"github.com/tuneinsight/lattigo/v5/ring"
+	"github.com/tuneinsight/lattigo/v5/utils/sampling"
 )
 
-// Points is a struct storing a set of encrypts points.
-type Points [][]*rlwe.Ciphertext
+// Points is a struct storing a set of encrypted points of the domain [0, T).
+// Value[i] is the i-th point, split over ceil(T/N) ciphertexts.
+type Points struct {
+	T     uint64
+	Value [][]*rlwe.Ciphertext
+}
+
+// Len returns the number of points.
+func (p Points) Len() int {
+	return len(p.Value)
+}
 
 // Client is a struct storing the necessary elements
 // to encode, encrypt and decrypt points.
+// T is the default domain of the points, see EncryptWithDomain.
 type Client struct {
 	T uint64
 	heint.Parameters
@@ -21,27 +32,145 @@ type Client struct {
 	*rlwe.Encryptor
 	*rlwe.Decryptor
 	*rlwe.MemEvaluationKeySet
+
+	// SeededEvaluationKeySet is the compressed MemEvaluationKeySet,
+	// only generated if KeyParameters.Seeded is set.
+	SeededEvaluationKeySet *SeededEvaluationKeySet
 }
 
 // NewClient instantiates a new client.
-func NewClient(params heint.Parameters, T uint64) *Client {
-	// Instantiates an rlwe.KeyGenerator
-	kgen := heint.NewKeyGenerator(params)
+// The optional KeyParameters select the strategy used to generate the evaluation keys.
+func NewClient(params heint.Parameters, T uint64, keyParams ...KeyParameters) *Client {
 
 	// Generates the client secret key
-	sk := kgen.GenSecretKeyNew()
+	sk := heint.NewKeyGenerator(params).GenSecretKeyNew()
+
+	c, err := newClient(params, T, sk, nil, nil, keyParams...)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// NewClientFromSeed instantiates a new client whose secret key, and the uniform
+// components of its evaluation keys and encryptions, are derived from seed, which
+// must be of SeedSize bytes. The keys and encryptions are generated by an
+// rlwe.KeyGenerator and an rlwe.Encryptor whose uniform sampler is keyed with
+// WithPRNG. Their errors are sampled by lattigo, which does not expose a way to
+// seed them, so the keys and ciphertexts of two clients of the same seed only
+// differ by their errors, and decrypt under the same secret key.
+// It is meant for known-answer tests: the encryptions of a client
+// whose seed is known are not secure.
+func NewClientFromSeed(params heint.Parameters, T uint64, seed []byte, keyParams ...KeyParameters) (c *Client, err error) {
+
+	if len(seed) != SeedSize {
+		return nil, fmt.Errorf("invalid seed size: %d != %d", len(seed), SeedSize)
+	}
+
+	var prng sampling.PRNG
+	if prng, err = sampling.NewKeyedPRNG(seed); err != nil {
+		return nil, fmt.Errorf("sampling.NewKeyedPRNG: %w", err)
+	}
+
+	// Derives one seed for the secret key, the keys and the encryptions
+	seeds := make([][]byte, 3)
+	for i := range seeds {
+		seeds[i] = make([]byte, SeedSize)
+		if _, err = prng.Read(seeds[i]); err != nil {
+			return nil, fmt.Errorf("prng.Read: %w", err)
+		}
+	}
+
+	var sk *rlwe.SecretKey
+	if sk, err = newSecretKeyFromSeed(params, seeds[0]); err != nil {
+		return nil, err
+	}
+
+	var prngEnc sampling.PRNG
+	if prngEnc, err = sampling.NewKeyedPRNG(seeds[2]); err != nil {
+		return nil, fmt.Errorf("sampling.NewKeyedPRNG: %w", err)
+	}
+
+	return newClient(params, T, sk, seeds[1], prngEnc, keyParams...)
+}
+
+// newSecretKeyFromSeed samples a secret key from the distribution params.Xs() with a PRNG keyed
+// with seed, as rlwe.KeyGenerator.GenSecretKey, which samples it from an unseeded PRNG.
+func newSecretKeyFromSeed(params heint.Parameters, seed []byte) (sk *rlwe.SecretKey, err error) {
+
+	var prng sampling.PRNG
+	if prng, err = sampling.NewKeyedPRNG(seed); err != nil {
+		return nil, fmt.Errorf("sampling.NewKeyedPRNG: %w", err)
+	}
+
+	xs, err := ring.NewSampler(prng, params.RingQ(), params.Xs(), false)
+	if err != nil {
+		return nil, fmt.Errorf("ring.NewSampler: %w", err)
+	}
+
+	sk = rlwe.NewSecretKey(params)
+
+	ringQP := params.RingQP().AtLevel(sk.LevelQ(), sk.LevelP())
+
+	xs.AtLevel(sk.LevelQ()).Read(sk.Value.Q)
+
+	if levelP := sk.LevelP(); levelP > -1 {
+		ringQP.ExtendBasisSmallNormAndCenter(sk.Value.Q, levelP, sk.Value.Q, sk.Value.P)
+	}
+
+	ringQP.NTT(sk.Value, sk.Value)
+	ringQP.MForm(sk.Value, sk.Value)
+
+	return
+}
+
+// newClient instantiates a new client with the given secret key. The uniform component
+// of the evaluation keys is generated from keySeed and the one of the encryptions from
+// prngEnc if they are not nil, and from fresh randomness otherwise.
+func newClient(params heint.Parameters, T uint64, sk *rlwe.SecretKey, keySeed []byte, prngEnc sampling.PRNG, keyParams ...KeyParameters) (c *Client, err error) {
+
+	var kp KeyParameters
+	if len(keyParams) != 0 {
+		kp = keyParams[0]
+	}
+
+	if kp.NbPoints == 0 {
+		kp.NbPoints = params.N()
+	}
 
 	// Instantiates an rlwe.Encryptor, rlwe.Decryptor and heint.Encoder
 	enc := heint.NewEncryptor(params, sk)
+	if prngEnc != nil {
+		enc = enc.WithPRNG(prngEnc)
+	}
+
 	dec := heint.NewDecryptor(params, sk)
 	ecd := heint.NewEncoder(params)
 
 	// Galois elements needed for the repacking
-	galEls := params.GaloisElementsForPack(params.LogN())
+	galEls := GaloisElementsForEvaluate(params, kp.NbPoints)
+
+	evkParams, err := kp.EvaluationKeyParameters(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// The uniform component of the keys is generated from a seed
+	// which is sent instead of the component itself
+	if kp.Seeded && keySeed == nil {
+		if keySeed, err = NewSeed(); err != nil {
+			return nil, err
+		}
+	}
 
-	// Since we do not use a modulus P, we need to specify a base-2 decomposition
-	// parameters for the evaluation keys to control the noise
-	evkParams := rlwe.EvaluationKeyParameters{BaseTwoDecomposition: utils.Pointy(BaseTwoDecomposition)}
+	// Instantiates an rlwe.KeyGenerator
+	kgen := heint.NewKeyGenerator(params)
+	if keySeed != nil {
+		if kgen, err = NewSeededKeyGenerator(params, keySeed); err != nil {
+			return nil, err
+		}
+	}
 
 	// Generates a list of Galois keys from the provided Galois elements
 	// Galois keys is public-material that can be shared.
@@ -50,34 +179,83 @@ func NewClient(params heint.Parameters, T uint64) *Client {
 	// Struct holding the keys compliant to the rlwe.EvaluationKeySet interface
 	evk := rlwe.NewMemEvaluationKeySet(nil, gks...)
 
+	var sevk *SeededEvaluationKeySet
+	if kp.Seeded {
+		sevk = NewSeededEvaluationKeySet(keySeed, gks)
+	}
+
 	return &Client{
-		T:                   T,
-		Parameters:          params,
-		Encoder:             ecd,
-		Encryptor:           enc,
-		Decryptor:           dec,
-		MemEvaluationKeySet: evk,
+		T:                      T,
+		Parameters:             params,
+		Encoder:                ecd,
+		Encryptor:              enc,
+		Decryptor:              dec,
+		MemEvaluationKeySet:    evk,
+		SeededEvaluationKeySet: sevk,
+	}, nil
+}
+
+// EvaluationKeysBinarySize returns the size in bytes of the evaluation
+// keys uploaded by the client, i.e. the SeededEvaluationKeySet if
+// it was generated and the MemEvaluationKeySet otherwise.
+func (c Client) EvaluationKeysBinarySize() int {
+	if c.SeededEvaluationKeySet != nil {
+		return c.SeededEvaluationKeySet.BinarySize()
 	}
+	return c.MemEvaluationKeySet.BinarySize()
 }
 
-// Encrypt encrypts a list of points.
+// ShallowCopy creates a shallow copy of the client in which the keys are
+// shared with the receiver. The returned client can be used concurrently with the receiver.
+// The returned client always encrypts with fresh randomness, even if the
+// receiver was instantiated with NewClientFromSeed.
+func (c Client) ShallowCopy() *Client {
+	return &Client{
+		T:                      c.T,
+		Parameters:             c.Parameters,
+		Encoder:                c.Encoder.ShallowCopy(),
+		Encryptor:              c.Encryptor.ShallowCopy(),
+		Decryptor:              c.Decryptor.ShallowCopy(),
+		MemEvaluationKeySet:    c.MemEvaluationKeySet,
+		SeededEvaluationKeySet: c.SeededEvaluationKeySet,
+	}
+}
+
+// Encrypt encrypts a list of points of the domain [0, c.T).
 func (c Client) Encrypt(points []uint64) (ctXi Points) {
+	return c.EncryptWithDomain(points, c.T)
+}
+
+// EncryptWithDomain encrypts a list of points of the domain [0, T),
+// which must match the domain of the function they are evaluated on.
+func (c Client) EncryptWithDomain(points []uint64, T uint64) (ctXi Points) {
 
 	params := c.Parameters
 	ecd := c.Encoder
 	enc := c.Encryptor
-	T := c.T
+
+	if T == 0 || T > params.PlaintextModulus() {
+		panic(fmt.Errorf("invalid domain: T=%d must be in [1, %d]", T, params.PlaintextModulus()))
+	}
 
 	// Generate Enc(X^i) with split domain [Z_N U Z_N U ... U Z_N >= Z_T]
-	ctXi = make([][]*rlwe.Ciphertext, len(points))
+	ctXi = Points{
+		T:     T,
+		Value: make([][]*rlwe.Ciphertext, len(points)),
+	}
 
 	// Buffer
 	ptXi := heint.NewPlaintext(params, params.MaxLevel())
 
 	// Encrypt each point
 	m := make([]uint64, params.N())
-	for i := range ctXi {
-		ctXi[i] = encryptXi(params, points[i], T, m, ptXi, ecd, enc)
+	for i := range ctXi.Value {
+
+		if points[i] >= T {
+			panic(fmt.Errorf("invalid point: %d is not in [0, %d)", points[i], T))
+		}
+
+		ctXi.Value[i] = encryptXi(params, points[i], T, m, ptXi, ecd, enc)
 	}
 
 	return
@@ -111,12 +289,11 @@ func encryptXi(params heint.Parameters, i, T uint64, m []uint64, pt *rlwe.Plaint
 // Decrypt decrypts and decodes the result.
 func (c Client) Decrypt(ct *rlwe.Ciphertext) (v []uint64) {
 
-	params := c.Parameters
 	ecd := c.Encoder
 	dec := c.Decryptor
 
 	// Decrypts and decodes the result on v
-	v = make([]uint64, params.N())
+	v = make([]uint64, ct.Slots())
 	if err := ecd.Decode(dec.DecryptNew(ct), v); err != nil {
 		panic(err)
 	}
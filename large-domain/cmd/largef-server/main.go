@@ -0,0 +1,54 @@
+// Command largef-server runs a largef.Service on a local network address.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	largef "github.com/pro7ech/fhe-org-2024/large-domain"
+)
+
+func main() {
+
+	addr := flag.String("addr", "localhost:8080", "address on which the service listens")
+	T := flag.Uint64("T", 1<<15, "input function domain, must be smaller or equal to the plaintext modulus")
+	maxKeySize := flag.Int64("max-key-size", largef.DefaultMaxKeySize, "maximum size in bytes of an evaluation key set")
+	maxQuerySize := flag.Int64("max-query-size", 0, "maximum size in bytes of a query (0 = derived from the parameters)")
+	maxKeys := flag.Int("max-keys", largef.DefaultMaxKeys, "maximum number of registered evaluation key sets")
+	keyTTL := flag.Duration("key-ttl", largef.DefaultKeyTTL, "lifetime of a registered evaluation key set")
+	maxConcurrentQueries := flag.Int("max-concurrent-queries", 0, "number of queries evaluated concurrently (0 = #CPU)")
+	flag.Parse()
+
+	params, err := largef.GetParameters()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *T > params.PlaintextModulus() {
+		log.Fatalf("T=%d cannot be greater than the plaintext modulus %d", *T, params.PlaintextModulus())
+	}
+
+	// Example function tables, see largef_test.go
+	b := uint64(257)
+	h := uint64(257)
+	alpha := *T/h + 1
+
+	functions := map[string]func(x uint64) (y uint64){
+		"f0": func(x uint64) (y uint64) { return x / b * alpha },
+		"f1": func(x uint64) (y uint64) { return x / h },
+	}
+
+	log.Printf("generating test polynomials for %d functions over [0, %d)", len(functions), *T)
+
+	service := largef.NewService(largef.NewServer(params, *T), functions, largef.ServiceParameters{
+		MaxKeySize:           *maxKeySize,
+		MaxQuerySize:         *maxQuerySize,
+		MaxKeys:              *maxKeys,
+		KeyTTL:               *keyTTL,
+		MaxConcurrentQueries: *maxConcurrentQueries,
+	})
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, service))
+}
@@ -0,0 +1,136 @@
+package largef
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/utils/buffer"
+	"github.com/tuneinsight/lattigo/v5/utils/sampling"
+)
+
+// flagUpdate regenerates the known-answer test fixtures.
+var flagUpdate = flag.Bool("update", false, "regenerate the known-answer test fixtures in testdata/kat")
+
+// Known-answer test inputs. Any change in the seeded key generation, encryption,
+// evaluation or serialization changes the fixtures and must be deliberate.
+var (
+	katSeed     = []byte("largef known-answer test seed 00")
+	katMaskSeed = []byte("largef known-answer test mask 00")
+	katT        = uint64(1 << LogN)
+	katPoints   = []uint64{42, 2047}
+)
+
+func TestKnownAnswer(t *testing.T) {
+
+	params, err := GetParameters()
+	require.NoError(t, err)
+
+	kp := KeyParameters{NbPoints: len(katPoints), Seeded: true}
+
+	client, err := NewClientFromSeed(params, katT, katSeed, kp)
+	require.NoError(t, err)
+
+	// Same seed, same secret key and uniform components
+	other, err := NewClientFromSeed(params, katT, katSeed, kp)
+	require.NoError(t, err)
+
+	require.Equal(t, client.SeededEvaluationKeySet.Seed, other.SeededEvaluationKeySet.Seed)
+
+	q := Query{
+		KeyID:     "kat",
+		Functions: []string{"f0"},
+		Points:    []Points{client.Encrypt(katPoints)},
+	}
+
+	requireSameUniform(t, q.Points[0], other.Encrypt(katPoints))
+
+	// The keys and the query are the inputs of the test: the errors of the
+	// encryptions are not seeded, so they are only regenerated with -update.
+	if *flagUpdate {
+		writeFixture(t, "keys.bin", serialize(t, client.SeededEvaluationKeySet))
+		writeFixture(t, "query.bin", serialize(t, q))
+	}
+
+	sevk := &SeededEvaluationKeySet{}
+	_, err = sevk.ReadFrom(buffer.NewBuffer(readFixture(t, "keys.bin")))
+	require.NoError(t, err)
+	require.Equal(t, client.SeededEvaluationKeySet.Seed, sevk.Seed)
+
+	stored := Query{}
+	_, err = stored.ReadFrom(buffer.NewBuffer(readFixture(t, "query.bin")))
+	require.NoError(t, err)
+	require.Len(t, stored.Points, 1)
+	requireSameUniform(t, q.Points[0], stored.Points[0])
+
+	evk, err := sevk.Expand(params)
+	require.NoError(t, err)
+
+	prng, err := sampling.NewKeyedPRNG(katMaskSeed)
+	require.NoError(t, err)
+
+	server := NewServer(params, katT).WithPRNG(prng)
+
+	// The evaluation is deterministic
+	ct := server.Evaluate(stored.Points, []TestPoly{server.GenTestPolynomials(F[0], katT)}, evk)
+
+	v := other.Decrypt(ct)
+	for i := range katPoints {
+		require.Equal(t, F[0](katPoints[i]), v[i])
+	}
+
+	response := &bytes.Buffer{}
+	w := bufio.NewWriter(response)
+	_, err = writeCiphertext(w, ct)
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+
+	if *flagUpdate {
+		writeFixture(t, "response.bin", response.Bytes())
+	}
+
+	require.True(t, bytes.Equal(readFixture(t, "response.bin"), response.Bytes()), "response.bin does not match the fixture")
+
+	// The fixtures can be read back
+	ct = &rlwe.Ciphertext{}
+	_, err = readCiphertext(buffer.NewBuffer(readFixture(t, "response.bin")), ct)
+	require.NoError(t, err)
+	require.Equal(t, v, client.Decrypt(ct))
+}
+
+// requireSameUniform checks that the points have the same uniform components.
+func requireSameUniform(t *testing.T, want, have Points) {
+	require.Equal(t, want.T, have.T)
+	require.Equal(t, want.Len(), have.Len())
+	for i := range want.Value {
+		require.Equal(t, len(want.Value[i]), len(have.Value[i]))
+		for j := range want.Value[i] {
+			require.True(t, want.Value[i][j].Value[1].Equal(&have.Value[i][j].Value[1]), "points[%d][%d]", i, j)
+		}
+	}
+}
+
+func writeFixture(t *testing.T, name string, data []byte) {
+	path := filepath.Join("testdata", "kat", name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(filepath.Join("testdata", "kat", name))
+	require.NoError(t, err, "run go test -run TestKnownAnswer -update to generate the fixtures")
+	return data
+}
+
+func serialize(t *testing.T, obj io.WriterTo) []byte {
+	data := &bytes.Buffer{}
+	_, err := obj.WriteTo(data)
+	require.NoError(t, err)
+	return data.Bytes()
+}
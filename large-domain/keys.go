@@ -0,0 +1,341 @@
+package largef
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/heint"
+	"github.com/tuneinsight/lattigo/v5/ring/ringqp"
+	"github.com/tuneinsight/lattigo/v5/utils"
+	"github.com/tuneinsight/lattigo/v5/utils/buffer"
+	"github.com/tuneinsight/lattigo/v5/utils/sampling"
+	"github.com/tuneinsight/lattigo/v5/utils/structs"
+)
+
+// SeedSize is the size in bytes of the seed of a SeededEvaluationKeySet.
+const SeedSize = 32
+
+// KeyParameters is a struct storing the strategy used by NewClient to
+// generate the evaluation keys, each option trading server time for upload size.
+// The zero value generates the Galois keys needed to repack N points with a
+// base-two decomposition of BaseTwoDecomposition bits and no modulus P.
+type KeyParameters struct {
+	// NbPoints is the maximum number of points per query. If smaller than N,
+	// only the Galois keys needed to repack NbPoints values are generated
+	// (see GaloisElementsForEvaluate). Zero value defaults to N.
+	NbPoints int
+
+	// Seeded generates the uniform component of the Galois keys from a
+	// seed, so that the client can upload the SeededEvaluationKeySet,
+	// of about half the size, instead of the full keys.
+	// The server must regenerate the keys with SeededEvaluationKeySet.Expand.
+	Seeded bool
+
+	// Hybrid uses the hybrid RNS decomposition with the auxiliary modulus P
+	// instead of the base-two decomposition, which reduces the number of
+	// rows of the keys at the cost of a modulus switch per key-switching.
+	// Requires parameters with a modulus P (see GetParametersHybrid).
+	Hybrid bool
+}
+
+// EvaluationKeyParameters returns the rlwe.EvaluationKeyParameters of the Galois keys.
+func (kp KeyParameters) EvaluationKeyParameters(params heint.Parameters) (evkParams rlwe.EvaluationKeyParameters, err error) {
+
+	if kp.Hybrid {
+
+		if params.PCount() == 0 {
+			return evkParams, fmt.Errorf("hybrid decomposition requires parameters with a modulus P")
+		}
+
+		return rlwe.EvaluationKeyParameters{
+			LevelP:               utils.Pointy(params.MaxLevelP()),
+			BaseTwoDecomposition: utils.Pointy(0),
+		}, nil
+	}
+
+	// Since we do not use a modulus P, we need to specify a base-2 decomposition
+	// parameters for the evaluation keys to control the noise
+	return rlwe.EvaluationKeyParameters{
+		LevelP:               utils.Pointy(-1),
+		BaseTwoDecomposition: utils.Pointy(BaseTwoDecomposition),
+	}, nil
+}
+
+// GaloisElementsForEvaluate returns the list of Galois elements required
+// by Server.Evaluate to repack nbPoints values.
+// Repacking n < N values only needs log2(n) of the log2(N)+1 Galois keys.
+func GaloisElementsForEvaluate(params heint.Parameters, nbPoints int) (galEls []uint64) {
+
+	if nbPoints < 2 {
+		return nil
+	}
+
+	logGap := bits.Len64(uint64(nbPoints - 1))
+
+	if logGap >= params.LogN() {
+		return params.GaloisElementsForPack(params.LogN())
+	}
+
+	// Steps LogN-logGap, ..., LogN-1 of rlwe.Evaluator.Pack
+	for i := params.LogN() - logGap; i < params.LogN(); i++ {
+		galEls = append(galEls, params.GaloisElement(1<<(i-1)))
+	}
+
+	return
+}
+
+// SeededEvaluationKeySet is a compressed representation of a list of Galois keys
+// in which the uniform component of each key is replaced by the seed of the PRNG
+// that generated it.
+type SeededEvaluationKeySet struct {
+	Seed                 []byte
+	BaseTwoDecomposition int
+	GaloisElements       []uint64
+	// Value[k][i][j] is the first component of the k-th Galois key.
+	Value []structs.Matrix[ringqp.Poly]
+}
+
+// NewSeededKeyGenerator returns an rlwe.KeyGenerator sampling the
+// uniform component of the evaluation keys from a PRNG keyed with seed.
+func NewSeededKeyGenerator(params heint.Parameters, seed []byte) (kgen *rlwe.KeyGenerator, err error) {
+
+	var prng sampling.PRNG
+	if prng, err = sampling.NewKeyedPRNG(seed); err != nil {
+		return nil, fmt.Errorf("sampling.NewKeyedPRNG: %w", err)
+	}
+
+	return &rlwe.KeyGenerator{Encryptor: rlwe.NewEncryptor(params, nil).WithPRNG(prng)}, nil
+}
+
+// NewSeed returns a new random seed of SeedSize bytes.
+func NewSeed() (seed []byte, err error) {
+	seed = make([]byte, SeedSize)
+	if _, err = rand.Read(seed); err != nil {
+		return nil, fmt.Errorf("rand.Read: %w", err)
+	}
+	return
+}
+
+// NewSeededEvaluationKeySet compresses a list of Galois keys generated, in this order,
+// by a KeyGenerator returned by NewSeededKeyGenerator with the given seed.
+func NewSeededEvaluationKeySet(seed []byte, gks []*rlwe.GaloisKey) *SeededEvaluationKeySet {
+
+	skey := &SeededEvaluationKeySet{
+		Seed:           seed,
+		GaloisElements: make([]uint64, len(gks)),
+		Value:          make([]structs.Matrix[ringqp.Poly], len(gks)),
+	}
+
+	for k, gk := range gks {
+
+		skey.BaseTwoDecomposition = gk.BaseTwoDecomposition
+		skey.GaloisElements[k] = gk.GaloisElement
+
+		skey.Value[k] = make([][]ringqp.Poly, len(gk.Value))
+		for i := range gk.Value {
+			skey.Value[k][i] = make([]ringqp.Poly, len(gk.Value[i]))
+			for j := range gk.Value[i] {
+				skey.Value[k][i][j] = gk.Value[i][j][0]
+			}
+		}
+	}
+
+	return skey
+}
+
+// Expand regenerates the uniform component of the keys from the seed and
+// returns the full evaluation key set.
+func (skey SeededEvaluationKeySet) Expand(params heint.Parameters) (evk *rlwe.MemEvaluationKeySet, err error) {
+
+	if len(skey.GaloisElements) != len(skey.Value) {
+		return nil, fmt.Errorf("invalid SeededEvaluationKeySet: #GaloisElements=%d != #Value=%d", len(skey.GaloisElements), len(skey.Value))
+	}
+
+	var prng sampling.PRNG
+	if prng, err = sampling.NewKeyedPRNG(skey.Seed); err != nil {
+		return nil, fmt.Errorf("sampling.NewKeyedPRNG: %w", err)
+	}
+
+	// Same sampler as the rlwe.Encryptor of the key generator
+	sampler := ringqp.NewUniformSampler(prng, *params.RingQP())
+
+	gks := make([]*rlwe.GaloisKey, len(skey.Value))
+
+	for k := range skey.Value {
+
+		if len(skey.Value[k]) == 0 || len(skey.Value[k][0]) == 0 {
+			return nil, fmt.Errorf("invalid SeededEvaluationKeySet: empty key")
+		}
+
+		levelQ := skey.Value[k][0][0].LevelQ()
+		levelP := skey.Value[k][0][0].LevelP()
+
+		if levelQ > params.MaxLevelQ() || levelP > params.MaxLevelP() {
+			return nil, fmt.Errorf("invalid SeededEvaluationKeySet: key levels do not match the parameters")
+		}
+
+		gk := &rlwe.GaloisKey{
+			GaloisElement: skey.GaloisElements[k],
+			NthRoot:       params.RingQ().NthRoot(),
+			EvaluationKey: rlwe.EvaluationKey{
+				GadgetCiphertext: *rlwe.NewGadgetCiphertext(params, 1, levelQ, levelP, skey.BaseTwoDecomposition),
+			},
+		}
+
+		if len(gk.Value) != len(skey.Value[k]) {
+			return nil, fmt.Errorf("invalid SeededEvaluationKeySet: key %d has an invalid decomposition", k)
+		}
+
+		for i := range gk.Value {
+
+			if len(gk.Value[i]) != len(skey.Value[k][i]) {
+				return nil, fmt.Errorf("invalid SeededEvaluationKeySet: key %d has an invalid decomposition", k)
+			}
+
+			for j := range gk.Value[i] {
+				gk.Value[i][j][0].Copy(skey.Value[k][i][j])
+				sampler.AtLevel(levelQ, levelP).Read(gk.Value[i][j][1])
+			}
+		}
+
+		gks[k] = gk
+	}
+
+	return rlwe.NewMemEvaluationKeySet(nil, gks...), nil
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (skey SeededEvaluationKeySet) BinarySize() (size int) {
+	size = 8 + len(skey.Seed)
+	size += 8
+	size += 8 + 8*len(skey.GaloisElements)
+	size += 8
+	for i := range skey.Value {
+		size += skey.Value[i].BinarySize()
+	}
+	return
+}
+
+// WriteTo writes the object on an io.Writer.
+func (skey SeededEvaluationKeySet) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+
+		if inc, err = buffer.WriteAsUint64[int](w, len(skey.Seed)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		if inc, err = buffer.Write(w, skey.Seed); err != nil {
+			return n + inc, fmt.Errorf("buffer.Write: %w", err)
+		}
+
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[int](w, skey.BaseTwoDecomposition); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		if inc, err = structs.Vector[uint64](skey.GaloisElements).WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("structs.Vector[uint64].WriteTo: %w", err)
+		}
+
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[int](w, len(skey.Value)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		for i := range skey.Value {
+			if inc, err = skey.Value[i].WriteTo(w); err != nil {
+				return n + inc, fmt.Errorf("skey.Value[%d].WriteTo: %w", i, err)
+			}
+
+			n += inc
+		}
+
+		return n, w.Flush()
+
+	default:
+		return skey.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader.
+func (skey *SeededEvaluationKeySet) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+
+		var size int
+		if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		if size != SeedSize {
+			return n, fmt.Errorf("invalid seed size: %d != %d", size, SeedSize)
+		}
+
+		skey.Seed = make([]byte, size)
+
+		if inc, err = buffer.Read(r, skey.Seed); err != nil {
+			return n + inc, fmt.Errorf("buffer.Read: %w", err)
+		}
+
+		n += inc
+
+		if inc, err = buffer.ReadAsUint64[int](r, &skey.BaseTwoDecomposition); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		galEls := structs.Vector[uint64]{}
+		if inc, err = galEls.ReadFrom(r); err != nil {
+			return n + inc, fmt.Errorf("structs.Vector[uint64].ReadFrom: %w", err)
+		}
+
+		n += inc
+
+		skey.GaloisElements = galEls
+
+		if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		skey.Value = skey.Value[:0]
+
+		for i := 0; i < size; i++ {
+
+			var v structs.Matrix[ringqp.Poly]
+			if inc, err = v.ReadFrom(r); err != nil {
+				return n + inc, fmt.Errorf("v.ReadFrom: %w", err)
+			}
+
+			n += inc
+
+			skey.Value = append(skey.Value, v)
+		}
+
+		return
+
+	default:
+		return skey.ReadFrom(bufio.NewReader(r))
+	}
+}
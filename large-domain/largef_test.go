@@ -1,13 +1,20 @@
 package largef
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
 	"fmt"
-	"math/big"
+	"math/bits"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/heint"
 	"github.com/tuneinsight/lattigo/v5/utils/sampling"
 )
 
@@ -32,6 +39,63 @@ const (
 	alpha uint64 = max/h + 1
 )
 
+// flagSeed is the seed of the random points of the tests.
+var flagSeed = flag.String("seed", "", "hex encoded seed of the random points of the tests, a random seed is printed if empty")
+
+var (
+	testPRNG     sampling.PRNG
+	testPRNGOnce sync.Once
+	testPRNGMu   sync.Mutex
+)
+
+// randomPoints returns n points sampled uniformly in [0, T) from the PRNG
+// keyed with -seed, so that a failing run can be reproduced.
+func randomPoints(n int, T uint64) (points []uint64) {
+
+	testPRNGOnce.Do(func() {
+
+		seed, err := hex.DecodeString(*flagSeed)
+		if err != nil {
+			panic(fmt.Errorf("invalid -seed: %w", err))
+		}
+
+		if len(seed) == 0 {
+			seed = make([]byte, SeedSize)
+			if _, err = rand.Read(seed); err != nil {
+				panic(err)
+			}
+		}
+
+		fmt.Printf("Points Seed: -seed=%x\n", seed)
+
+		if testPRNG, err = sampling.NewKeyedPRNG(seed); err != nil {
+			panic(err)
+		}
+	})
+
+	testPRNGMu.Lock()
+	defer testPRNGMu.Unlock()
+
+	// Rejection sampling
+	mask := uint64(1)<<bits.Len64(T-1) - 1
+	buf := make([]byte, 8)
+
+	points = make([]uint64, n)
+	for i := range points {
+		for {
+			if _, err := testPRNG.Read(buf); err != nil {
+				panic(err)
+			}
+
+			if points[i] = binary.LittleEndian.Uint64(buf) & mask; points[i] < T {
+				break
+			}
+		}
+	}
+
+	return
+}
+
 // Set of function to combine, functions can be
 // added/removed freely.
 var F = []func(x uint64) (y uint64){
@@ -51,16 +115,10 @@ func TestLargeF(t *testing.T) {
 	client := NewClient(params, T)
 	server := NewServer(params, T)
 
-	maxBigint := new(big.Int).SetUint64(max)
-
 	// Creates `NbPoints` random points for each function.
 	points := make([][]uint64, len(F))
 	for i := range points {
-		v := make([]uint64, NbPoints)
-		for j := range v {
-			v[j] = sampling.RandInt(maxBigint).Uint64()
-		}
-		points[i] = v
+		points[i] = randomPoints(NbPoints, max)
 	}
 
 	// Generate the Test Polynomials for split domain [Z_N U Z_N U ... U Z_N >= Z_T]-> [Z_T] (i.e. k=ceil(T/N))
@@ -80,8 +138,8 @@ func TestLargeF(t *testing.T) {
 		}
 	})
 
-	fmt.Printf("Query Size: tot: %d MB - point: %d KB\n", (len(ctPoints)*NbPoints*len(ctPoints[0][0])*ctPoints[0][0][0].BinarySize())>>20, len(ctPoints)*len(ctPoints[0][0])*ctPoints[0][0][0].BinarySize()>>10)
-	fmt.Printf("Evaluation Keys Size: %d KB\n", client.MemEvaluationKeySet.BinarySize()>>10)
+	fmt.Printf("Query Size: tot: %d MB - point: %d KB\n", (len(ctPoints)*NbPoints*len(ctPoints[0].Value[0])*ctPoints[0].Value[0][0].BinarySize())>>20, len(ctPoints)*len(ctPoints[0].Value[0])*ctPoints[0].Value[0][0].BinarySize()>>10)
+	fmt.Printf("Evaluation Keys Size: %d KB\n", client.EvaluationKeysBinarySize()>>10)
 
 	// Server evaluation of G(xi, yi, ...)
 	var finalG *rlwe.Ciphertext
@@ -137,6 +195,175 @@ func TestLargeF(t *testing.T) {
 	}
 }
 
+func TestKeyParameters(t *testing.T) {
+
+	params, err := GetParameters()
+	require.NoError(t, err)
+
+	paramsHybrid, err := GetParametersHybrid()
+	require.NoError(t, err)
+
+	nbPoints := 16
+
+	seed := make([]byte, SeedSize)
+
+	testCases := []struct {
+		name   string
+		params heint.Parameters
+		kp     KeyParameters
+		seed   []byte
+	}{
+		{"Default", params, KeyParameters{}, nil},
+		{"Sparse", params, KeyParameters{NbPoints: nbPoints}, nil},
+		{"Seeded", params, KeyParameters{Seeded: true}, nil},
+		{"Hybrid", paramsHybrid, KeyParameters{Hybrid: true}, nil},
+		{"Seeded/Sparse/Hybrid", paramsHybrid, KeyParameters{NbPoints: nbPoints, Seeded: true, Hybrid: true}, nil},
+		{"FromSeed/Seeded/Sparse", params, KeyParameters{NbPoints: nbPoints, Seeded: true}, seed},
+		{"FromSeed/Seeded/Sparse/Hybrid", paramsHybrid, KeyParameters{NbPoints: nbPoints, Seeded: true, Hybrid: true}, seed},
+	}
+
+	for _, tc := range testCases {
+
+		t.Run(tc.name, func(t *testing.T) {
+
+			var client *Client
+			if tc.seed != nil {
+				client, err = NewClientFromSeed(tc.params, T, tc.seed, tc.kp)
+				require.NoError(t, err)
+			} else {
+				client = NewClient(tc.params, T, tc.kp)
+			}
+
+			server := NewServer(tc.params, T)
+
+			evk := client.MemEvaluationKeySet
+
+			if tc.kp.Seeded {
+
+				// Upload of the compressed keys
+				data := &bytes.Buffer{}
+				_, err := client.SeededEvaluationKeySet.WriteTo(data)
+				require.NoError(t, err)
+				require.Equal(t, client.SeededEvaluationKeySet.BinarySize(), data.Len())
+
+				sevk := &SeededEvaluationKeySet{}
+				_, err = sevk.ReadFrom(data)
+				require.NoError(t, err)
+
+				evk, err = sevk.Expand(tc.params)
+				require.NoError(t, err)
+
+				for _, galEl := range client.MemEvaluationKeySet.GetGaloisKeysList() {
+					want, err := client.MemEvaluationKeySet.GetGaloisKey(galEl)
+					require.NoError(t, err)
+					have, err := evk.GetGaloisKey(galEl)
+					require.NoError(t, err)
+					require.True(t, want.Equal(have))
+				}
+			}
+
+			fmt.Printf("%s: #Galois Keys: %d - Evaluation Keys Size: %d KB\n", tc.name, len(evk.GetGaloisKeysList()), client.EvaluationKeysBinarySize()>>10)
+
+			F0 := server.GenTestPolynomials(F[0], T)
+
+			x := randomPoints(nbPoints, max)
+
+			v := client.Decrypt(server.Evaluate([]Points{client.Encrypt(x)}, []TestPoly{F0}, evk))
+
+			for i := range x {
+				require.Equal(t, F[0](x[i]), v[i])
+			}
+		})
+	}
+}
+
+func TestSparsePacking(t *testing.T) {
+
+	params, err := GetParameters()
+	require.NoError(t, err)
+
+	server := NewServer(params, T)
+	F0 := server.GenTestPolynomials(F[0], T)
+
+	for _, nbPoints := range []int{1, 16, 256} {
+
+		t.Run(fmt.Sprintf("NbPoints=%d", nbPoints), func(t *testing.T) {
+
+			client := NewClient(params, T, KeyParameters{NbPoints: nbPoints})
+
+			x := randomPoints(nbPoints, max)
+
+			ctX := client.Encrypt(x)
+
+			var ct *rlwe.Ciphertext
+			runTimed(fmt.Sprintf("Server Evaluation (%d points)", nbPoints), func() {
+				ct = server.Evaluate([]Points{ctX}, []TestPoly{F0}, client.MemEvaluationKeySet)
+			})
+
+			v := client.Decrypt(ct)
+
+			// Smallest power of two greater or equal to nbPoints
+			slots := 1
+			for slots < nbPoints {
+				slots <<= 1
+			}
+
+			require.Equal(t, slots, len(v))
+
+			for i := range x {
+				require.Equal(t, F[0](x[i]), v[i])
+			}
+		})
+	}
+
+	t.Run("MissingKeys", func(t *testing.T) {
+		client := NewClient(params, T, KeyParameters{NbPoints: 16})
+		require.Panics(t, func() {
+			server.Evaluate([]Points{client.Encrypt(make([]uint64, 32))}, []TestPoly{F0}, client.MemEvaluationKeySet)
+		})
+	})
+}
+
+func TestMixedDomains(t *testing.T) {
+
+	params, err := GetParameters()
+	require.NoError(t, err)
+
+	nbPoints := 16
+
+	client := NewClient(params, T, KeyParameters{NbPoints: nbPoints})
+	server := NewServer(params, T)
+
+	// F[0] on [0, 2^10) and F[1] on [0, 2^16)
+	domains := []uint64{1 << 10, 1 << 16}
+
+	ptF := make([]TestPoly, len(F))
+	ctPoints := make([]Points, len(F))
+	points := make([][]uint64, len(F))
+
+	for i := range F {
+
+		ptF[i] = server.GenTestPolynomials(F[i], domains[i])
+
+		points[i] = randomPoints(nbPoints, domains[i])
+
+		ctPoints[i] = client.EncryptWithDomain(points[i], domains[i])
+
+		require.Equal(t, (int(domains[i])+params.N()-1)/params.N(), len(ctPoints[i].Value[0]))
+	}
+
+	v := client.Decrypt(server.Evaluate(ctPoints, ptF, client.MemEvaluationKeySet))
+
+	for i := 0; i < nbPoints; i++ {
+		require.Equal(t, (F[0](points[0][i])+F[1](points[1][i]))%params.PlaintextModulus(), v[i])
+	}
+
+	// Points and test polynomials of different domains
+	require.Panics(t, func() {
+		server.Evaluate([]Points{ctPoints[1], ctPoints[0]}, ptF, client.MemEvaluationKeySet)
+	})
+}
+
 func runTimed(op string, f func()) {
 	now := time.Now()
 	fmt.Printf("%s: ", op)
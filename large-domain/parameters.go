@@ -17,6 +17,13 @@ const (
 	// BaseTwoDecomposition is the power of two decomposition
 	// of the evaluation keys.
 	BaseTwoDecomposition = 14
+
+	// LogNHybrid is the ring degree of the parameters with an auxiliary modulus P.
+	// At LogN=11 the 128-bit security bound leaves no room for P.
+	LogNHybrid = 12
+
+	// LogP is the log2(P) of the auxiliary modulus used by the hybrid key-switching.
+	LogP = 55
 )
 
 // GetParameters instantiates a new heint.Parameters.
@@ -31,3 +38,18 @@ func GetParameters() (params heint.Parameters, err error) {
 		PlaintextModulus: PlaintextModulus, // Plaintext modulus, should be >= Function Domain
 	})
 }
+
+// GetParametersHybrid instantiates a new heint.Parameters with an auxiliary
+// modulus P, to be used with KeyParameters.Hybrid.
+func GetParametersHybrid() (params heint.Parameters, err error) {
+	// N=4096 & Log(QP) = 54 + 55
+	// Default error distribution: discrete Gaussian with sigma=3.2 bounded by ceil(6*sigma)
+	// Default secret distribution: uniform ternary
+	// Security: 128-bit
+	return heint.NewParametersFromLiteral(heint.ParametersLiteral{
+		LogN:             LogNHybrid,       //Log2 of the ring degree
+		LogQ:             []int{LogQ},      // Bit-size of the primes moduli
+		LogP:             []int{LogP},      // Bit-size of the auxiliary primes moduli
+		PlaintextModulus: PlaintextModulus, // Plaintext modulus, should be >= Function Domain
+	})
+}
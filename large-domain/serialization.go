@@ -0,0 +1,295 @@
+package largef
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/ring"
+	"github.com/tuneinsight/lattigo/v5/utils/buffer"
+)
+
+// maxStringSize is the maximum size in bytes of the strings read by readString.
+const maxStringSize = 1 << 10
+
+// BinarySize returns the serialized size of the object in bytes.
+func (p Points) BinarySize() (size int) {
+	size = 8
+	size += 8
+	for i := range p.Value {
+		size += 8
+		for j := range p.Value[i] {
+			size += ciphertextBinarySize(p.Value[i][j])
+		}
+	}
+	return
+}
+
+// WriteTo writes the object on an io.Writer.
+func (p Points) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+
+		if inc, err = buffer.WriteUint64(w, p.T); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteUint64: %w", err)
+		}
+
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[int](w, len(p.Value)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		for i := range p.Value {
+
+			if inc, err = buffer.WriteAsUint64[int](w, len(p.Value[i])); err != nil {
+				return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+			}
+
+			n += inc
+
+			for j := range p.Value[i] {
+				if inc, err = writeCiphertext(w, p.Value[i][j]); err != nil {
+					return n + inc, fmt.Errorf("writeCiphertext: %w", err)
+				}
+
+				n += inc
+			}
+		}
+
+		return n, w.Flush()
+
+	default:
+		return p.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader.
+// Slices are grown as elements are read, so that a corrupted
+// length prefix cannot trigger a large allocation by itself.
+func (p *Points) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+
+		if inc, err = buffer.ReadUint64(r, &p.T); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadUint64: %w", err)
+		}
+
+		n += inc
+
+		var size int
+		if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		p.Value = p.Value[:0]
+
+		for i := 0; i < size; i++ {
+
+			var sizei int
+			if inc, err = buffer.ReadAsUint64[int](r, &sizei); err != nil {
+				return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+			}
+
+			n += inc
+
+			var pi []*rlwe.Ciphertext
+
+			for j := 0; j < sizei; j++ {
+
+				ct := &rlwe.Ciphertext{}
+
+				if inc, err = readCiphertext(r, ct); err != nil {
+					return n + inc, fmt.Errorf("readCiphertext: %w", err)
+				}
+
+				n += inc
+
+				pi = append(pi, ct)
+			}
+
+			p.Value = append(p.Value, pi)
+		}
+
+		return
+
+	default:
+		return p.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// ciphertextBinarySize returns the size in bytes of a ciphertext serialized with writeCiphertext.
+func ciphertextBinarySize(ct *rlwe.Ciphertext) (size int) {
+	scale, _ := ct.Scale.MarshalJSON()
+	return 1 + 2 + 8 + len(scale) + ct.Value.BinarySize()
+}
+
+// writeCiphertext writes a ciphertext on w.
+// The rlwe.MetaData is written in a compact form, since its
+// JSON encoding cannot be read back without knowing its size.
+func writeCiphertext(w buffer.Writer, ct *rlwe.Ciphertext) (n int64, err error) {
+
+	var flags uint8
+	if ct.IsNTT {
+		flags |= 1
+	}
+
+	if ct.IsMontgomery {
+		flags |= 2
+	}
+
+	if ct.IsBatched {
+		flags |= 4
+	}
+
+	var inc int64
+	if inc, err = buffer.WriteUint8(w, flags); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	if inc, err = buffer.WriteUint8(w, uint8(ct.LogDimensions.Rows)); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	if inc, err = buffer.WriteUint8(w, uint8(ct.LogDimensions.Cols)); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	var scale []byte
+	if scale, err = ct.Scale.MarshalJSON(); err != nil {
+		return n, err
+	}
+
+	if inc, err = buffer.WriteAsUint64[int](w, len(scale)); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	if inc, err = buffer.Write(w, scale); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	inc, err = ct.Value.WriteTo(w)
+
+	return n + inc, err
+}
+
+// readCiphertext reads a ciphertext written with writeCiphertext on ct.
+func readCiphertext(r buffer.Reader, ct *rlwe.Ciphertext) (n int64, err error) {
+
+	if ct.MetaData == nil {
+		ct.MetaData = &rlwe.MetaData{}
+	}
+
+	var inc int64
+
+	var flags uint8
+	if inc, err = buffer.ReadUint8(r, &flags); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	ct.IsNTT = flags&1 == 1
+	ct.IsMontgomery = flags&2 == 2
+	ct.IsBatched = flags&4 == 4
+
+	var rows, cols uint8
+	if inc, err = buffer.ReadUint8(r, &rows); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	if inc, err = buffer.ReadUint8(r, &cols); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	ct.LogDimensions = ring.Dimensions{Rows: int(rows), Cols: int(cols)}
+
+	var size int
+	if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	if size < 0 || size > maxStringSize {
+		return n, fmt.Errorf("invalid scale size: %d", size)
+	}
+
+	scale := make([]byte, size)
+	if inc, err = buffer.Read(r, scale); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	if err = ct.Scale.UnmarshalJSON(scale); err != nil {
+		return n, err
+	}
+
+	inc, err = ct.Value.ReadFrom(r)
+
+	return n + inc, err
+}
+
+func writeString(w buffer.Writer, s string) (n int64, err error) {
+
+	var inc int64
+	if inc, err = buffer.WriteAsUint64[int](w, len(s)); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	inc, err = buffer.Write(w, []byte(s))
+
+	return n + inc, err
+}
+
+func readString(r buffer.Reader, s *string) (n int64, err error) {
+
+	var size int
+
+	var inc int64
+	if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+		return n + inc, err
+	}
+
+	n += inc
+
+	if size < 0 || size > maxStringSize {
+		return n, fmt.Errorf("invalid string size: %d > %d", size, maxStringSize)
+	}
+
+	b := make([]byte, size)
+
+	if inc, err = buffer.Read(r, b); err != nil {
+		return n + inc, err
+	}
+
+	*s = string(b)
+
+	return n + inc, nil
+}
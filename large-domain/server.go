@@ -1,8 +1,13 @@
 package largef
 
 import (
+	"fmt"
+	"math/bits"
+
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
 	"github.com/tuneinsight/lattigo/v5/he/heint"
+	"github.com/tuneinsight/lattigo/v5/ring"
+	"github.com/tuneinsight/lattigo/v5/utils/sampling"
 )
 
 // Server is a struct storing the necessary elements
@@ -12,6 +17,9 @@ type Server struct {
 	heint.Parameters
 	*heint.Evaluator
 	*heint.Encoder
+
+	// prng is the source of randomness of the masks, see WithPRNG.
+	prng sampling.PRNG
 }
 
 // NewServer instantiates a new server.
@@ -24,7 +32,33 @@ func NewServer(params heint.Parameters, T uint64) *Server {
 	}
 }
 
-// Evaluate evaluates the test polynomials on a set of encrypted points.
+// ShallowCopy creates a shallow copy of the server in which the read-only data-structures are
+// shared with the receiver. The returned server can be used concurrently with the receiver.
+// The returned server always samples fresh masks, see WithPRNG.
+func (s Server) ShallowCopy() *Server {
+	return &Server{
+		T:          s.T,
+		Parameters: s.Parameters,
+		Evaluator:  s.Evaluator.ShallowCopy(),
+		Encoder:    s.Encoder.ShallowCopy(),
+	}
+}
+
+// WithPRNG returns a shallow copy of the server which samples the masks of the unused
+// coefficients of the results of Evaluate from prng instead of a fresh PRNG, which makes
+// the evaluation deterministic. The returned server is not safe to use concurrently with the receiver.
+func (s Server) WithPRNG(prng sampling.PRNG) *Server {
+	cpy := s
+	cpy.prng = prng
+	return &cpy
+}
+
+// Evaluate evaluates the test polynomials on a set of encrypted points,
+// i.e. f_0(ctXi[0][i]) + f_1(ctXi[1][i]) + ..., where ctXi[k] and ptU[k] must
+// have the same domain T, which can differ between functions.
+// The result of the i-th point is stored in the i-th coefficient of the returned
+// ciphertext, whose LogDimensions.Cols is the log2 of the smallest power of two
+// greater or equal to the number of points.
 func (s Server) Evaluate(ctXi []Points, ptU []TestPoly, evk rlwe.EvaluationKeySet) (final *rlwe.Ciphertext) {
 
 	params := s.Parameters
@@ -32,9 +66,39 @@ func (s Server) Evaluate(ctXi []Points, ptU []TestPoly, evk rlwe.EvaluationKeySe
 
 	var err error
 
+	if len(ctXi) == 0 || len(ctXi) != len(ptU) {
+		panic(fmt.Errorf("#points=%d but #test polynomials=%d", len(ctXi), len(ptU)))
+	}
+
+	nbPoints := ctXi[0].Len()
+
+	if nbPoints == 0 {
+		panic(fmt.Errorf("#points cannot be zero"))
+	}
+
+	// Each function has its own domain [0, T), hence its own number of split
+	// polynomials, and the points of the k-th function must be encrypted
+	// with the same split.
+	for k := range ctXi {
+
+		if ctXi[k].Len() != nbPoints {
+			panic(fmt.Errorf("ctXi[%d] has %d points but ctXi[0] has %d", k, ctXi[k].Len(), nbPoints))
+		}
+
+		if ctXi[k].T != ptU[k].T {
+			panic(fmt.Errorf("ctXi[%d] has domain T=%d but ptU[%d] has domain T=%d", k, ctXi[k].T, k, ptU[k].T))
+		}
+
+		for i := range ctXi[k].Value {
+			if len(ctXi[k].Value[i]) != len(ptU[k].Value) {
+				panic(fmt.Errorf("ctXi[%d][%d] has %d ciphertexts but ptU[%d] has %d polynomials", k, i, len(ctXi[k].Value[i]), k, len(ptU[k].Value)))
+			}
+		}
+	}
+
 	// Evaluate u x Enc(X^i) -> Enc(f(i)) by summation over the split domains
 	res := make(map[int]*rlwe.Ciphertext)
-	for i := range ctXi[0] {
+	for i := 0; i < nbPoints; i++ {
 		res[i] = heint.NewCiphertext(params, 1, params.MaxLevel())
 		res[i].IsBatched = false
 	}
@@ -43,8 +107,8 @@ func (s Server) Evaluate(ctXi []Points, ptU []TestPoly, evk rlwe.EvaluationKeySe
 
 	for k := range ctXi {
 
-		ctXik := ctXi[k]
-		ptUk := ptU[k]
+		ctXik := ctXi[k].Value
+		ptUk := ptU[k].Value
 
 		for i := range ctXik {
 
@@ -68,14 +132,61 @@ func (s Server) Evaluate(ctXi []Points, ptU []TestPoly, evk rlwe.EvaluationKeySe
 		}
 	}
 
+	// The points are packed on the first 2^{logGap} coefficients, with
+	// 2^{logGap} the smallest power of two greater or equal to #points.
+	logGap := bits.Len64(uint64(len(res) - 1))
+
 	if len(res) > 1 {
+
+		// Only the last logGap steps of the repacking are needed to pack the
+		// points, which is also the number of Galois keys needed.
+		for _, galEl := range GaloisElementsForEvaluate(params, len(res)) {
+			if _, err = evk.GetGaloisKey(galEl); err != nil {
+				panic(fmt.Errorf("missing Galois key to repack %d points: %w", len(res), err))
+			}
+		}
+
 		// Pack all Enc(f(i)) into a single RLWE ciphertext
-		if final, err = eval.Pack(res, params.LogN(), false); err != nil {
+		if final, err = eval.Pack(res, logGap, false); err != nil {
 			panic(err)
 		}
 	} else {
 		final = res[0]
 	}
 
+	// Unless #points = N, the coefficients which do not store a point still
+	// store evaluations of the functions on other inputs, so they are masked
+	// with uniform values mod T.
+	if len(res) < params.N() {
+
+		prng := s.prng
+		if prng == nil {
+			if prng, err = sampling.NewPRNG(); err != nil {
+				panic(err)
+			}
+		}
+
+		mask := params.RingT().NewPoly()
+		ring.NewUniformSampler(prng, params.RingT()).Read(mask)
+
+		m := mask.Coeffs[0]
+		for i := range res {
+			m[i] = 0
+		}
+
+		pt := heint.NewPlaintext(params, final.Level())
+		pt.IsBatched = false
+		pt.Scale = final.Scale
+		if err = s.Encoder.Encode(m, pt); err != nil {
+			panic(err)
+		}
+
+		if err = eval.Add(final, pt, final); err != nil {
+			panic(err)
+		}
+	}
+
+	final.LogDimensions = ring.Dimensions{Rows: 0, Cols: logGap}
+
 	return
 }
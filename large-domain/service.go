@@ -0,0 +1,593 @@
+package largef
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/heint"
+	"github.com/tuneinsight/lattigo/v5/utils"
+	"github.com/tuneinsight/lattigo/v5/utils/buffer"
+)
+
+const (
+	// RouteKeys is the route on which clients register their evaluation keys.
+	RouteKeys = "/keys"
+
+	// RouteSeededKeys is the route on which clients register their evaluation keys
+	// as a SeededEvaluationKeySet.
+	RouteSeededKeys = "/keys/seeded"
+
+	// RouteFunctions is the route listing the sorted names of the function tables.
+	RouteFunctions = "/functions"
+
+	// RouteFunctionsV2 is the route returning the domain of each function table, indexed by name.
+	RouteFunctionsV2 = "/v2/functions"
+
+	// RouteEvaluate is the route on which clients submit their queries.
+	RouteEvaluate = "/evaluate"
+
+	// DefaultMaxKeySize is the default maximum size in bytes of a registered evaluation key set.
+	DefaultMaxKeySize = 1 << 26
+
+	// DefaultMaxQueryCiphertexts is the default maximum number of ciphertexts of a query,
+	// from which the default maximum size in bytes of a query is derived, see ServiceParameters.
+	// A query of n points for a function with T = 2^15 has 16n ciphertexts.
+	DefaultMaxQueryCiphertexts = 1 << 10
+
+	// DefaultMaxKeys is the default maximum number of registered evaluation key sets.
+	DefaultMaxKeys = 64
+
+	// DefaultKeyTTL is the default lifetime of a registered evaluation key set.
+	DefaultKeyTTL = time.Hour
+)
+
+// ErrUnknownKey is returned when a query references an evaluation key set that was not registered.
+var ErrUnknownKey = errors.New("unknown key ID")
+
+// ErrTooManyKeys is returned when an evaluation key set is registered while the service
+// already stores its maximum number of key sets.
+var ErrTooManyKeys = errors.New("too many registered keys")
+
+// Query is a struct storing a client request: the encrypted points Points[i]
+// are evaluated on the function table Functions[i] and the results are summed.
+type Query struct {
+	KeyID     string
+	Functions []string
+	Points    []Points
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (q Query) BinarySize() (size int) {
+	size = 8 + len(q.KeyID)
+	size += 8
+	for i := range q.Functions {
+		size += 8 + len(q.Functions[i])
+	}
+	size += 8
+	for i := range q.Points {
+		size += q.Points[i].BinarySize()
+	}
+	return
+}
+
+// WriteTo writes the object on an io.Writer.
+func (q Query) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+
+		if inc, err = writeString(w, q.KeyID); err != nil {
+			return n + inc, fmt.Errorf("writeString: %w", err)
+		}
+
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[int](w, len(q.Functions)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		for i := range q.Functions {
+			if inc, err = writeString(w, q.Functions[i]); err != nil {
+				return n + inc, fmt.Errorf("writeString: %w", err)
+			}
+
+			n += inc
+		}
+
+		if inc, err = buffer.WriteAsUint64[int](w, len(q.Points)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		for i := range q.Points {
+			if inc, err = q.Points[i].WriteTo(w); err != nil {
+				return n + inc, fmt.Errorf("q.Points[%d].WriteTo: %w", i, err)
+			}
+
+			n += inc
+		}
+
+		return n, w.Flush()
+
+	default:
+		return q.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader.
+func (q *Query) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+
+		if inc, err = readString(r, &q.KeyID); err != nil {
+			return n + inc, fmt.Errorf("readString: %w", err)
+		}
+
+		n += inc
+
+		var size int
+		if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		q.Functions = q.Functions[:0]
+
+		for i := 0; i < size; i++ {
+
+			var name string
+			if inc, err = readString(r, &name); err != nil {
+				return n + inc, fmt.Errorf("readString: %w", err)
+			}
+
+			n += inc
+
+			q.Functions = append(q.Functions, name)
+		}
+
+		if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+
+		n += inc
+
+		q.Points = q.Points[:0]
+
+		for i := 0; i < size; i++ {
+
+			var p Points
+			if inc, err = p.ReadFrom(r); err != nil {
+				return n + inc, fmt.Errorf("p.ReadFrom: %w", err)
+			}
+
+			n += inc
+
+			q.Points = append(q.Points, p)
+		}
+
+		return
+
+	default:
+		return q.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// ServiceParameters is a struct storing the limits of a Service.
+// Zero values are replaced by their default.
+type ServiceParameters struct {
+	// MaxKeySize is the maximum size in bytes of a registered evaluation key set.
+	MaxKeySize int64
+	// MaxQuerySize is the maximum size in bytes of a query, which is buffered in memory.
+	// Defaults to the size of DefaultMaxQueryCiphertexts ciphertexts of the parameters of the server.
+	MaxQuerySize int64
+	// MaxKeys is the maximum number of registered evaluation key sets, beyond which
+	// the registration of new key sets fails with ErrTooManyKeys. Defaults to DefaultMaxKeys.
+	MaxKeys int
+	// KeyTTL is the lifetime of a registered evaluation key set,
+	// after which it is evicted. Defaults to DefaultKeyTTL.
+	KeyTTL time.Duration
+	// MaxConcurrentQueries is the number of queries evaluated concurrently,
+	// additional queries wait for a free evaluator. Defaults to runtime.NumCPU().
+	MaxConcurrentQueries int
+}
+
+// Service is a struct exposing a Server and a set of named
+// function tables over HTTP. It implements http.Handler.
+type Service struct {
+	ServiceParameters
+	Server *Server
+
+	servers chan *Server
+
+	mu        sync.RWMutex
+	keys      map[string]registeredKeys
+	functions map[string]TestPoly
+}
+
+// registeredKeys is a registered evaluation key set and its expiration time.
+type registeredKeys struct {
+	*rlwe.MemEvaluationKeySet
+	expires time.Time
+}
+
+// NewService instantiates a new Service and generates the test polynomials of
+// the named functions over the domain [0, server.T).
+// Functions over other domains can be added with RegisterFunction.
+func NewService(server *Server, functions map[string]func(x uint64) (y uint64), sp ServiceParameters) *Service {
+
+	if sp.MaxKeySize == 0 {
+		sp.MaxKeySize = DefaultMaxKeySize
+	}
+
+	if sp.MaxQuerySize == 0 {
+		sp.MaxQuerySize = DefaultMaxQuerySize(server.Parameters)
+	}
+
+	if sp.MaxKeys == 0 {
+		sp.MaxKeys = DefaultMaxKeys
+	}
+
+	if sp.KeyTTL == 0 {
+		sp.KeyTTL = DefaultKeyTTL
+	}
+
+	if sp.MaxConcurrentQueries == 0 {
+		sp.MaxConcurrentQueries = runtime.NumCPU()
+	}
+
+	// Each server has its own buffers, which enables concurrent evaluation
+	servers := make(chan *Server, sp.MaxConcurrentQueries)
+	for i := 0; i < sp.MaxConcurrentQueries; i++ {
+		servers <- server.ShallowCopy()
+	}
+
+	s := &Service{
+		ServiceParameters: sp,
+		Server:            server,
+		servers:           servers,
+		keys:              map[string]registeredKeys{},
+		functions:         map[string]TestPoly{},
+	}
+
+	for _, name := range utils.GetSortedKeys(functions) {
+		if err := s.RegisterFunction(name, functions[name], server.T); err != nil {
+			panic(err)
+		}
+	}
+
+	return s
+}
+
+// RegisterFunction generates the test polynomials of f over the domain [0, T)
+// and stores them under the given name, replacing any previous function of that name.
+func (s *Service) RegisterFunction(name string, f func(x uint64) (y uint64), T uint64) (err error) {
+
+	if len(name) > maxStringSize {
+		return fmt.Errorf("invalid function name: size %d > %d", len(name), maxStringSize)
+	}
+
+	if T == 0 || T > s.Server.PlaintextModulus() {
+		return fmt.Errorf("invalid domain: T=%d must be in [1, %d]", T, s.Server.PlaintextModulus())
+	}
+
+	// The encoder of the server is not thread-safe
+	ptU := s.Server.ShallowCopy().GenTestPolynomials(f, T)
+
+	s.mu.Lock()
+	s.functions[name] = ptU
+	s.mu.Unlock()
+
+	return
+}
+
+// Functions returns the domain T of each function table, indexed by name.
+func (s *Service) Functions() (domains map[string]uint64) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	domains = make(map[string]uint64, len(s.functions))
+	for name, ptU := range s.functions {
+		domains[name] = ptU.T
+	}
+
+	return
+}
+
+// DefaultMaxQuerySize returns the default maximum size in bytes of a query: the size of
+// DefaultMaxQueryCiphertexts ciphertexts of the given parameters, and of their headers.
+func DefaultMaxQuerySize(params heint.Parameters) int64 {
+	ct := ciphertextBinarySize(heint.NewCiphertext(params, 1, params.MaxLevel()))
+	return int64(DefaultMaxQueryCiphertexts)*int64(ct+8) + 1<<16
+}
+
+// RegisterKeys stores an evaluation key set for ServiceParameters.KeyTTL and returns its key ID.
+// It returns ErrTooManyKeys if ServiceParameters.MaxKeys key sets are already registered.
+func (s *Service) RegisterKeys(evk *rlwe.MemEvaluationKeySet) (id string, err error) {
+
+	if evk == nil {
+		return "", fmt.Errorf("evaluation key set cannot be nil")
+	}
+
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return "", fmt.Errorf("rand.Read: %w", err)
+	}
+
+	id = hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	for k, keys := range s.keys {
+		if now.After(keys.expires) {
+			delete(s.keys, k)
+		}
+	}
+
+	if len(s.keys) >= s.MaxKeys {
+		return "", ErrTooManyKeys
+	}
+
+	s.keys[id] = registeredKeys{MemEvaluationKeySet: evk, expires: now.Add(s.KeyTTL)}
+
+	return
+}
+
+// Evaluate checks the query and evaluates it with the registered evaluation keys.
+func (s *Service) Evaluate(q Query) (ct *rlwe.Ciphertext, err error) {
+
+	evk, ptF, err := s.lookup(q)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.checkPoints(q.Points, ptF); err != nil {
+		return nil, err
+	}
+
+	server := <-s.servers
+	defer func() { s.servers <- server }()
+
+	// The evaluation panics on invalid inputs (e.g. missing Galois keys)
+	defer func() {
+		if r := recover(); r != nil {
+			ct = nil
+			err = fmt.Errorf("server.Evaluate: %v", r)
+		}
+	}()
+
+	return server.Evaluate(q.Points, ptF, evk), nil
+}
+
+// lookup returns the evaluation keys and the test polynomials referenced by the query.
+func (s *Service) lookup(q Query) (evk *rlwe.MemEvaluationKeySet, ptF []TestPoly, err error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys, ok := s.keys[q.KeyID]
+	if !ok || time.Now().After(keys.expires) {
+		return nil, nil, ErrUnknownKey
+	}
+
+	evk = keys.MemEvaluationKeySet
+
+	ptF = make([]TestPoly, len(q.Functions))
+	for i, name := range q.Functions {
+		if ptF[i], ok = s.functions[name]; !ok {
+			return nil, nil, fmt.Errorf("unknown function %q", name)
+		}
+	}
+
+	return
+}
+
+// checkPoints checks that the points are well formed for the parameters of the service
+// and have the same domain as the function they are evaluated on.
+func (s *Service) checkPoints(points []Points, ptF []TestPoly) (err error) {
+
+	params := s.Server.Parameters
+	N := params.N()
+
+	if len(ptF) == 0 || len(points) != len(ptF) {
+		return fmt.Errorf("invalid query: #functions=%d but #points=%d", len(ptF), len(points))
+	}
+
+	nbPoints := points[0].Len()
+
+	if nbPoints == 0 || nbPoints > N {
+		return fmt.Errorf("invalid query: #points=%d must be in [1, %d]", nbPoints, N)
+	}
+
+	for i := range points {
+
+		if points[i].T != ptF[i].T {
+			return fmt.Errorf("invalid query: points[%d] has domain T=%d but the function has domain T=%d", i, points[i].T, ptF[i].T)
+		}
+
+		if points[i].Len() != nbPoints {
+			return fmt.Errorf("invalid query: points[%d] has %d points but points[0] has %d", i, points[i].Len(), nbPoints)
+		}
+
+		split := len(ptF[i].Value)
+
+		for j := range points[i].Value {
+
+			if len(points[i].Value[j]) != split {
+				return fmt.Errorf("invalid query: points[%d][%d] has %d ciphertexts but want %d", i, j, len(points[i].Value[j]), split)
+			}
+
+			for _, ct := range points[i].Value[j] {
+				if ct.MetaData == nil || !ct.IsNTT || ct.Degree() != 1 || ct.Level() != params.MaxLevel() || ct.Value[0].N() != N || ct.Value[1].N() != N {
+					return fmt.Errorf("invalid query: points[%d][%d] has a malformed ciphertext", i, j)
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case RouteKeys:
+		s.handleKeys(w, r)
+	case RouteSeededKeys:
+		s.handleSeededKeys(w, r)
+	case RouteFunctions:
+		s.handleFunctions(w, r, false)
+	case RouteFunctionsV2:
+		s.handleFunctions(w, r, true)
+	case RouteEvaluate:
+		s.handleEvaluate(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Service) handleKeys(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	evk := &rlwe.MemEvaluationKeySet{}
+	if _, err := evk.ReadFrom(http.MaxBytesReader(w, r.Body, s.MaxKeySize)); err != nil {
+		httpError(w, fmt.Errorf("evk.ReadFrom: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.RegisterKeys(evk)
+	if err != nil {
+		httpError(w, err, registerKeysStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, id)
+}
+
+// registerKeysStatus returns the HTTP status of an error of Service.RegisterKeys.
+func registerKeysStatus(err error) int {
+	if errors.Is(err, ErrTooManyKeys) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *Service) handleSeededKeys(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sevk := &SeededEvaluationKeySet{}
+	if _, err := sevk.ReadFrom(http.MaxBytesReader(w, r.Body, s.MaxKeySize)); err != nil {
+		httpError(w, fmt.Errorf("sevk.ReadFrom: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	evk, err := sevk.Expand(s.Server.Parameters)
+	if err != nil {
+		httpError(w, fmt.Errorf("sevk.Expand: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.RegisterKeys(evk)
+	if err != nil {
+		httpError(w, err, registerKeysStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, id)
+}
+
+// handleFunctions writes the sorted names of the function tables, or their domains if withDomains.
+func (s *Service) handleFunctions(w http.ResponseWriter, r *http.Request, withDomains bool) {
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var v interface{} = s.Functions()
+	if !withDomains {
+		v = utils.GetSortedKeys(s.Functions())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+	}
+}
+
+func (s *Service) handleEvaluate(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var q Query
+	if _, err := q.ReadFrom(http.MaxBytesReader(w, r.Body, s.MaxQuerySize)); err != nil {
+		httpError(w, fmt.Errorf("q.ReadFrom: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	ct, err := s.Evaluate(q)
+
+	switch {
+	case errors.Is(err, ErrUnknownKey):
+		httpError(w, err, http.StatusNotFound)
+		return
+	case err != nil:
+		httpError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	buf := bufio.NewWriter(w)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err = writeCiphertext(buf, ct); err != nil {
+		httpError(w, fmt.Errorf("writeCiphertext: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err = buf.Flush(); err != nil {
+		httpError(w, fmt.Errorf("buf.Flush: %w", err), http.StatusInternalServerError)
+	}
+}
+
+// httpError replies with the error, using http.StatusRequestEntityTooLarge
+// if the request body exceeded its limit.
+func httpError(w http.ResponseWriter, err error, code int) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		code = http.StatusRequestEntityTooLarge
+	}
+	http.Error(w, err.Error(), code)
+}
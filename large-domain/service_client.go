@@ -0,0 +1,186 @@
+package largef
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/utils"
+	"github.com/tuneinsight/lattigo/v5/utils/buffer"
+)
+
+// RemoteClient is a struct wrapping a Client to
+// query a Service over HTTP. A RemoteClient is not safe
+// for concurrent use, see Client.ShallowCopy.
+type RemoteClient struct {
+	*Client
+	URL        string
+	HTTPClient *http.Client
+	KeyID      string
+
+	// Domains is the domain T of each function of the service, as returned by
+	// Functions. Points of functions without domain are encrypted with Client.T.
+	Domains map[string]uint64
+}
+
+// NewRemoteClient instantiates a new RemoteClient for the service at the given URL.
+func NewRemoteClient(client *Client, url string) *RemoteClient {
+	return &RemoteClient{
+		Client:     client,
+		URL:        strings.TrimSuffix(url, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// RegisterKeys uploads the evaluation keys of the client to the service
+// and stores the returned key ID. The SeededEvaluationKeySet is uploaded
+// instead of the MemEvaluationKeySet if the client has one.
+func (c *RemoteClient) RegisterKeys() (err error) {
+
+	body := &bytes.Buffer{}
+
+	var resp []byte
+	if c.SeededEvaluationKeySet != nil {
+
+		if _, err = c.SeededEvaluationKeySet.WriteTo(body); err != nil {
+			return fmt.Errorf("c.SeededEvaluationKeySet.WriteTo: %w", err)
+		}
+
+		if resp, err = c.post(RouteSeededKeys, body); err != nil {
+			return err
+		}
+
+	} else {
+
+		if _, err = c.MemEvaluationKeySet.WriteTo(body); err != nil {
+			return fmt.Errorf("c.MemEvaluationKeySet.WriteTo: %w", err)
+		}
+
+		if resp, err = c.post(RouteKeys, body); err != nil {
+			return err
+		}
+	}
+
+	c.KeyID = string(resp)
+
+	return
+}
+
+// Functions returns the sorted names of the function tables of the service
+// and stores their domains in c.Domains.
+func (c *RemoteClient) Functions() (functions []string, err error) {
+
+	resp, err := c.HTTPClient.Get(c.URL + RouteFunctionsV2)
+	if err != nil {
+		return nil, fmt.Errorf("c.HTTPClient.Get: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	domains := map[string]uint64{}
+	if err = json.NewDecoder(resp.Body).Decode(&domains); err != nil {
+		return nil, fmt.Errorf("json.Decode: %w", err)
+	}
+
+	c.Domains = domains
+
+	return utils.GetSortedKeys(domains), nil
+}
+
+// Evaluate encrypts the points, evaluates them on the service and decrypts the result, i.e.
+// v[i] = functions[0](points[0][i]) + functions[1](points[1][i]) + ... mod PlaintextModulus.
+func (c RemoteClient) Evaluate(functions []string, points [][]uint64) (v []uint64, err error) {
+
+	if len(functions) != len(points) {
+		return nil, fmt.Errorf("#functions=%d but #points=%d", len(functions), len(points))
+	}
+
+	if len(points) == 0 {
+		return nil, fmt.Errorf("#points cannot be zero")
+	}
+
+	q := Query{
+		KeyID:     c.KeyID,
+		Functions: functions,
+		Points:    make([]Points, len(points)),
+	}
+
+	for i := range points {
+
+		T, ok := c.Domains[functions[i]]
+		if !ok {
+			T = c.T
+		}
+
+		for _, x := range points[i] {
+			if x >= T {
+				return nil, fmt.Errorf("point %d of function %q is not in [0, %d)", x, functions[i], T)
+			}
+		}
+
+		q.Points[i] = c.EncryptWithDomain(points[i], T)
+	}
+
+	body := &bytes.Buffer{}
+	if _, err = q.WriteTo(body); err != nil {
+		return nil, fmt.Errorf("q.WriteTo: %w", err)
+	}
+
+	resp, err := c.post(RouteEvaluate, body)
+	if err != nil {
+		return nil, err
+	}
+
+	ct := &rlwe.Ciphertext{}
+	if _, err = readCiphertext(buffer.NewBuffer(resp), ct); err != nil {
+		return nil, fmt.Errorf("readCiphertext: %w", err)
+	}
+
+	// The response is decrypted with the parameters of the client,
+	// so its shape is checked before to not panic on a malformed response.
+	dims := c.LogMaxDimensions()
+	if ct.Degree() != 1 || ct.Level() > c.MaxLevel() || ct.Value[0].N() != c.N() ||
+		ct.LogDimensions.Rows > dims.Rows || ct.LogDimensions.Cols > dims.Cols {
+		return nil, fmt.Errorf("invalid response: ciphertext does not match the parameters")
+	}
+
+	values := c.Decrypt(ct)
+	if len(values) < len(points[0]) {
+		return nil, fmt.Errorf("invalid response: %d slots for %d points", len(values), len(points[0]))
+	}
+
+	return values[:len(points[0])], nil
+}
+
+func (c RemoteClient) post(route string, body io.Reader) (p []byte, err error) {
+
+	resp, err := c.HTTPClient.Post(c.URL+route, "application/octet-stream", body)
+	if err != nil {
+		return nil, fmt.Errorf("c.HTTPClient.Post: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	if p, err = io.ReadAll(resp.Body); err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+
+	return
+}
+
+func responseError(resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,240 @@
+package largef
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tuneinsight/lattigo/v5/ring"
+)
+
+func TestService(t *testing.T) {
+
+	params, err := GetParameters()
+	require.NoError(t, err)
+
+	// Small number of points to keep the queries small
+	nbPoints := 16
+
+	client := NewClient(params, T)
+	server := NewServer(params, T)
+
+	functions := map[string]func(x uint64) (y uint64){
+		"f0": F[0],
+		"f1": F[1],
+	}
+
+	service := NewService(server, functions, ServiceParameters{MaxConcurrentQueries: 2})
+
+	ts := httptest.NewServer(service)
+	defer ts.Close()
+
+	remote := NewRemoteClient(client, ts.URL)
+	require.NoError(t, remote.RegisterKeys())
+
+	names, err := remote.Functions()
+	require.NoError(t, err)
+	require.Equal(t, []string{"f0", "f1"}, names)
+	require.Equal(t, map[string]uint64{"f0": T, "f1": T}, remote.Domains)
+
+	// The first version of the route lists the names only
+	resp, err := http.Get(ts.URL + RouteFunctions)
+	require.NoError(t, err)
+
+	var list []string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, names, list)
+
+	genPoints := func() (points [][]uint64) {
+		points = make([][]uint64, len(names))
+		for i := range points {
+			points[i] = randomPoints(nbPoints, max)
+		}
+		return
+	}
+
+	t.Run("ConcurrentQueries", func(t *testing.T) {
+
+		keyID := remote.KeyID
+
+		var wg sync.WaitGroup
+
+		for k := 0; k < 3; k++ {
+
+			wg.Add(1)
+
+			go func() {
+
+				defer wg.Done()
+
+				// The encryptor and decryptor are not thread-safe
+				remote := NewRemoteClient(client.ShallowCopy(), ts.URL)
+				remote.KeyID = keyID
+
+				points := genPoints()
+
+				v, err := remote.Evaluate(names, points)
+				assert.NoError(t, err)
+
+				for i := range v {
+					assert.Equal(t, F[0](points[0][i])+F[1](points[1][i]), v[i])
+				}
+			}()
+		}
+
+		wg.Wait()
+	})
+
+	t.Run("SeededKeys", func(t *testing.T) {
+		remote := NewRemoteClient(NewClient(params, T, KeyParameters{NbPoints: nbPoints, Seeded: true}), ts.URL)
+		require.NoError(t, remote.RegisterKeys())
+
+		points := genPoints()
+
+		v, err := remote.Evaluate(names, points)
+		require.NoError(t, err)
+
+		for i := range v {
+			require.Equal(t, F[0](points[0][i])+F[1](points[1][i]), v[i])
+		}
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		other := *remote
+		other.KeyID = "unknown"
+		_, err := other.Evaluate(names, genPoints())
+		require.ErrorContains(t, err, "404")
+	})
+
+	t.Run("UnknownFunction", func(t *testing.T) {
+		_, err := remote.Evaluate([]string{"f0", "f2"}, genPoints())
+		require.ErrorContains(t, err, "400")
+	})
+
+	t.Run("MalformedQuery", func(t *testing.T) {
+		// A point encrypted for a smaller domain has too few ciphertexts
+		small := NewClient(params, uint64(params.N()))
+		q := Query{
+			KeyID:     remote.KeyID,
+			Functions: []string{"f0"},
+			Points:    []Points{{T: T, Value: small.Encrypt([]uint64{0}).Value}},
+		}
+		_, err := service.Evaluate(q)
+		require.Error(t, err)
+	})
+
+	t.Run("MixedDomains", func(t *testing.T) {
+
+		// g is only defined on [0, 2^10)
+		Tg := uint64(1 << 10)
+		require.NoError(t, service.RegisterFunction("g", func(x uint64) (y uint64) { return 3 * x }, Tg))
+
+		names, err := remote.Functions()
+		require.NoError(t, err)
+		require.Equal(t, []string{"f0", "f1", "g"}, names)
+		require.Equal(t, Tg, remote.Domains["g"])
+
+		points := genPoints()
+		for i := range points[1] {
+			points[1][i] %= Tg
+		}
+
+		v, err := remote.Evaluate([]string{"f0", "g"}, points)
+		require.NoError(t, err)
+
+		for i := range v {
+			require.Equal(t, F[0](points[0][i])+3*points[1][i], v[i])
+		}
+
+		// Points of the wrong domain are rejected
+		q := Query{
+			KeyID:     remote.KeyID,
+			Functions: []string{"f0", "g"},
+			Points:    []Points{client.Encrypt(points[0]), client.Encrypt(points[1])},
+		}
+		_, err = service.Evaluate(q)
+		require.ErrorContains(t, err, "domain")
+	})
+
+	t.Run("QueryTooLarge", func(t *testing.T) {
+
+		limited := httptest.NewServer(NewService(server, functions, ServiceParameters{MaxQuerySize: 1 << 20}))
+		defer limited.Close()
+
+		q := Query{
+			KeyID:     remote.KeyID,
+			Functions: names,
+			Points:    []Points{client.Encrypt(genPoints()[0]), client.Encrypt(genPoints()[1])},
+		}
+
+		body := &bytes.Buffer{}
+		_, err := q.WriteTo(body)
+		require.NoError(t, err)
+		require.Equal(t, q.BinarySize(), body.Len())
+
+		resp, err := http.Post(limited.URL+RouteEvaluate, "application/octet-stream", body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("TooManyKeys", func(t *testing.T) {
+
+		limited := NewService(server, functions, ServiceParameters{MaxKeys: 1})
+		ts := httptest.NewServer(limited)
+		defer ts.Close()
+
+		other := NewRemoteClient(client, ts.URL)
+		require.NoError(t, other.RegisterKeys())
+		require.ErrorContains(t, other.RegisterKeys(), "503")
+
+		_, err := limited.RegisterKeys(client.MemEvaluationKeySet)
+		require.ErrorIs(t, err, ErrTooManyKeys)
+	})
+
+	t.Run("ExpiredKeys", func(t *testing.T) {
+
+		expiring := NewService(server, functions, ServiceParameters{MaxKeys: 1, KeyTTL: time.Nanosecond})
+
+		id, err := expiring.RegisterKeys(client.MemEvaluationKeySet)
+		require.NoError(t, err)
+
+		time.Sleep(time.Millisecond)
+
+		_, err = expiring.Evaluate(Query{KeyID: id, Functions: []string{"f0"}, Points: []Points{client.Encrypt(genPoints()[0])}})
+		require.ErrorIs(t, err, ErrUnknownKey)
+
+		// The expired key set is evicted by the next registration
+		_, err = expiring.RegisterKeys(client.MemEvaluationKeySet)
+		require.NoError(t, err)
+	})
+
+	t.Run("MalformedResponse", func(t *testing.T) {
+
+		// The response has fewer slots than the number of points
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ct := client.Encrypt([]uint64{0}).Value[0][0]
+			ct.LogDimensions = ring.Dimensions{Rows: 0, Cols: 2}
+			buf := bufio.NewWriter(w)
+			_, err := writeCiphertext(buf, ct)
+			require.NoError(t, err)
+			require.NoError(t, buf.Flush())
+		}))
+		defer ts.Close()
+
+		other := NewRemoteClient(client, ts.URL)
+		other.KeyID = remote.KeyID
+		other.Domains = remote.Domains
+
+		_, err := other.Evaluate([]string{"f0", "f1"}, genPoints())
+		require.ErrorContains(t, err, "invalid response")
+	})
+}
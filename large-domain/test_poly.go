@@ -4,8 +4,12 @@ import (
 	"github.com/tuneinsight/lattigo/v5/ring"
 )
 
-// TestPoly is a set of polynomials encoding a function f(x) = y mod T.
-type TestPoly []ring.Poly
+// TestPoly is a set of polynomials encoding a function f(x) = y over the domain [0, T).
+// Value is split over ceil(T/N) polynomials.
+type TestPoly struct {
+	T     uint64
+	Value []ring.Poly
+}
 
 // GenTestPolynomials generates a TestPolynomial from a function.
 func (s Server) GenTestPolynomials(f func(x uint64) (y uint64), T uint64) (ptU TestPoly) {
@@ -17,13 +21,16 @@ func (s Server) GenTestPolynomials(f func(x uint64) (y uint64), T uint64) (ptU T
 	PlaintextModulus := params.PlaintextModulus()
 	ringQ := params.RingQ()
 
-	ptU = make([]ring.Poly, (int(T)+N-1)/N)
+	ptU = TestPoly{
+		T:     T,
+		Value: make([]ring.Poly, (int(T)+N-1)/N),
+	}
 
 	// Test polynomial
 	u := params.RingT().NewPoly()
 	coeffs := u.Coeffs[0]
 
-	for i := range ptU {
+	for i := range ptU.Value {
 
 		start := i * N
 		end := start + N
@@ -38,16 +45,16 @@ func (s Server) GenTestPolynomials(f func(x uint64) (y uint64), T uint64) (ptU T
 			coeffs[N-k-1] = PlaintextModulus - f(uint64(j))
 		}
 
-		ptU[i] = ringQ.NewPoly()
+		ptU.Value[i] = ringQ.NewPoly()
 
 		// False = not scale by T^{-1} mod Q
-		ecd.RingT2Q(ptU[i].Level(), false, u, ptU[i])
+		ecd.RingT2Q(ptU.Value[i].Level(), false, u, ptU.Value[i])
 
 		// Montgomery domain
-		params.RingQ().MForm(ptU[i], ptU[i])
+		params.RingQ().MForm(ptU.Value[i], ptU.Value[i])
 
 		// NTT domain
-		params.RingQ().NTT(ptU[i], ptU[i])
+		params.RingQ().NTT(ptU.Value[i], ptU.Value[i])
 	}
 
 	return
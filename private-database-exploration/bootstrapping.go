@@ -14,19 +14,27 @@ type Bootstrapper interface {
 	he.Bootstrapper[rlwe.Ciphertext]
 	SchemeSwitch(input *rlwe.Ciphertext) (output0, output1 *rlwe.Ciphertext, err error)
 	GetEvaluator() *hefloat.Evaluator
+
+	// ShallowCopy returns a copy of the Bootstrapper that shares the keys with the
+	// receiver and can be used concurrently with the receiver.
+	ShallowCopy() Bootstrapper
 }
 
 type BootstrappingEvaluator struct {
 	bootstrapping.Evaluator
 }
 
-func NewBootstrappingEvaluator(r Request) (btp Bootstrapper, err error) {
+func NewBootstrappingEvaluator(cfg Config, r Request) (btp Bootstrapper, err error) {
+
+	if err = cfg.CheckParameters(r.Parameters); err != nil {
+		return nil, fmt.Errorf("cfg.CheckParameters: %w", err)
+	}
 
-	paramsEval := hefloat.Parameters{Parameters: ckks.Parameters{Parameters: *r.Parameters[LogNEval].GetRLWEParameters()}}
+	paramsEval := hefloat.Parameters{Parameters: ckks.Parameters{Parameters: *r.Parameters[cfg.LogNEval].GetRLWEParameters()}}
 
 	var btpParams bootstrapping.Parameters
-	if btpParams, err = bootstrapping.NewParametersFromLiteral(paramsEval, BootstrappingParametersLiteral); err != nil {
-		return nil, fmt.Errorf("bootstrapping.NewParametersFromLiteral: %w", err)
+	if btpParams, err = cfg.ParametersBootstrapping(paramsEval); err != nil {
+		return nil, fmt.Errorf("cfg.ParametersBootstrapping: %w", err)
 	}
 
 	var eval *bootstrapping.Evaluator
@@ -40,3 +48,164 @@ func NewBootstrappingEvaluator(r Request) (btp Bootstrapper, err error) {
 func (eval BootstrappingEvaluator) GetEvaluator() *hefloat.Evaluator {
 	return eval.Evaluator.Evaluator
 }
+
+// ShallowCopy creates a shallow copy of the bootstrapper in which the keys are shared with the receiver.
+func (eval BootstrappingEvaluator) ShallowCopy() Bootstrapper {
+	return BootstrappingEvaluator{Evaluator: *eval.Evaluator.ShallowCopy()}
+}
+
+// SecretKeyBootstrapper is a debug Bootstrapper that refreshes the ciphertexts by
+// decrypting and re-encrypting them with the secret key of the client, and that
+// emulates the scheme-switching in plaintext.
+// It evaluates the same circuit as the BootstrappingEvaluator, in a fraction of the
+// time and memory, and must only be used to debug and test the pipeline.
+type SecretKeyBootstrapper struct {
+	*hefloat.Evaluator
+	Parameters hefloat.Parameters
+	Encoder    *hefloat.Encoder
+	Encryptor  *rlwe.Encryptor
+	Decryptor  *rlwe.Decryptor
+}
+
+// NewSecretKeyBootstrapper instantiates a new SecretKeyBootstrapper with the secret key
+// of the parameters of degree 2^{LogNEval} and the evaluation keys of the comparison circuit, i.e.
+// the relinearization key and the Galois keys of the inner sum.
+func NewSecretKeyBootstrapper(params hefloat.Parameters, sk *rlwe.SecretKey, evk rlwe.EvaluationKeySet) (btp *SecretKeyBootstrapper, err error) {
+
+	if sk == nil {
+		return nil, fmt.Errorf("secret key cannot be nil")
+	}
+
+	if sk.Value.Q.N() != params.N() {
+		return nil, fmt.Errorf("invalid secret key: ring degree %d != %d", sk.Value.Q.N(), params.N())
+	}
+
+	return &SecretKeyBootstrapper{
+		Evaluator:  hefloat.NewEvaluator(params, evk),
+		Parameters: params,
+		Encoder:    hefloat.NewEncoder(params),
+		Encryptor:  rlwe.NewEncryptor(params, sk),
+		Decryptor:  rlwe.NewDecryptor(params, sk),
+	}, nil
+}
+
+// Bootstrap decrypts the ciphertext and re-encrypts it at the output level with the default scale.
+func (btp SecretKeyBootstrapper) Bootstrap(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	cts, err := btp.BootstrapMany([]rlwe.Ciphertext{*ct})
+	if err != nil {
+		return nil, err
+	}
+	return &cts[0], nil
+}
+
+// BootstrapMany bootstraps a list of ciphertexts, see Bootstrap.
+func (btp SecretKeyBootstrapper) BootstrapMany(cts []rlwe.Ciphertext) ([]rlwe.Ciphertext, error) {
+
+	params := btp.Parameters
+
+	for i := range cts {
+
+		ct := &cts[i]
+
+		if err := btp.check(ct); err != nil {
+			return nil, fmt.Errorf("cts[%d]: %w", i, err)
+		}
+
+		values := make([]complex128, ct.Slots())
+		if err := btp.Encoder.Decode(btp.Decryptor.DecryptNew(ct), values); err != nil {
+			return nil, fmt.Errorf("btp.Encoder.Decode: %w", err)
+		}
+
+		pt := hefloat.NewPlaintext(params, btp.OutputLevel())
+		pt.LogDimensions = ct.LogDimensions
+
+		if err := btp.Encoder.Encode(values, pt); err != nil {
+			return nil, fmt.Errorf("btp.Encoder.Encode: %w", err)
+		}
+
+		out, err := btp.Encryptor.EncryptNew(pt)
+		if err != nil {
+			return nil, fmt.Errorf("btp.Encryptor.EncryptNew: %w", err)
+		}
+
+		cts[i] = *out
+	}
+
+	return cts, nil
+}
+
+// Depth returns zero: the bootstrapping does not consume any level.
+func (btp SecretKeyBootstrapper) Depth() int {
+	return 0
+}
+
+// MinimumInputLevel returns zero: any level can be bootstrapped.
+func (btp SecretKeyBootstrapper) MinimumInputLevel() int {
+	return 0
+}
+
+// OutputLevel returns the maximum level of the parameters.
+func (btp SecretKeyBootstrapper) OutputLevel() int {
+	return btp.Parameters.MaxLevel()
+}
+
+func (btp SecretKeyBootstrapper) GetEvaluator() *hefloat.Evaluator {
+	return btp.Evaluator
+}
+
+// ShallowCopy creates a shallow copy of the bootstrapper in which the keys are shared with the receiver.
+func (btp SecretKeyBootstrapper) ShallowCopy() Bootstrapper {
+	return &SecretKeyBootstrapper{
+		Evaluator:  btp.Evaluator.ShallowCopy(),
+		Parameters: btp.Parameters,
+		Encoder:    btp.Encoder.ShallowCopy(),
+		Encryptor:  btp.Encryptor.ShallowCopy(),
+		Decryptor:  btp.Decryptor.ShallowCopy(),
+	}
+}
+
+// check returns an error if the ciphertext cannot be decrypted with the secret key.
+func (btp SecretKeyBootstrapper) check(ct *rlwe.Ciphertext) (err error) {
+
+	if ct.Degree() != 1 {
+		return fmt.Errorf("invalid ciphertext degree: %d != 1", ct.Degree())
+	}
+
+	if N := len(ct.Value[0].Coeffs[0]); N != btp.Parameters.N() {
+		return fmt.Errorf("invalid ciphertext ring degree: %d != %d", N, btp.Parameters.N())
+	}
+
+	return
+}
+
+// NewBootstrapper returns the Bootstrapper of the request: a SecretKeyBootstrapper
+// if s.SecretKeyBootstrapping is set, which requires s.SkDebug[cfg.LogNEval], else a
+// BootstrappingEvaluator, see NewBootstrappingEvaluator.
+func (s Server) NewBootstrapper(cfg Config, r Request) (btp Bootstrapper, err error) {
+
+	if !s.SecretKeyBootstrapping {
+		return NewBootstrappingEvaluator(cfg, r)
+	}
+
+	sk, ok := s.SkDebug[cfg.LogNEval]
+	if !ok {
+		return nil, fmt.Errorf("secret key bootstrapping requires s.SkDebug[%d]", cfg.LogNEval)
+	}
+
+	if err = cfg.CheckParameters(r.Parameters); err != nil {
+		return nil, fmt.Errorf("cfg.CheckParameters: %w", err)
+	}
+
+	paramsEval := hefloat.Parameters{Parameters: ckks.Parameters{Parameters: *r.Parameters[cfg.LogNEval].GetRLWEParameters()}}
+
+	var evk rlwe.EvaluationKeySet
+	if r.EvaluationKeys.MemEvaluationKeySet != nil {
+		evk = r.EvaluationKeys.MemEvaluationKeySet
+	}
+
+	if btp, err = NewSecretKeyBootstrapper(paramsEval, sk, evk); err != nil {
+		return nil, fmt.Errorf("NewSecretKeyBootstrapper: %w", err)
+	}
+
+	return
+}
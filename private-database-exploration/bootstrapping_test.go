@@ -0,0 +1,495 @@
+package pde
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestSecretKeyBootstrapping(t *testing.T) {
+
+	// Small parameters, the bootstrapping is emulated with the secret key
+	cfg, err := NewConfig(ProfileTestInsecure)
+	require.NoError(t, err)
+
+	paramsEval, err := cfg.ParametersEval()
+	require.NoError(t, err)
+
+	client := NewClient()
+	client.Config = cfg
+
+	kgen := rlwe.NewKeyGenerator(paramsEval)
+	sk := kgen.GenSecretKeyNew()
+
+	rpk := RepackEvaluationKeySet{}
+	client.Ski, err = rpk.GenRingSwitchingKeys(paramsEval, sk, cfg.LogNPack, cfg.EvaluationKeys)
+	require.NoError(t, err)
+	rpk.GenRepackEvaluationKeys(rpk.Parameters[cfg.LogNPack], client.Ski[cfg.LogNPack], cfg.EvaluationKeys)
+	client.Parameters = rpk.Parameters
+
+	// The comparison circuit only needs the relinearization key and
+	// the keys of the conjugation and of the inner sum
+	galEls := append(paramsEval.GaloisElementsForInnerSum(1, paramsEval.MaxSlots()), paramsEval.GaloisElementForComplexConjugation())
+
+	evk := EvaluationKeys{RepackEvaluationKeySet: rpk}
+	evk.MemEvaluationKeySet = rlwe.NewMemEvaluationKeySet(kgen.GenRelinearizationKeyNew(sk), kgen.GenGaloisKeysNew(galEls, sk)...)
+
+	schema := Schema{
+		{Name: "age", Interval: [2]float64{0, 120}},
+		{Name: "sex", Type: Categorical, Categories: []string{"F", "M"}},
+	}
+
+	r := rand.New(rand.NewSource(0))
+
+	rows := 300
+	data := make([]float64, 2*rows)
+	for i := 0; i < rows; i++ {
+		data[2*i] = float64(r.Intn(121))
+		data[2*i+1] = float64(r.Intn(2))
+	}
+
+	db := Database{Dense: mat.NewDense(rows, 2, data), Schema: schema}
+
+	criteria, err := CompileCriteria("age > 40 AND sex = M", schema, 121)
+	require.NoError(t, err)
+
+	var want int
+	for i := 0; i < rows; i++ {
+		if criteria.Match(db.GetRow(i)) {
+			want++
+		}
+	}
+
+	tvs, t0, t1, err := client.GenEncryptedCriteria(criteria, want)
+	require.NoError(t, err)
+
+	request := Request{
+		EvaluationKeys:    &evk,
+		TestVectors:       &tvs,
+		PrivateThreshold0: &t0,
+		PrivateThreshold1: &t1,
+	}
+
+	server := NewServer()
+
+	t.Run("NoSecretKey", func(t *testing.T) {
+		server := server
+		server.SecretKeyBootstrapping = true
+		_, err := server.NewBootstrapper(cfg, request)
+		require.Error(t, err)
+	})
+
+	server.SkDebug = client.Ski
+	server.SecretKeyBootstrapping = true
+
+	btp, err := server.NewBootstrapper(cfg, request)
+	require.NoError(t, err)
+	require.IsType(t, &SecretKeyBootstrapper{}, btp)
+
+	t.Run("Bootstrap", func(t *testing.T) {
+
+		ecd := hefloat.NewEncoder(paramsEval)
+		enc := rlwe.NewEncryptor(paramsEval, sk)
+
+		values := []complex128{1, 2i, -3}
+
+		pt := hefloat.NewPlaintext(paramsEval, 0)
+		require.NoError(t, ecd.Encode(values, pt))
+
+		ct, err := enc.EncryptNew(pt)
+		require.NoError(t, err)
+
+		ct, err = btp.Bootstrap(ct)
+		require.NoError(t, err)
+		require.Equal(t, btp.OutputLevel(), ct.Level())
+
+		have, err := client.Decrypt(ct)
+		require.NoError(t, err)
+
+		for i := range values {
+			require.InDelta(t, real(values[i]), real(have[i]), 1e-6)
+			require.InDelta(t, imag(values[i]), imag(have[i]), 1e-6)
+		}
+
+		// Wrong ring degree
+		_, err = btp.Bootstrap(&tvs[0].Value[0])
+		require.Error(t, err)
+	})
+
+	t.Run("ProcessRequest", func(t *testing.T) {
+
+		server := server
+
+		last := map[Stage]Progress{}
+		server.Observer = ObserverFunc(func(p Progress) {
+			last[p.Stage] = p
+		})
+
+		partial, err := server.ProcessPartialRequest(context.Background(), cfg, request, &db, btp)
+		require.NoError(t, err)
+
+		for _, stage := range []Stage{StageLookupPacking, StageMerging, StageSchemeSwitching, StageInnerSum} {
+			require.Equal(t, 1, last[stage].Total, stage)
+			require.Equal(t, last[stage].Total, last[stage].Done, stage)
+			require.NotZero(t, last[stage].Memory, stage)
+		}
+
+		count, err := client.Decrypt(partial.Count)
+		require.NoError(t, err)
+		require.InDelta(t, float64(want), real(count[0]), 0.5)
+
+		// The global threshold is met with exactly the number of matching patients
+		for _, tc := range []struct {
+			minPatients int
+			want        float64
+		}{
+			{want, 1},
+			{want + 1, 0},
+		} {
+
+			t1, err := client.GenPrivateThreshold(float64(tc.minPatients), nil)
+			require.NoError(t, err)
+
+			request := request
+			request.PrivateThreshold1 = &t1
+
+			score, err := server.Aggregate(context.Background(), cfg, request, []PartialCount{partial}, btp)
+			require.NoError(t, err)
+
+			v, err := client.Decrypt(score)
+			require.NoError(t, err)
+			require.InDelta(t, tc.want, real(v[0]), 1e-2)
+		}
+
+		require.Equal(t, 1, last[StageAggregation].Done)
+		require.Equal(t, 1, last[StageAggregation].Total)
+
+		// An invalid noisy count is rejected before the budget is charged
+		server.Accountant = NewPrivacyAccountant(PrivacyBudget{Epsilon: 1}, PrivacyBudget{Epsilon: 1})
+
+		noisy := request
+		noisy.Privacy = &DifferentialPrivacy{Noise: DiscreteLaplace, Epsilon: 1}
+
+		for _, invalid := range []PartialCount{
+			{Hospital: partial.Hospital, Count: partial.Count, Rows: -partial.Rows},
+			{Hospital: partial.Hospital, Rows: partial.Rows},
+		} {
+			_, err = server.Aggregate(context.Background(), cfg, noisy, []PartialCount{invalid}, btp)
+			require.Error(t, err)
+			require.Equal(t, PrivacyBudget{}, server.Accountant.Spent(partial.Hospital))
+		}
+
+		_, err = server.Aggregate(context.Background(), cfg, noisy, []PartialCount{partial}, btp)
+		require.NoError(t, err)
+		require.Equal(t, PrivacyBudget{Epsilon: 1}, server.Accountant.Spent(partial.Hospital))
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+
+		server := server
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Canceled once the rows are packed
+		var stages []Stage
+		server.Observer = ObserverFunc(func(p Progress) {
+			stages = append(stages, p.Stage)
+			cancel()
+		})
+
+		_, err := server.ProcessRequest(ctx, cfg, request, &db, btp)
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, []Stage{StageLookupPacking}, stages)
+	})
+
+	t.Run("Precision", func(t *testing.T) {
+
+		report, err := server.Precision(context.Background(), cfg, request, &db, btp, criteria.Oracle(want))
+		require.NoError(t, err)
+
+		t.Logf("%+v", report)
+
+		for _, p := range []StagePrecision{report.Lookup, report.Packing, report.Merging, report.SchemeSwitching, report.LocalThreshold} {
+			require.Equal(t, rows, p.Values)
+			require.Zero(t, p.Misclassified)
+		}
+
+		// The error of the scores is below the resolution of the local threshold
+		require.Less(t, report.SchemeSwitching.MaxError, 1e-2)
+		require.Less(t, report.LocalThreshold.MaxError, 1e-2)
+
+		for _, p := range []StagePrecision{report.InnerSum, report.GlobalThreshold} {
+			require.Equal(t, 1, p.Values)
+			require.Zero(t, p.Misclassified)
+			require.Less(t, p.MaxError, 0.5)
+		}
+
+		// The precision requires the secret keys
+		server := server
+		server.SkDebug = nil
+		_, err = server.Precision(context.Background(), cfg, request, &db, btp, criteria.Oracle(want))
+		require.Error(t, err)
+	})
+
+	t.Run("Policies", func(t *testing.T) {
+
+		// Out of range ages are clamped by the schema and unknown sexes skipped by the request
+		schema := append(Schema{}, schema...)
+		schema[0].Policy = PolicyClamp
+
+		invalid := Database{Dense: mat.DenseCopyOf(db.Dense), Schema: schema}
+		invalid.Set(0, 0, 200)
+		invalid.Set(0, 1, 1)
+		invalid.Set(1, 0, -10)
+		invalid.Set(2, 1, 7)
+		invalid.Set(3, 1, math.NaN())
+
+		funcs := append([]Func{}, criteria.Funcs...)
+		funcs[1].Policy = PolicySkip
+
+		tvs, err := client.GenEncryptedFunction(funcs)
+		require.NoError(t, err)
+
+		t0, err := client.GenPrivateThreshold(criteria.Threshold, funcs)
+		require.NoError(t, err)
+
+		request := request
+		request.TestVectors = &tvs
+		request.PrivateThreshold0 = &t0
+
+		oracle := Oracle{Funcs: funcs, Threshold0: criteria.Threshold}
+
+		want, err := oracle.Evaluate(&invalid)
+		require.NoError(t, err)
+
+		server := server
+		server.Policies = &PolicyCounts{}
+
+		partial, err := server.ProcessPartialRequest(context.Background(), cfg, request, &invalid, btp)
+		require.NoError(t, err)
+
+		count, err := client.Decrypt(partial.Count)
+		require.NoError(t, err)
+		require.InDelta(t, want.Count, real(count[0]), 0.5)
+
+		require.Equal(t, int64(2), server.Policies.Clamped.Load())
+		require.Equal(t, int64(0), server.Policies.Missing.Load())
+		require.Equal(t, int64(2), server.Policies.Skipped.Load())
+
+		// The rows are counted once when the request is resumed by a restarted server
+		server.Checkpoint = &Checkpoint{Dir: t.TempDir(), ID: "request"}
+		server.Policies = &PolicyCounts{}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		server.Observer = ObserverFunc(func(p Progress) {
+			if p.Stage == StageMerging {
+				cancel()
+			}
+		})
+
+		_, err = server.ProcessPartialRequest(ctx, cfg, request, &invalid, btp)
+		cancel()
+		require.ErrorIs(t, err, context.Canceled)
+		require.Equal(t, [3]int64{}, server.Policies.counts())
+
+		server.Observer = nil
+		server.Policies = &PolicyCounts{}
+
+		_, err = server.ProcessPartialRequest(context.Background(), cfg, request, &invalid, btp)
+		require.NoError(t, err)
+		require.Equal(t, [3]int64{2, 0, 2}, server.Policies.counts())
+
+		server.Checkpoint = nil
+
+		// The skipped and padding rows are not counted by a local threshold t0 <= 1/2
+		t0, err = client.GenPrivateThreshold(0, funcs)
+		require.NoError(t, err)
+		request.PrivateThreshold0 = &t0
+
+		oracle.Threshold0 = 0
+		want, err = oracle.Evaluate(&invalid)
+		require.NoError(t, err)
+		require.Equal(t, float64(rows-2), want.Count)
+
+		partial, err = server.ProcessPartialRequest(context.Background(), cfg, request, &invalid, btp)
+		require.NoError(t, err)
+
+		count, err = client.Decrypt(partial.Count)
+		require.NoError(t, err)
+		require.InDelta(t, want.Count, real(count[0]), 0.5)
+
+		// The ages have no policy without the schema
+		invalid.Schema = nil
+		_, err = server.ProcessPartialRequest(context.Background(), cfg, request, &invalid, btp)
+		require.Error(t, err)
+	})
+
+	t.Run("Checkpoint", func(t *testing.T) {
+
+		server := server
+		server.Checkpoint = &Checkpoint{Dir: t.TempDir(), ID: "request"}
+
+		// Crashes once the stage is completed, and resumes after it
+		for _, tc := range []struct {
+			crash  Stage
+			resume []Stage
+		}{
+			{StageLookupPacking, []Stage{StageMerging, StageSchemeSwitching, StageInnerSum}},
+			{StageMerging, []Stage{StageSchemeSwitching, StageInnerSum}},
+			{StageSchemeSwitching, []Stage{StageInnerSum}},
+		} {
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			server.Observer = ObserverFunc(func(p Progress) {
+				if p.Stage == tc.crash {
+					cancel()
+				}
+			})
+
+			_, err := server.ProcessPartialRequest(ctx, cfg, request, &db, btp)
+			cancel()
+			require.ErrorIs(t, err, context.Canceled, tc.crash)
+			require.FileExists(t, filepath.Join(server.Checkpoint.Path(), checkpointFile), tc.crash)
+
+			// The state is of another number of workers
+			other := server
+			other.Workers = 2
+			_, err = other.ProcessPartialRequest(context.Background(), cfg, request, &db, btp)
+			require.Error(t, err, tc.crash)
+
+			var stages []Stage
+			server.Observer = ObserverFunc(func(p Progress) {
+				stages = append(stages, p.Stage)
+			})
+
+			partial, err := server.ProcessPartialRequest(context.Background(), cfg, request, &db, btp)
+			require.NoError(t, err, tc.crash)
+			require.Equal(t, tc.resume, stages, tc.crash)
+			require.NoDirExists(t, server.Checkpoint.Path(), tc.crash)
+
+			count, err := client.Decrypt(partial.Count)
+			require.NoError(t, err)
+			require.InDelta(t, float64(want), real(count[0]), 0.5, tc.crash)
+		}
+	})
+
+	t.Run("MemoryBudget", func(t *testing.T) {
+
+		server := server
+
+		server.MemoryBudget = 1
+		_, err := server.ProcessPartialRequest(context.Background(), cfg, request, &db, btp)
+		require.Error(t, err)
+
+		paramsPack := *client.Parameters[cfg.LogNPack]
+		sizePack := hefloat.NewCiphertext(paramsPack, 1, paramsPack.MaxLevel()).BinarySize()
+		sizeEval := hefloat.NewCiphertext(paramsEval, 1, paramsEval.MaxLevel()).BinarySize()
+
+		// Batches of 1365 rows, the last batch of each full chunk of 2^{LogNPack} rows has a single row
+		server.MemoryBudget = 2*(paramsEval.N()/paramsPack.N())*sizePack + sizePack + 3*sizeEval + 1365*sizePack
+
+		// Several chunks of 2^{LogNEval} rows
+		rows := paramsEval.N() + 300
+		data := make([]float64, 2*rows)
+		for i := 0; i < rows; i++ {
+			data[2*i] = float64(r.Intn(121))
+			data[2*i+1] = float64(r.Intn(2))
+		}
+
+		db := Database{Dense: mat.NewDense(rows, 2, data), Schema: schema}
+
+		var want int
+		for i := 0; i < rows; i++ {
+			if criteria.Match(db.GetRow(i)) {
+				want++
+			}
+		}
+
+		partial, err := server.ProcessPartialRequest(context.Background(), cfg, request, &db, btp)
+		require.NoError(t, err)
+		require.Equal(t, rows, partial.Rows)
+
+		count, err := client.Decrypt(partial.Count)
+		require.NoError(t, err)
+		require.InDelta(t, float64(want), real(count[0]), 0.5)
+
+		// Three workers, the second evaluates the last chunk of rows
+		server.Workers = 3
+		server.MemoryBudget = 0
+
+		partial, err = server.ProcessPartialRequest(context.Background(), cfg, request, &db, btp)
+		require.NoError(t, err)
+
+		have, err := client.Decrypt(partial.Count)
+		require.NoError(t, err)
+		require.InDelta(t, real(count[0]), real(have[0]), 1e-3)
+	})
+}
+
+func TestSchemeSwitch(t *testing.T) {
+
+	cfg, err := NewConfig(ProfileTestInsecure)
+	require.NoError(t, err)
+
+	client := NewClient()
+	evk, err := client.Init(cfg)
+	require.NoError(t, err)
+
+	request := Request{EvaluationKeys: &evk}
+
+	btp, err := NewBootstrappingEvaluator(cfg, request)
+	require.NoError(t, err)
+
+	server := NewServer()
+	server.SkDebug = client.Ski
+	server.SecretKeyBootstrapping = true
+
+	btpDebug, err := server.NewBootstrapper(cfg, request)
+	require.NoError(t, err)
+
+	// Coefficient-packed input at the level of the merged ciphertexts
+	params := *client.Parameters[cfg.LogNEval]
+
+	coeffs := make([]float64, params.N())
+	for i := range coeffs {
+		coeffs[i] = float64(i%256) / float64(Scaling)
+	}
+
+	pt := hefloat.NewPlaintext(params, 0)
+	pt.IsBatched = false
+	require.NoError(t, hefloat.NewEncoder(params).Encode(coeffs, pt))
+
+	ct, err := rlwe.NewEncryptor(params, client.Ski[cfg.LogNEval]).EncryptNew(pt)
+	require.NoError(t, err)
+
+	want0, want1, err := btp.SchemeSwitch(ct.CopyNew())
+	require.NoError(t, err)
+
+	have0, have1, err := btpDebug.SchemeSwitch(ct.CopyNew())
+	require.NoError(t, err)
+
+	for i, pair := range [][2]*rlwe.Ciphertext{{want0, have0}, {want1, have1}} {
+
+		require.Equal(t, pair[0].Level(), pair[1].Level())
+		require.Equal(t, pair[0].Scale.Float64(), pair[1].Scale.Float64())
+
+		want, err := client.Decrypt(pair[0])
+		require.NoError(t, err)
+
+		have, err := client.Decrypt(pair[1])
+		require.NoError(t, err)
+
+		for j := range want {
+			require.InDelta(t, real(want[j]), real(have[j]), 1e-3, "output%d[%d]", i, j)
+		}
+	}
+}
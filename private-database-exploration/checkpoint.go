@@ -0,0 +1,297 @@
+package pde
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/utils/buffer"
+	"github.com/tuneinsight/lattigo/v5/utils/structs"
+)
+
+// checkpointFile is the name of the file of the state of a request in its work directory.
+const checkpointFile = "checkpoint.bin"
+
+// Checkpoint is a work directory in which Server.ProcessPartialRequest saves the
+// intermediate ciphertexts of a request: the packed ciphertexts of the current chunks
+// of rows after the lookup tables and the packing, the merged ciphertexts after the
+// ring merging, and the count after each scheme-switched ciphertext is added to it.
+// A server evaluating the same request with the same Checkpoint, e.g. after a crash,
+// resumes it from its last completed stage.
+type Checkpoint struct {
+	// Dir is the work directory.
+	Dir string
+	// ID identifies the request, whose state is saved in Dir/ID.
+	ID string
+}
+
+// Path returns the directory of the state of the request.
+func (cp Checkpoint) Path() string {
+	return filepath.Join(cp.Dir, cp.ID)
+}
+
+// Remove removes the state of the request.
+func (cp Checkpoint) Remove() (err error) {
+	return os.RemoveAll(cp.Path())
+}
+
+// checkpointState is the state of a request saved in a Checkpoint.
+type checkpointState struct {
+	// Fingerprint identifies the request, the database and the chunks of rows.
+	Fingerprint []byte
+	// Row is the first row of the current chunks of rows.
+	Row int
+	// Stage is the last completed stage of the current chunks of rows.
+	Stage Stage
+	// Folded is the number of merged ciphertexts of the current chunks of rows added to Count.
+	Folded int
+	// Count is the local threshold of the rows up to Row, and of the Folded merged ciphertexts.
+	Count rlwe.Ciphertext
+	// Policies are the PolicyCounts of the rows looked up, see PolicyCounts.counts.
+	Policies [3]int64
+	// Cts are the packed ciphertexts after StageLookupPacking, else the merged ciphertexts.
+	Cts structs.Vector[rlwe.Ciphertext]
+}
+
+// load reads the state of the request, and returns false if there is none.
+func (cp Checkpoint) load(state *checkpointState) (ok bool, err error) {
+
+	f, err := os.Open(filepath.Join(cp.Path(), checkpointFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("os.Open: %w", err)
+	}
+
+	defer f.Close()
+
+	if _, err = state.ReadFrom(f); err != nil {
+		return false, fmt.Errorf("state.ReadFrom: %w", err)
+	}
+
+	return true, nil
+}
+
+// resume loads the state of the request into state, which holds the fingerprint
+// of the request. It returns false if there is no state, and an error if the state
+// is of another request or invalid.
+func (cp Checkpoint) resume(state *checkpointState, window, rows int) (ok bool, err error) {
+
+	var saved checkpointState
+	if ok, err = cp.load(&saved); err != nil || !ok {
+		return
+	}
+
+	if !bytes.Equal(saved.Fingerprint, state.Fingerprint) {
+		return false, fmt.Errorf("the checkpoint %s is of another request, database or number of workers", cp.Path())
+	}
+
+	if saved.Row < 0 || saved.Row >= rows || saved.Row%window != 0 {
+		return false, fmt.Errorf("invalid checkpoint: row %d", saved.Row)
+	}
+
+	switch saved.Stage {
+	case StageLookupPacking, StageMerging:
+	case StageSchemeSwitching:
+		if saved.Folded > len(saved.Cts) {
+			return false, fmt.Errorf("invalid checkpoint: %d ciphertexts folded out of %d", saved.Folded, len(saved.Cts))
+		}
+	default:
+		return false, fmt.Errorf("invalid checkpoint: stage %q", saved.Stage)
+	}
+
+	*state = saved
+
+	return true, nil
+}
+
+// save writes the state of the request. The state is written to a temporary
+// file which then replaces the previous state, so that a crash while saving
+// does not corrupt the previous state.
+func (cp Checkpoint) save(state *checkpointState) (err error) {
+
+	if err = os.MkdirAll(cp.Path(), 0o700); err != nil {
+		return fmt.Errorf("os.MkdirAll: %w", err)
+	}
+
+	f, err := os.CreateTemp(cp.Path(), checkpointFile+".*")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: %w", err)
+	}
+
+	defer os.Remove(f.Name())
+
+	if _, err = state.WriteTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("state.WriteTo: %w", err)
+	}
+
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("f.Sync: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("f.Close: %w", err)
+	}
+
+	if err = os.Rename(f.Name(), filepath.Join(cp.Path(), checkpointFile)); err != nil {
+		return fmt.Errorf("os.Rename: %w", err)
+	}
+
+	return
+}
+
+// checkpointFingerprint returns the fingerprint of the evaluation of the request on
+// the database by chunks of window rows, which the state of a Checkpoint must match.
+func checkpointFingerprint(r Request, db *Database, window int) (fingerprint []byte, err error) {
+
+	h := sha256.New()
+
+	rows, cols := db.Dims()
+
+	for _, v := range []int{rows, cols, window} {
+		if err = binary.Write(h, binary.LittleEndian, uint64(v)); err != nil {
+			return nil, fmt.Errorf("binary.Write: %w", err)
+		}
+	}
+
+	for i := 0; i < rows; i++ {
+		if err = binary.Write(h, binary.LittleEndian, db.RawRowView(i)); err != nil {
+			return nil, fmt.Errorf("binary.Write: %w", err)
+		}
+	}
+
+	if _, err = r.TestVectors.WriteTo(h); err != nil {
+		return nil, fmt.Errorf("r.TestVectors.WriteTo: %w", err)
+	}
+
+	if _, err = r.PrivateThreshold0.WriteTo(h); err != nil {
+		return nil, fmt.Errorf("r.PrivateThreshold0.WriteTo: %w", err)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface.
+func (state checkpointState) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindCheckpoint); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeBytes(w, state.Fingerprint); err != nil {
+			return n + inc, fmt.Errorf("writeBytes: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[int](w, state.Row); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeBytes(w, []byte(state.Stage)); err != nil {
+			return n + inc, fmt.Errorf("writeBytes: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[int](w, state.Folded); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if inc, err = state.Count.WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("state.Count.WriteTo: %w", err)
+		}
+		n += inc
+
+		for _, x := range state.Policies {
+			if inc, err = buffer.WriteAsUint64[int64](w, x); err != nil {
+				return n + inc, fmt.Errorf("buffer.WriteAsUint64[int64]: %w", err)
+			}
+			n += inc
+		}
+
+		if inc, err = state.Cts.WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("state.Cts.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return state.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (state *checkpointState) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindCheckpoint); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = readBytes(r, &state.Fingerprint); err != nil {
+			return n + inc, fmt.Errorf("readBytes: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.ReadAsUint64[int](r, &state.Row); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		var stage []byte
+		if inc, err = readBytes(r, &stage); err != nil {
+			return n + inc, fmt.Errorf("readBytes: %w", err)
+		}
+		n += inc
+
+		state.Stage = Stage(stage)
+
+		if inc, err = buffer.ReadAsUint64[int](r, &state.Folded); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if inc, err = state.Count.ReadFrom(r); err != nil {
+			return n + inc, fmt.Errorf("state.Count.ReadFrom: %w", err)
+		}
+		n += inc
+
+		for i := range state.Policies {
+			if inc, err = buffer.ReadAsUint64[int64](r, &state.Policies[i]); err != nil {
+				return n + inc, fmt.Errorf("buffer.ReadAsUint64[int64]: %w", err)
+			}
+			n += inc
+		}
+
+		if inc, err = state.Cts.ReadFrom(r); err != nil {
+			return n + inc, fmt.Errorf("state.Cts.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return state.ReadFrom(bufio.NewReader(r))
+	}
+}
@@ -2,17 +2,25 @@ package pde
 
 import (
 	"fmt"
+	"math"
 	"math/bits"
 
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
 	"github.com/tuneinsight/lattigo/v5/he/hefloat"
 	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
-	"github.com/tuneinsight/lattigo/v5/utils"
 )
 
 type Client struct {
+	// Config is the configuration of the keys of the client, see Client.Init.
+	Config     Config
 	Parameters map[int]*hefloat.Parameters
 	Ski        map[int]*rlwe.SecretKey
+
+	// Pk are the collective public keys and Party the share of the
+	// secret keys of the client, when the keys are generated with
+	// the hospitals, see Client.InitMultiparty.
+	Pk    map[int]*rlwe.PublicKey
+	Party *Party
 }
 
 func NewClient() (c Client) {
@@ -24,11 +32,21 @@ type Request struct {
 	*TestVectors
 	PrivateThreshold0 *PrivateThreshold
 	PrivateThreshold1 *PrivateThreshold
+
+	// Privacy, if not nil, replaces the global threshold: the response is
+	// the number of matching patients with differentially private noise,
+	// and PrivateThreshold1 is not used.
+	Privacy *DifferentialPrivacy
 }
 
 type PrivateThreshold struct {
 	Threshold     *rlwe.Ciphertext
 	Normalization *rlwe.Ciphertext
+
+	// Max is the sum of Func.Max of the functions of a local threshold, which sets
+	// the resolution of its sign polynomial, see Config.LocalThresholdPolynomial.
+	// It is public, and zero for a global threshold.
+	Max float64
 }
 
 type EvaluationKeys struct {
@@ -48,10 +66,21 @@ func (c Client) Decrypt(score *rlwe.Ciphertext) (v []complex128, err error) {
 	return v, ecd.Decode(dec.DecryptNew(score), v)
 }
 
+// encryptor returns an encryptor with the secret key of the client
+// or, if the keys are collective, with the collective public key.
+func (c Client) encryptor(LogN int) *rlwe.Encryptor {
+	if sk, ok := c.Ski[LogN]; ok {
+		return rlwe.NewEncryptor(*c.Parameters[LogN], sk)
+	}
+	return rlwe.NewEncryptor(*c.Parameters[LogN], c.Pk[LogN])
+}
+
+// GenEncryptedFunction encrypts the test vectors of the functions, each of which
+// declares the columns it consumes in the clear, see Func.Columns and Func.Axes.
 func (c Client) GenEncryptedFunction(funcs []Func) (encFuncs TestVectors, err error) {
 
-	params := *c.Parameters[LogNPack]
-	enc := rlwe.NewEncryptor(params, c.Ski[LogNPack])
+	params := *c.Parameters[c.Config.LogNPack]
+	enc := c.encryptor(c.Config.LogNPack)
 	ecd := hefloat.NewEncoder(params)
 
 	encFuncs = make([]TestVector, len(funcs))
@@ -69,11 +98,28 @@ func (c Client) GenEncryptedFunction(funcs []Func) (encFuncs TestVectors, err er
 
 func (c Client) GenPrivateThreshold(threshold float64, f []Func) (p PrivateThreshold, err error) {
 
-	params := *c.Parameters[LogNEval]
+	params := *c.Parameters[c.Config.LogNEval]
 
-	enc := rlwe.NewEncryptor(params, c.Ski[LogNEval])
+	enc := c.encryptor(c.Config.LogNEval)
 	ecd := hefloat.NewEncoder(params)
 
+	var max float64
+	for i := range f {
+		max += f[i].Max
+	}
+
+	// The resolution of the local threshold is bounded by the configuration
+	if max > c.Config.MaxScore {
+		return PrivateThreshold{}, fmt.Errorf("the sum of the maximums of the functions %f is greater than Config.MaxScore=%f", max, c.Config.MaxScore)
+	}
+
+	// The scores are in [0, max], so a local threshold outside of [0, max+1] selects
+	// the same rows as its nearest bound, which keeps Score - t0 + 1/2 in the domain
+	// of the sign polynomial, see Config.LocalThresholdPolynomial
+	if max != 0 {
+		threshold = math.Max(0, math.Min(threshold, max+1))
+	}
+
 	pt := hefloat.NewPlaintext(params, params.MaxLevel())
 	pt.IsBatched = false
 
@@ -93,16 +139,11 @@ func (c Client) GenPrivateThreshold(threshold float64, f []Func) (p PrivateThres
 
 	size := tEnc.BinarySize()
 
-	var max float64
-	for i := range f {
-		max += f[i].Max
-	}
-
 	if max != 0 {
 
 		pt.Scale = rlwe.NewScale(params.Q()[params.MaxLevel()])
 
-		if err = ecd.Encode([]float64{1 / max}, pt); err != nil {
+		if err = ecd.Encode([]float64{1 / (max + 0.5)}, pt); err != nil {
 			return PrivateThreshold{}, fmt.Errorf("ecd.Encode: %w", err)
 		}
 
@@ -120,51 +161,55 @@ func (c Client) GenPrivateThreshold(threshold float64, f []Func) (p PrivateThres
 	return PrivateThreshold{
 		Threshold:     tEnc,
 		Normalization: tNorm,
+		Max:           max,
 	}, nil
 
 }
 
-func (c *Client) Init() (evk EvaluationKeys, err error) {
+// Init validates the configuration and generates the keys of the client
+// and the evaluation keys of the requests.
+func (c *Client) Init(cfg Config) (evk EvaluationKeys, err error) {
 
-	paramsEval, err := hefloat.NewParametersFromLiteral(ParametersLiteralLogN16)
+	if err = cfg.Check(); err != nil {
+		return evk, fmt.Errorf("cfg.Check: %w", err)
+	}
+
+	paramsEval, err := cfg.ParametersEval()
 
 	if err != nil {
-		return evk, fmt.Errorf("hefloat.NewParametersFromLiteral: %w", err)
+		return evk, fmt.Errorf("cfg.ParametersEval: %w", err)
 	}
 
 	kgen := rlwe.NewKeyGenerator(paramsEval)
-	Sk16 := kgen.GenSecretKeyNew()
+	SkEval := kgen.GenSecretKeyNew()
 
 	evkRPK := RepackEvaluationKeySet{}
 
-	evkParams := rlwe.EvaluationKeyParameters{
-		LevelQ:               utils.Pointy(0),
-		LevelP:               utils.Pointy(0),
-		BaseTwoDecomposition: utils.Pointy(30),
-	}
+	evkParams := cfg.EvaluationKeys
 
-	if c.Ski, err = evkRPK.GenRingSwitchingKeys(paramsEval, Sk16, LogNPack, evkParams); err != nil {
+	if c.Ski, err = evkRPK.GenRingSwitchingKeys(paramsEval, SkEval, cfg.LogNPack, evkParams); err != nil {
 		return evk, fmt.Errorf("evkRPK.GenRingSwitchingKeys: %w", err)
 	}
 
-	evkRPK.GenRepackEvaluationKeys(evkRPK.Parameters[LogNPack], c.Ski[LogNPack], evkParams)
+	evkRPK.GenRepackEvaluationKeys(evkRPK.Parameters[cfg.LogNPack], c.Ski[cfg.LogNPack], evkParams)
 
+	c.Config = cfg
 	c.Parameters = evkRPK.Parameters
 
 	var btpParams bootstrapping.Parameters
-	if btpParams, err = bootstrapping.NewParametersFromLiteral(paramsEval, BootstrappingParametersLiteral); err != nil {
-		return evk, fmt.Errorf("bootstrapping.NewParametersFromLiteral: %w", err)
+	if btpParams, err = cfg.ParametersBootstrapping(paramsEval); err != nil {
+		return evk, fmt.Errorf("cfg.ParametersBootstrapping: %w", err)
 	}
 
-	for i := LogNPack; i < LogNEval; i++ {
+	for i := cfg.LogNPack; i < cfg.LogNEval; i++ {
 		p := c.Parameters[i]
 		fmt.Printf("Params Pack LogN=%d LogQP=%10.5f Xs=%v Xe=%v\n", i, p.LogQP(), p.Xs(), p.Xe())
 	}
-	fmt.Printf("Params Eval LogN=%d LogQP=%10.5f Xs=%v Xe=%v\n", LogNEval, c.Parameters[LogNEval].LogQP(), c.Parameters[LogNEval].Xs(), c.Parameters[LogNEval].Xe())
-	fmt.Printf("Params Boot LogN=%d LogQP=%10.5f Xs=%v Xe=%v\n", LogNEval, btpParams.BootstrappingParameters.LogQP(), btpParams.BootstrappingParameters.Xs(), btpParams.BootstrappingParameters.Xe())
+	fmt.Printf("Params Eval LogN=%d LogQP=%10.5f Xs=%v Xe=%v\n", cfg.LogNEval, c.Parameters[cfg.LogNEval].LogQP(), c.Parameters[cfg.LogNEval].Xs(), c.Parameters[cfg.LogNEval].Xe())
+	fmt.Printf("Params Boot LogN=%d LogQP=%10.5f Xs=%v Xe=%v\n", cfg.LogNEval, btpParams.BootstrappingParameters.LogQP(), btpParams.BootstrappingParameters.Xs(), btpParams.BootstrappingParameters.Xe())
 
 	var evkBoot *bootstrapping.EvaluationKeys
-	if evkBoot, _, err = btpParams.GenEvaluationKeys(Sk16); err != nil {
+	if evkBoot, _, err = btpParams.GenEvaluationKeys(SkEval); err != nil {
 		return evk, fmt.Errorf("btpParams.GenEvaluationKeys: %w", err)
 	}
 
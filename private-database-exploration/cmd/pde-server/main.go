@@ -0,0 +1,109 @@
+// Command pde-server runs a pde.Service on the database of a hospital.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	pde "github.com/pro7ech/fhe-org-2024/private-database-exploration"
+)
+
+func main() {
+
+	addr := flag.String("addr", "localhost:8080", "address on which the service listens")
+	profile := flag.String("profile", pde.Profile128, "parameter profile of the keys of the clients ("+pde.ProfileTestInsecure+", "+pde.Profile128+", "+pde.ProfileLowMemory+")")
+	csvPath := flag.String("csv", "", "CSV table of the patients (default: synthetic database)")
+	schemaPath := flag.String("schema", "", "JSON schema of the CSV table")
+	hospital := flag.String("hospital", "", "identifier of the hospital in the partial counts")
+	epsilon := flag.Float64("epsilon", 0, "privacy budget epsilon of the noisy counts (0 = noisy counts disabled)")
+	delta := flag.Float64("delta", 0, "privacy budget delta of the noisy counts")
+	maxQueryEpsilon := flag.Float64("max-query-epsilon", 0.1, "maximum epsilon of a single noisy count")
+	maxQueryDelta := flag.Float64("max-query-delta", 0, "maximum delta of a single noisy count")
+	maxKeySize := flag.Int64("max-key-size", pde.DefaultMaxKeySize, "maximum size in bytes of the evaluation keys")
+	maxQuerySize := flag.Int64("max-query-size", pde.DefaultMaxQuerySize, "maximum size in bytes of a query")
+	maxConcurrentJobs := flag.Int("max-concurrent-jobs", 1, "number of jobs evaluated concurrently")
+	workers := flag.Int("workers", 1, "number of goroutines evaluating a job")
+	publicGrids := flag.Bool("public-grids", false, "only accept queries tabulated on the public grids of the schema, which hide the selection intervals")
+	memoryBudget := flag.Int("memory-budget", 0, "maximum size in bytes of the ciphertexts buffered by a job (0 = the lookup tables of 2^LogNPack rows at once)")
+	flag.Parse()
+
+	if *publicGrids && *csvPath == "" {
+		log.Fatal("the public grids require a CSV table and its schema")
+	}
+
+	cfg, err := pde.NewConfig(*profile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var db pde.Database
+
+	if *csvPath != "" {
+
+		if *schemaPath == "" {
+			log.Fatal("a CSV table requires a schema")
+		}
+
+		schema := readSchema(*schemaPath)
+
+		f, err := os.Open(*csvPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var invalid []pde.RowError
+		if db, invalid, err = pde.LoadCSV(f, schema); err != nil {
+			log.Fatal(err)
+		}
+
+		f.Close()
+
+		if len(invalid) != 0 {
+			log.Printf("skipped %d invalid rows, first: %v", len(invalid), invalid[0])
+		}
+
+		log.Printf("loaded %d rows x %d columns from %s", db.Size(), len(schema), *csvPath)
+
+	} else {
+		db = pde.NewDatabase(cfg.DBSize, cfg.Features)
+		log.Printf("generated a synthetic database of %d rows x %d columns", cfg.DBSize, cfg.Features)
+	}
+
+	server := pde.NewServer()
+	server.Hospital = *hospital
+	server.MemoryBudget = *memoryBudget
+	server.Workers = *workers
+
+	if *epsilon != 0 {
+		server.Accountant = pde.NewPrivacyAccountant(pde.PrivacyBudget{Epsilon: *epsilon, Delta: *delta}, pde.PrivacyBudget{Epsilon: *maxQueryEpsilon, Delta: *maxQueryDelta})
+	}
+
+	service := pde.NewService(cfg, server, &db, pde.ServiceParameters{
+		MaxKeySize:        *maxKeySize,
+		MaxQuerySize:      *maxQuerySize,
+		MaxConcurrentJobs: *maxConcurrentJobs,
+		PublicGrids:       *publicGrids,
+	})
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, service))
+}
+
+func readSchema(path string) pde.Schema {
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer f.Close()
+
+	schema, err := pde.ReadSchema(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return schema
+}
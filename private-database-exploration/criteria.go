@@ -0,0 +1,731 @@
+package pde
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxCriteriaWeight is the maximum sum of the weights of a compiled Criteria.
+// The local threshold distinguishes scores with a delta of 2^{-8} after
+// normalization by 1/(sum(Max)+1/2), and two scores differ by at least 1/2
+// around the threshold.
+const MaxCriteriaWeight = 127
+
+// Criteria is a selection formula compiled into the additive score-then-threshold
+// circuit: a patient p meets the criteria iff sum_j Weights[j] * P_j(p[j]) >= Threshold,
+// where P_j is the predicate of the formula on the j-th column, encoded by Funcs[j].
+//
+// The formula is a boolean combination of comparisons on the columns of a Schema:
+//
+//	age in [40,65] AND bmi > 30 AND (diabetes OR hypertension) AND sex = F AND region in {1, 3}
+//
+// with the operators AND, OR, NOT and parenthesis, the comparisons <, <=, >, >=, = and !=
+// with a number or a category label, the numeric range "in [a, b]" and the categorical
+// set "in {x, y, ...}". A bare column name is true if the value is not zero.
+// Sub-formulas on a single column are merged into a single predicate, and the formula on
+// the predicates must be a nested combination of AND/OR in which each operator has at
+// most one operand that is not a single column predicate, e.g. "a AND (b OR (c AND d))".
+// Other formulas, e.g. "(a AND b) OR (c AND d)", cannot be expressed with a single
+// threshold and are rejected.
+type Criteria struct {
+	Formula   string
+	Funcs     []Func
+	Weights   []float64
+	Threshold float64
+}
+
+// CompileCriteria compiles a formula on the columns of the schema into a Criteria.
+// Numeric columns are evaluated on a grid of points-2 steps over the range of the
+// column, so values closer than half a step to a bound of a comparison can be
+// misclassified. If points is zero, the columns are evaluated on their public grid,
+// see Column.Grid, so that the test vectors carry no plaintext metadata beyond the
+// schema.
+func CompileCriteria(formula string, schema Schema, points int) (c Criteria, err error) {
+
+	if err = schema.Check(); err != nil {
+		return
+	}
+
+	if points < 0 || points != 0 && points <= 2 {
+		return c, fmt.Errorf("invalid #points=%d: must be greater than 2", points)
+	}
+
+	p := &criteriaParser{schema: schema}
+
+	if p.tokens, err = tokenizeCriteria(formula); err != nil {
+		return
+	}
+
+	var node *criteriaNode
+	if node, err = p.parse(); err != nil {
+		return
+	}
+
+	node = node.pushNot(false).collapse().flatten()
+
+	th, err := node.threshold(schema)
+	if err != nil {
+		return
+	}
+
+	if th.W > MaxCriteriaWeight {
+		return c, fmt.Errorf("cannot express %q: sum of weights %d > %d", formula, th.W, MaxCriteriaWeight)
+	}
+
+	c = Criteria{
+		Formula:   formula,
+		Funcs:     make([]Func, len(schema)),
+		Weights:   make([]float64, len(schema)),
+		Threshold: float64(th.T),
+	}
+
+	for j, col := range schema {
+
+		pred, ok := th.Leaves[j]
+
+		var w float64
+		if ok {
+			w = float64(th.Weights[j])
+		} else {
+			// Columns which are not in the formula have a zero weight
+			pred = func(x float64) bool { return false }
+		}
+
+		c.Weights[j] = w
+
+		// The missing values of a column with PolicyMissing do not meet the predicate
+		f := Func{Max: w, Policy: col.Policy}
+
+		if col.Type == Categorical {
+			f.Points = len(col.Categories)
+			f.Categorical = true
+		} else {
+			// Grid of points-2 steps over the range of the column, as
+			// the last step cannot be evaluated, see TestVectors.Evaluate
+			grid := col.Grid()
+			if points != 0 {
+				grid = numericGrid(col.Interval, points)
+			}
+
+			a, b := col.Interval[0], col.Interval[1]
+			step := (b - a) / float64(grid.Points-2)
+			f.Interval = grid.Interval
+			f.Points = grid.Points
+
+			// Removes the rounding errors of the grid points
+			p := pred
+			pred = func(x float64) bool { return p(a + math.Round((x-a)/step)*step) }
+		}
+
+		f.F = func(x float64) (y float64) {
+			if pred(x) {
+				return w / float64(Scaling)
+			}
+			return 0
+		}
+
+		c.Funcs[j] = f
+	}
+
+	return
+}
+
+// Score returns the score of a row, i.e. sum_j Funcs[j](row[j]) rounded as by TestVectors.Evaluate,
+// scaled back by Scaling.
+func (c Criteria) Score(row []float64) (score float64) {
+	for j, f := range c.Funcs {
+		score += f.F(quantize(f, row[j])) * float64(Scaling)
+	}
+	return
+}
+
+// Match returns true if the row meets the criteria.
+func (c Criteria) Match(row []float64) bool {
+	return c.Score(row) >= c.Threshold-0.5
+}
+
+// quantize returns the input of f encoded at the position of x in the test vector of f.
+func quantize(f Func, x float64) float64 {
+
+	if f.Categorical {
+		return x
+	}
+
+	a, b := f.Interval[0], f.Interval[1]
+	interval := 1.0 / float64(f.Points)
+
+	return normalizeInv(math.Round(normalize(x, a, b)/interval)*interval, a, b)
+}
+
+// GenEncryptedCriteria generates the encrypted functions and the two private thresholds
+// of a request selecting the patients meeting the criteria, and testing whether there
+// are at least minPatients of them.
+func (c Client) GenEncryptedCriteria(criteria Criteria, minPatients int) (tvs TestVectors, t0, t1 PrivateThreshold, err error) {
+
+	if tvs, err = c.GenEncryptedFunction(criteria.Funcs); err != nil {
+		return nil, t0, t1, fmt.Errorf("c.GenEncryptedFunction: %w", err)
+	}
+
+	if t0, err = c.GenPrivateThreshold(criteria.Threshold, criteria.Funcs); err != nil {
+		return nil, t0, t1, fmt.Errorf("c.GenPrivateThreshold: %w", err)
+	}
+
+	if t1, err = c.GenPrivateThreshold(float64(minPatients), nil); err != nil {
+		return nil, t0, t1, fmt.Errorf("c.GenPrivateThreshold: %w", err)
+	}
+
+	return
+}
+
+type criteriaOp int
+
+const (
+	criteriaLeaf criteriaOp = iota
+	criteriaAnd
+	criteriaOr
+	criteriaNot
+)
+
+// criteriaNode is a node of the syntax tree of a formula.
+type criteriaNode struct {
+	Op       criteriaOp
+	Children []*criteriaNode
+
+	// Leaf
+	Column int
+	Pred   func(x float64) bool
+	Text   string
+}
+
+func (n *criteriaNode) String() string {
+	switch n.Op {
+	case criteriaLeaf:
+		return n.Text
+	case criteriaNot:
+		return "NOT " + n.Children[0].String()
+	default:
+		op := " AND "
+		if n.Op == criteriaOr {
+			op = " OR "
+		}
+		s := make([]string, len(n.Children))
+		for i := range n.Children {
+			s[i] = n.Children[i].String()
+		}
+		return "(" + strings.Join(s, op) + ")"
+	}
+}
+
+// columns returns the columns of the sub-formula.
+func (n *criteriaNode) columns() (cols map[int]bool) {
+
+	if n.Op == criteriaLeaf {
+		return map[int]bool{n.Column: true}
+	}
+
+	cols = map[int]bool{}
+	for _, c := range n.Children {
+		for j := range c.columns() {
+			cols[j] = true
+		}
+	}
+
+	return
+}
+
+// eval evaluates the sub-formula on a single column.
+func (n *criteriaNode) eval(x float64) bool {
+	switch n.Op {
+	case criteriaLeaf:
+		return n.Pred(x)
+	case criteriaNot:
+		return !n.Children[0].eval(x)
+	case criteriaAnd:
+		for _, c := range n.Children {
+			if !c.eval(x) {
+				return false
+			}
+		}
+		return true
+	default:
+		for _, c := range n.Children {
+			if c.eval(x) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// pushNot pushes the negations to the leaves with De Morgan's laws.
+func (n *criteriaNode) pushNot(neg bool) *criteriaNode {
+	switch n.Op {
+	case criteriaLeaf:
+		if !neg {
+			return n
+		}
+		pred := n.Pred
+		return &criteriaNode{Op: criteriaLeaf, Column: n.Column, Pred: func(x float64) bool { return !pred(x) }, Text: "NOT " + n.Text}
+	case criteriaNot:
+		return n.Children[0].pushNot(!neg)
+	default:
+		op := n.Op
+		if neg {
+			if op == criteriaAnd {
+				op = criteriaOr
+			} else {
+				op = criteriaAnd
+			}
+		}
+		children := make([]*criteriaNode, len(n.Children))
+		for i := range n.Children {
+			children[i] = n.Children[i].pushNot(neg)
+		}
+		return &criteriaNode{Op: op, Children: children}
+	}
+}
+
+// collapse merges the sub-formulas on a single column into a single leaf.
+func (n *criteriaNode) collapse() *criteriaNode {
+
+	if n.Op == criteriaLeaf {
+		return n
+	}
+
+	if cols := n.columns(); len(cols) == 1 {
+		for j := range cols {
+			return &criteriaNode{Op: criteriaLeaf, Column: j, Pred: n.eval, Text: n.String()}
+		}
+	}
+
+	children := make([]*criteriaNode, len(n.Children))
+	for i := range n.Children {
+		children[i] = n.Children[i].collapse()
+	}
+
+	return &criteriaNode{Op: n.Op, Children: children}
+}
+
+// flatten merges nested operators of the same type.
+func (n *criteriaNode) flatten() *criteriaNode {
+
+	if n.Op == criteriaLeaf {
+		return n
+	}
+
+	var children []*criteriaNode
+	for _, c := range n.Children {
+		if c = c.flatten(); c.Op == n.Op {
+			children = append(children, c.Children...)
+		} else {
+			children = append(children, c)
+		}
+	}
+
+	return &criteriaNode{Op: n.Op, Children: children}
+}
+
+// criteriaThreshold is a threshold function sum_j Weights[j] * Leaves[j](x[j]) >= T
+// with W = sum_j Weights[j].
+type criteriaThreshold struct {
+	Leaves  map[int]func(x float64) bool
+	Weights map[int]int
+	T, W    int
+}
+
+// threshold returns the threshold function equivalent to the formula.
+// An AND (resp. OR) of leaves L_1, ..., L_k and of a threshold function f = (w, T, W)
+// is the threshold function with weights M=W-T+1 (resp. T) for the leaves, w for f,
+// and threshold k*M+T (resp. T).
+func (n *criteriaNode) threshold(schema Schema) (th criteriaThreshold, err error) {
+
+	th = criteriaThreshold{
+		Leaves:  map[int]func(x float64) bool{},
+		Weights: map[int]int{},
+	}
+
+	if n.Op == criteriaLeaf {
+		th.Leaves[n.Column] = n.Pred
+		th.Weights[n.Column] = 1
+		th.T, th.W = 1, 1
+		return
+	}
+
+	var leaves []*criteriaNode
+	var other *criteriaNode
+
+	for _, c := range n.Children {
+
+		if c.Op == criteriaLeaf {
+			leaves = append(leaves, c)
+			continue
+		}
+
+		if other != nil {
+			return th, fmt.Errorf("cannot express %s with a single threshold: %s and %s both combine several columns", n, other, c)
+		}
+
+		other = c
+	}
+
+	if other != nil {
+		if th, err = other.threshold(schema); err != nil {
+			return
+		}
+	}
+
+	var w int
+	switch {
+	case other == nil && n.Op == criteriaAnd:
+		w, th.T = 1, len(leaves)
+	case other == nil:
+		w, th.T = 1, 1
+	case n.Op == criteriaAnd:
+		w = th.W - th.T + 1
+		th.T += len(leaves) * w
+	default:
+		w = th.T
+	}
+
+	for _, c := range leaves {
+
+		if _, ok := th.Leaves[c.Column]; ok {
+			return th, fmt.Errorf("cannot express %s with a single threshold: column %q appears in several sub-formulas", n, schema[c.Column].Name)
+		}
+
+		th.Leaves[c.Column] = c.Pred
+		th.Weights[c.Column] = w
+		th.W += w
+	}
+
+	return
+}
+
+type criteriaToken struct {
+	Kind  string // "ident", "number", "string", "op", "punct", "eof"
+	Value string
+	Pos   int
+}
+
+func tokenizeCriteria(s string) (tokens []criteriaToken, err error) {
+
+	for i := 0; i < len(s); {
+
+		r := rune(s[i])
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, criteriaToken{Kind: "ident", Value: s[i:j], Pos: i})
+			i = j
+
+		case unicode.IsDigit(r) || r == '-' || r == '+' || r == '.':
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == 'e' || s[j] == 'E' || ((s[j] == '-' || s[j] == '+') && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			if _, err = strconv.ParseFloat(s[i:j], 64); err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", s[i:j], i)
+			}
+			tokens = append(tokens, criteriaToken{Kind: "number", Value: s[i:j], Pos: i})
+			i = j
+
+		case r == '"':
+			j := strings.IndexByte(s[i+1:], '"')
+			if j == -1 {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, criteriaToken{Kind: "string", Value: s[i+1 : i+1+j], Pos: i})
+			i += j + 2
+
+		case strings.ContainsRune("<>=!", r):
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			op := s[i:j]
+			if op == "!" {
+				return nil, fmt.Errorf("invalid operator %q at position %d", op, i)
+			}
+			if op == "==" {
+				op = "="
+			}
+			tokens = append(tokens, criteriaToken{Kind: "op", Value: op, Pos: i})
+			i = j
+
+		case strings.ContainsRune("()[]{},", r):
+			tokens = append(tokens, criteriaToken{Kind: "punct", Value: string(r), Pos: i})
+			i++
+
+		default:
+			return nil, fmt.Errorf("invalid character %q at position %d", r, i)
+		}
+	}
+
+	return append(tokens, criteriaToken{Kind: "eof", Pos: len(s)}), nil
+}
+
+type criteriaParser struct {
+	schema Schema
+	tokens []criteriaToken
+	pos    int
+}
+
+func (p *criteriaParser) peek() criteriaToken {
+	return p.tokens[p.pos]
+}
+
+func (p *criteriaParser) next() (t criteriaToken) {
+	t = p.tokens[p.pos]
+	if t.Kind != "eof" {
+		p.pos++
+	}
+	return
+}
+
+func (p *criteriaParser) keyword(kw string) bool {
+	if t := p.peek(); t.Kind == "ident" && strings.EqualFold(t.Value, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *criteriaParser) expect(punct string) (err error) {
+	if t := p.next(); t.Kind != "punct" || t.Value != punct {
+		return fmt.Errorf("expected %q at position %d", punct, t.Pos)
+	}
+	return
+}
+
+func (p *criteriaParser) parse() (n *criteriaNode, err error) {
+
+	if n, err = p.parseOr(); err != nil {
+		return
+	}
+
+	if t := p.peek(); t.Kind != "eof" {
+		return nil, fmt.Errorf("unexpected %q at position %d", t.Value, t.Pos)
+	}
+
+	return
+}
+
+func (p *criteriaParser) parseOr() (n *criteriaNode, err error) {
+	return p.parseBinary(criteriaOr, "OR", p.parseAnd)
+}
+
+func (p *criteriaParser) parseAnd() (n *criteriaNode, err error) {
+	return p.parseBinary(criteriaAnd, "AND", p.parseUnary)
+}
+
+func (p *criteriaParser) parseBinary(op criteriaOp, kw string, operand func() (*criteriaNode, error)) (n *criteriaNode, err error) {
+
+	if n, err = operand(); err != nil {
+		return
+	}
+
+	children := []*criteriaNode{n}
+
+	for p.keyword(kw) {
+
+		if n, err = operand(); err != nil {
+			return
+		}
+
+		children = append(children, n)
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+
+	return &criteriaNode{Op: op, Children: children}, nil
+}
+
+func (p *criteriaParser) parseUnary() (n *criteriaNode, err error) {
+
+	if p.keyword("NOT") {
+		if n, err = p.parseUnary(); err != nil {
+			return
+		}
+		return &criteriaNode{Op: criteriaNot, Children: []*criteriaNode{n}}, nil
+	}
+
+	if t := p.peek(); t.Kind == "punct" && t.Value == "(" {
+
+		p.next()
+
+		if n, err = p.parseOr(); err != nil {
+			return
+		}
+
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+
+		return
+	}
+
+	return p.parseAtom()
+}
+
+func (p *criteriaParser) parseAtom() (n *criteriaNode, err error) {
+
+	t := p.next()
+
+	if t.Kind != "ident" {
+		return nil, fmt.Errorf("expected a column name at position %d", t.Pos)
+	}
+
+	j := p.schema.Index(t.Value)
+	if j == -1 {
+		return nil, fmt.Errorf("unknown column %q at position %d", t.Value, t.Pos)
+	}
+
+	col := p.schema[j]
+
+	n = &criteriaNode{Op: criteriaLeaf, Column: j}
+
+	switch op := p.peek(); {
+
+	case op.Kind == "op":
+
+		p.next()
+
+		var v float64
+		if v, err = p.parseValue(col); err != nil {
+			return nil, err
+		}
+
+		switch op.Value {
+		case "<":
+			n.Pred = func(x float64) bool { return x < v }
+		case "<=":
+			n.Pred = func(x float64) bool { return x <= v }
+		case ">":
+			n.Pred = func(x float64) bool { return x > v }
+		case ">=":
+			n.Pred = func(x float64) bool { return x >= v }
+		case "=":
+			n.Pred = func(x float64) bool { return x == v }
+		case "!=":
+			n.Pred = func(x float64) bool { return x != v }
+		}
+
+		if col.Type == Categorical && op.Value != "=" && op.Value != "!=" {
+			return nil, fmt.Errorf("operator %q at position %d is not defined on categorical column %q", op.Value, op.Pos, col.Name)
+		}
+
+		n.Text = fmt.Sprintf("%s %s %v", col.Name, op.Value, v)
+
+	case op.Kind == "ident" && strings.EqualFold(op.Value, "in"):
+
+		p.next()
+
+		switch open := p.next(); {
+		case open.Kind == "punct" && open.Value == "[":
+
+			if col.Type == Categorical {
+				return nil, fmt.Errorf("range at position %d is not defined on categorical column %q", open.Pos, col.Name)
+			}
+
+			var a, b float64
+			if a, err = p.parseValue(col); err != nil {
+				return nil, err
+			}
+
+			if err = p.expect(","); err != nil {
+				return nil, err
+			}
+
+			if b, err = p.parseValue(col); err != nil {
+				return nil, err
+			}
+
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+
+			n.Pred = func(x float64) bool { return a <= x && x <= b }
+			n.Text = fmt.Sprintf("%s in [%v, %v]", col.Name, a, b)
+
+		case open.Kind == "punct" && open.Value == "{":
+
+			set := map[float64]bool{}
+
+			for {
+				var v float64
+				if v, err = p.parseValue(col); err != nil {
+					return nil, err
+				}
+
+				set[v] = true
+
+				if t := p.peek(); t.Kind == "punct" && t.Value == "," {
+					p.next()
+					continue
+				}
+
+				break
+			}
+
+			if err = p.expect("}"); err != nil {
+				return nil, err
+			}
+
+			n.Pred = func(x float64) bool { return set[x] }
+			n.Text = fmt.Sprintf("%s in %v", col.Name, set)
+
+		default:
+			return nil, fmt.Errorf("expected \"[\" or \"{\" at position %d", open.Pos)
+		}
+
+	default:
+		// Bare column: true if not zero
+		n.Pred = func(x float64) bool { return x != 0 }
+		n.Text = col.Name
+	}
+
+	return
+}
+
+// parseValue parses a number or, for categorical columns, a category label.
+func (p *criteriaParser) parseValue(col Column) (v float64, err error) {
+
+	t := p.next()
+
+	switch t.Kind {
+	case "number":
+		if v, err = strconv.ParseFloat(t.Value, 64); err != nil {
+			return 0, fmt.Errorf("invalid number %q at position %d", t.Value, t.Pos)
+		}
+
+		if col.Type == Categorical && (v < 0 || v >= float64(len(col.Categories)) || v != math.Trunc(v)) {
+			return 0, fmt.Errorf("invalid category index %v for column %q at position %d", v, col.Name, t.Pos)
+		}
+
+		return
+
+	case "ident", "string":
+		if col.Type == Categorical {
+			for i := range col.Categories {
+				if col.Categories[i] == t.Value {
+					return float64(i), nil
+				}
+			}
+			return 0, fmt.Errorf("unknown category %q of column %q at position %d", t.Value, col.Name, t.Pos)
+		}
+	}
+
+	return 0, fmt.Errorf("expected a value of column %q at position %d", col.Name, t.Pos)
+}
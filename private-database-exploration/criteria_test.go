@@ -0,0 +1,114 @@
+package pde
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCriteria(t *testing.T) {
+
+	schema := Schema{
+		{Name: "age", Interval: [2]float64{0, 120}},
+		{Name: "bmi", Interval: [2]float64{10, 60}},
+		{Name: "diabetes", Type: Categorical, Categories: []string{"no", "yes"}},
+		{Name: "hypertension", Type: Categorical, Categories: []string{"no", "yes"}},
+		{Name: "sex", Type: Categorical, Categories: []string{"F", "M"}},
+		{Name: "region", Type: Categorical, Categories: []string{"N", "E", "S", "W"}},
+	}
+
+	// Values on the grid of the numeric columns, so that the rounding does not change the predicates
+	points := 242
+
+	r := rand.New(rand.NewSource(0))
+
+	rows := make([][]float64, 2048)
+	for i := range rows {
+		rows[i] = []float64{
+			float64(r.Intn(241)) / 2,
+			10 + float64(r.Intn(101))/2,
+			float64(r.Intn(2)),
+			float64(r.Intn(2)),
+			float64(r.Intn(2)),
+			float64(r.Intn(4)),
+		}
+	}
+
+	for _, tc := range []struct {
+		formula string
+		want    func(p []float64) bool
+	}{
+		{"age >= 18", func(p []float64) bool { return p[0] >= 18 }},
+		{"age in [40,65] AND bmi > 30 AND (diabetes OR hypertension)", func(p []float64) bool {
+			return 40 <= p[0] && p[0] <= 65 && p[1] > 30 && (p[2] != 0 || p[3] != 0)
+		}},
+		{"age < 30 OR bmi >= 40 OR sex = M", func(p []float64) bool { return p[0] < 30 || p[1] >= 40 || p[4] == 1 }},
+		{"diabetes AND (sex = F OR (region in {E, 3} AND NOT (bmi < 25 OR age > 70)))", func(p []float64) bool {
+			return p[2] != 0 && (p[4] == 0 || ((p[5] == 1 || p[5] == 3) && !(p[1] < 25 || p[0] > 70)))
+		}},
+		// Sub-formulas on a single column are merged
+		{"(age < 20 OR age > 80) AND NOT (diabetes AND region != N)", func(p []float64) bool {
+			return (p[0] < 20 || p[0] > 80) && !(p[2] != 0 && p[5] != 0)
+		}},
+	} {
+		t.Run(tc.formula, func(t *testing.T) {
+
+			c, err := CompileCriteria(tc.formula, schema, points)
+			require.NoError(t, err)
+			require.NoError(t, schema.CheckFuncs(c.Funcs))
+
+			for j, f := range c.Funcs {
+				require.Equal(t, c.Weights[j], f.Max)
+			}
+
+			for _, p := range rows {
+				require.Equal(t, tc.want(p), c.Match(p), "%v", p)
+			}
+		})
+	}
+
+	t.Run("TestVectors", func(t *testing.T) {
+
+		// The compiled functions evaluate to the same score through the test vectors
+		c, err := CompileCriteria("age in [40,65] AND bmi > 30 AND (diabetes OR hypertension)", schema, points)
+		require.NoError(t, err)
+
+		fx := newFunctionFixture(t)
+		params, ecd, enc, dec := fx.params, fx.ecd, fx.enc, fx.dec
+		buffPoly, buffCt := fx.buffPoly, fx.buffCt
+
+		tvs := make(TestVectors, len(c.Funcs))
+		for j := range c.Funcs {
+			tvs[j], err = GenTestPolynomials(params, c.Funcs[j], ecd, enc)
+			require.NoError(t, err)
+		}
+
+		for _, p := range rows[:64] {
+			require.NoError(t, tvs.Evaluate(params, p, buffPoly, buffCt))
+			v := []float64{0}
+			require.NoError(t, ecd.Decode(dec.DecryptNew(buffCt), v))
+			require.InDelta(t, c.Score(p), v[0]*float64(Scaling), 1e-3)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, formula := range []string{
+			"",
+			"age >",
+			"age in [40, 65",
+			"weight > 80",
+			"sex > F",
+			"sex = X",
+			"region in {5}",
+			"(age > 40",
+			"age > 40 bmi",
+			// Not threshold functions
+			"(age > 40 AND bmi > 30) OR (diabetes AND hypertension)",
+			"(age > 40 AND bmi > 30) OR (age < 20 AND diabetes)",
+		} {
+			_, err := CompileCriteria(formula, schema, points)
+			require.Error(t, err, formula)
+		}
+	})
+}
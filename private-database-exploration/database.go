@@ -1,6 +1,10 @@
 package pde
 
 import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
 	"math"
 	"math/rand"
 
@@ -9,6 +13,9 @@ import (
 
 type Database struct {
 	*mat.Dense
+
+	// Schema is the description of the columns, nil for synthetic databases.
+	Schema Schema
 }
 
 func NewDatabase(p, h int) Database {
@@ -43,3 +50,147 @@ func (d Database) GetRow(i int) []float64 {
 	_, cols := d.Dims()
 	return d.RawMatrix().Data[i*cols : (i+1)*cols]
 }
+
+// RowError reports a row of a table that failed validation.
+type RowError struct {
+	// Row is the index of the row in the input, excluding the header.
+	Row    int
+	Column string
+	Err    error
+}
+
+func (e RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Err)
+	}
+	return fmt.Sprintf("row %d, column %q: %s", e.Row, e.Column, e.Err)
+}
+
+func (e RowError) Unwrap() error {
+	return e.Err
+}
+
+// LoadCSV reads a CSV table with a header row into a Database whose columns
+// are the columns of the schema, in the order of the schema. Additional
+// columns of the table are ignored. Rows with a missing or invalid value are
+// not loaded and are reported in invalid, unless the value is handled by the
+// policy of its column, see Column.Load.
+func LoadCSV(r io.Reader, schema Schema) (db Database, invalid []RowError, err error) {
+
+	if err = schema.Check(); err != nil {
+		return Database{}, nil, err
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return Database{}, nil, fmt.Errorf("csv.Read: header: %w", err)
+	}
+
+	// index[j] is the index in the table of the j-th column of the schema
+	index := make([]int, len(schema))
+	for j := range index {
+		index[j] = -1
+	}
+
+	for i, name := range header {
+		if j := schema.Index(name); j != -1 {
+			if index[j] != -1 {
+				return Database{}, nil, fmt.Errorf("duplicate column %q", name)
+			}
+			index[j] = i
+		}
+	}
+
+	for j := range index {
+		if index[j] == -1 {
+			return Database{}, nil, fmt.Errorf("missing column %q", schema[j].Name)
+		}
+	}
+
+	var m []float64
+	row := make([]float64, len(schema))
+
+	for i := 0; ; i++ {
+
+		var record []string
+		if record, err = cr.Read(); err != nil {
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && parseErr.Err == csv.ErrFieldCount {
+				invalid = append(invalid, RowError{Row: i, Err: err})
+				continue
+			}
+
+			return Database{}, nil, fmt.Errorf("csv.Read: %w", err)
+		}
+
+		valid := true
+
+		for j, c := range schema {
+
+			var field string
+			if index[j] < len(record) {
+				field = record[index[j]]
+			} else if !c.Handles(math.NaN()) {
+				invalid = append(invalid, RowError{Row: i, Column: c.Name, Err: fmt.Errorf("missing value")})
+				valid = false
+				break
+			}
+
+			if row[j], err = c.Load(field); err != nil {
+				invalid = append(invalid, RowError{Row: i, Column: c.Name, Err: err})
+				valid = false
+				break
+			}
+		}
+
+		if valid {
+			m = append(m, row...)
+		}
+	}
+
+	if len(m) == 0 {
+		return Database{}, invalid, fmt.Errorf("no valid row")
+	}
+
+	return Database{
+		Dense:  mat.NewDense(len(m)/len(schema), len(schema), m),
+		Schema: schema,
+	}, invalid, nil
+}
+
+// Validate checks that the values of each column are in the range of
+// the schema, or handled by the policy of the column, see Column.Handles,
+// and returns the rows that failed validation.
+func (db Database) Validate(schema Schema) (invalid []RowError, err error) {
+
+	if err = schema.Check(); err != nil {
+		return
+	}
+
+	rows, cols := db.Dims()
+
+	if cols != len(schema) {
+		return nil, fmt.Errorf("#columns=%d but the schema has %d columns", cols, len(schema))
+	}
+
+	for i := 0; i < rows; i++ {
+		for j, v := range db.GetRow(i) {
+			if err := schema[j].Validate(v); err != nil && !schema[j].Handles(v) {
+				invalid = append(invalid, RowError{Row: i, Column: schema[j].Name, Err: err})
+				break
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,171 @@
+package pde
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase(t *testing.T) {
+
+	schema, err := ReadSchema(strings.NewReader(`[
+		{"name": "age", "type": "numeric", "unit": "years", "interval": [0, 120]},
+		{"name": "bmi", "type": "numeric", "unit": "kg/m2", "interval": [10, 60]},
+		{"name": "sex", "type": "categorical", "categories": ["F", "M"]}
+	]`))
+	require.NoError(t, err)
+
+	t.Run("LoadCSV", func(t *testing.T) {
+
+		// Columns in a different order than the schema, with an additional column
+		csv := `id,sex,bmi,age
+0,F,22.5,41
+1,M,31.0,65
+2,X,25.0,30
+3,1,70.0,50
+4,0,abc,50
+5,M
+6,1,28.0,73
+`
+
+		db, invalid, err := LoadCSV(strings.NewReader(csv), schema)
+		require.NoError(t, err)
+
+		require.Equal(t, 3, db.Size())
+		require.Equal(t, []float64{41, 22.5, 0}, db.GetRow(0))
+		require.Equal(t, []float64{65, 31, 1}, db.GetRow(1))
+		require.Equal(t, []float64{73, 28, 1}, db.GetRow(2))
+
+		require.Len(t, invalid, 4)
+		require.Equal(t, 2, invalid[0].Row)
+		require.Equal(t, "sex", invalid[0].Column)
+		require.Equal(t, 3, invalid[1].Row)
+		require.Equal(t, "bmi", invalid[1].Column)
+		require.Equal(t, 4, invalid[2].Row)
+		require.Equal(t, 5, invalid[3].Row)
+
+		invalid, err = db.Validate(schema)
+		require.NoError(t, err)
+		require.Empty(t, invalid)
+	})
+
+	t.Run("Policies", func(t *testing.T) {
+
+		// Out of range BMIs are clamped and missing or unknown sexes are skipped
+		schema := append(Schema{}, schema...)
+		schema[1].Policy = PolicyClamp
+		schema[2].Policy = PolicySkip
+
+		csv := `age,bmi,sex
+41,70.0,F
+42,NA,F
+43,25.0,X
+44,25.0
+130,25.0,M
+`
+
+		db, invalid, err := LoadCSV(strings.NewReader(csv), schema)
+		require.NoError(t, err)
+
+		require.Equal(t, 3, db.Size())
+		require.Equal(t, []float64{41, 70, 0}, db.GetRow(0))
+		require.True(t, math.IsNaN(db.At(1, 2)))
+		require.True(t, math.IsNaN(db.At(2, 2)))
+
+		// Missing BMIs cannot be clamped and the age has no policy
+		require.Len(t, invalid, 2)
+		require.Equal(t, "bmi", invalid[0].Column)
+		require.Equal(t, "age", invalid[1].Column)
+
+		invalid, err = db.Validate(schema)
+		require.NoError(t, err)
+		require.Empty(t, invalid)
+
+		// Without the policies
+		schema[1].Policy = PolicyDefault
+		schema[2].Policy = PolicyDefault
+
+		invalid, err = db.Validate(schema)
+		require.NoError(t, err)
+		require.Len(t, invalid, 3)
+	})
+
+	t.Run("MissingColumn", func(t *testing.T) {
+		_, _, err := LoadCSV(strings.NewReader("age,sex\n40,F\n"), schema)
+		require.ErrorContains(t, err, "bmi")
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		// The values of the synthetic database are in [1, 2)
+		schema := Schema{
+			{Name: "a", Interval: [2]float64{1, 2}},
+			{Name: "b", Interval: [2]float64{1, 2}},
+		}
+		db := NewDatabase(4, len(schema))
+		db.Set(2, 1, 5)
+		invalid, err := db.Validate(schema)
+		require.NoError(t, err)
+		require.Len(t, invalid, 1)
+		require.Equal(t, 2, invalid[0].Row)
+		require.Equal(t, "b", invalid[0].Column)
+	})
+
+	t.Run("CheckFuncs", func(t *testing.T) {
+
+		funcs := []Func{
+			{Interval: [2]float64{0, 128}, Points: 128},
+			{Interval: [2]float64{0, 64}, Points: 64},
+			{Interval: [2]float64{0, 4}, Points: 4},
+		}
+
+		require.NoError(t, schema.CheckFuncs(funcs))
+
+		// The last step of the interval cannot be evaluated
+		funcs[0].Interval = [2]float64{0, 120}
+		require.ErrorContains(t, schema.CheckFuncs(funcs), "age")
+		funcs[0].Interval = [2]float64{0, 128}
+
+		// A multivariate function of the age and the sex replacing the first and last functions
+		joint := Func{
+			Columns: []int{0, 2},
+			Axes:    []Axis{{Interval: [2]float64{0, 128}, Points: 128}, {Points: 2, Categorical: true}},
+		}
+
+		require.NoError(t, schema.CheckFuncs([]Func{joint, funcs[1]}))
+
+		joint.Axes[0].Interval = [2]float64{0, 120}
+		require.ErrorContains(t, schema.CheckFuncs([]Func{joint, funcs[1]}), "age")
+
+		joint.Columns = []int{0, 3}
+		require.Error(t, schema.CheckFuncs([]Func{joint, funcs[1]}))
+	})
+
+	t.Run("Grid", func(t *testing.T) {
+
+		// Default grid of the age, the last step of which cannot be evaluated
+		age := schema[0].Grid()
+		require.Equal(t, DefaultGridPoints, age.Points)
+		require.Equal(t, 0.0, age.Interval[0])
+		require.InDelta(t, 120*float64(DefaultGridPoints)/float64(DefaultGridPoints-2), age.Interval[1], 1e-9)
+
+		require.Equal(t, Axis{Points: 2, Categorical: true}, schema[2].Grid())
+
+		funcs := make([]Func, len(schema))
+		for i := range schema {
+			funcs[i] = schema[i].Func(func(x float64) (y float64) { return 0 }, 0)
+		}
+
+		require.NoError(t, schema.CheckFuncs(funcs))
+
+		// Grids of more than 2 points on numeric columns only
+		invalid := append(Schema{}, schema...)
+		invalid[1].Points = 2
+		require.Error(t, invalid.Check())
+
+		invalid[1].Points = 0
+		invalid[2].Points = 4
+		require.Error(t, invalid.Check())
+	})
+}
@@ -0,0 +1,148 @@
+package pde
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+)
+
+// PartialCount is the response of a hospital to a federated request: the
+// identifier of the hospital, the number of patients of its database meeting
+// the criteria, encrypted under the key of the client, and the public number
+// of rows of its database.
+type PartialCount struct {
+	Hospital string
+	Count    *rlwe.Ciphertext
+	Rows     int
+}
+
+// Aggregate sums homomorphically the partial counts of the hospitals and
+// evaluates the global threshold against the total number of rows.
+// The aggregator only sees the encrypted partial counts and the numbers
+// of rows, and only the final binary output is ever decrypted.
+//
+// If the request has a Privacy, the aggregator instead adds noise to the
+// encrypted total count and returns the noisy count, after checking that its
+// cost does not exceed s.Accountant.MaxCost. The cost is charged to the privacy
+// budget of each hospital in s.Accountant once the noisy count is computed,
+// right before it is returned, so that an invalid or failed aggregation is not
+// charged.
+//
+// The evaluation stops with the error of ctx once ctx is done.
+func (s Server) Aggregate(ctx context.Context, cfg Config, r Request, partials []PartialCount, btp Bootstrapper) (score *rlwe.Ciphertext, err error) {
+
+	if len(partials) == 0 {
+		return nil, fmt.Errorf("no partial count")
+	}
+
+	if s, err = s.setup(cfg, r, btp); err != nil {
+		return nil, fmt.Errorf("s.setup: %w", err)
+	}
+
+	s.progress = newProgress(s.Observer, map[Stage]int{StageAggregation: 1})
+
+	// VALIDATION
+	var rows int
+	for i, p := range partials {
+
+		if ct := p.Count; ct == nil || ct.MetaData == nil || ct.Degree() != 1 || ct.Level() > s.ParamsEval.MaxLevel() || ct.Value[0].N() != s.ParamsEval.N() || ct.Value[1].N() != s.ParamsEval.N() {
+			return nil, fmt.Errorf("invalid partial count %d of hospital %q: malformed count", i, p.Hospital)
+		}
+
+		if p.Rows < 0 {
+			return nil, fmt.Errorf("invalid partial count %d of hospital %q: #rows=%d", i, p.Hospital, p.Rows)
+		}
+
+		rows += p.Rows
+	}
+
+	if rows <= 0 {
+		return nil, fmt.Errorf("invalid total #rows=%d", rows)
+	}
+
+	if r.Privacy != nil {
+
+		if s.Accountant == nil {
+			return nil, fmt.Errorf("a privacy accountant is required for a noisy count")
+		}
+
+		if err = s.Accountant.Check(*r.Privacy); err != nil {
+			return nil, fmt.Errorf("s.Accountant.Check: %w", err)
+		}
+
+	} else {
+
+		if r.PrivateThreshold1 == nil {
+			return nil, fmt.Errorf("missing the PrivateThreshold1")
+		}
+
+		if err = cfg.CheckGlobalThreshold(rows); err != nil {
+			return nil, fmt.Errorf("cfg.CheckGlobalThreshold: %w", err)
+		}
+	}
+
+	eval := s.GetEvaluator()
+
+	score = partials[0].Count.CopyNew()
+
+	for i := 1; i < len(partials); i++ {
+		if err = eval.Add(score, partials[i].Count, score); err != nil {
+			return nil, fmt.Errorf("eval.Add: %w", err)
+		}
+	}
+
+	s.PrintDebug("Aggregated Partial Counts", score, 1.0)
+
+	// NOISY COUNT
+	if r.Privacy != nil {
+
+		if err = s.AddNoise(score, *r.Privacy); err != nil {
+			return nil, fmt.Errorf("s.AddNoise: %w", err)
+		}
+
+		s.PrintDebug("Noisy Count", score, 1.0)
+
+		hospitals := make([]string, len(partials))
+		for i := range partials {
+			hospitals[i] = partials[i].Hospital
+		}
+
+		if err = s.Accountant.Spend(hospitals, r.Privacy.Cost()); err != nil {
+			return nil, fmt.Errorf("s.Accountant.Spend: %w", err)
+		}
+
+		s.progress.step(StageAggregation)
+
+		return
+	}
+
+	// GLOBAL THRESHOLD
+	if score, err = s.GlobalThreshold(ctx, score, r.PrivateThreshold1.Threshold, rows); err != nil {
+		return nil, fmt.Errorf("s.GlobalThreshold: %w", err)
+	}
+
+	s.PrintDebug("Global Threshold", score, 1.0)
+
+	s.progress.step(StageAggregation)
+
+	return
+}
+
+// AddNoise adds to the encrypted count a sample of the noise of dp,
+// drawn from a cryptographically secure source. The server learns the
+// noise but not the count, and the client only the sum of both.
+func (s Server) AddNoise(count *rlwe.Ciphertext, dp DifferentialPrivacy) (err error) {
+
+	var noise int64
+	if noise, err = dp.Sample(rand.Reader); err != nil {
+		return fmt.Errorf("dp.Sample: %w", err)
+	}
+
+	if err = s.GetEvaluator().Add(count, float64(noise), count); err != nil {
+		return fmt.Errorf("eval.Add: %w", err)
+	}
+
+	return
+}
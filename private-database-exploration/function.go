@@ -15,8 +15,55 @@ type Func struct {
 	Interval [2]float64
 	Max      float64
 	Points   int
+
+	// Categorical functions are defined on the category indexes [0, Points),
+	// which are mapped directly to the coefficients of the test vector,
+	// without normalization or rounding. Interval is ignored.
+	Categorical bool
+
+	// Interpolated functions are evaluated to first order between the points:
+	// f(x) ~ f(x_i) + (x - x_i)/(x_{i+1} - x_i) * (f(x_{i+1}) - f(x_i)) where x_i is
+	// the grid point below x, with a second test vector encoding the discrete slope
+	// of f. Categorical functions cannot be interpolated.
+	Interpolate bool
+
+	// Columns are the indexes of the columns of the database consumed by the function.
+	// If empty, the i-th function of a request consumes the i-th column.
+	Columns []int
+
+	// Axes are the grids of the inputs of a multivariate function, which consumes the
+	// column Columns[k] on the grid Axes[k] and is evaluated with Joint instead of F.
+	// It is tabulated on the product of the grids, indexed by the joint position
+	// sum_k position_k * prod_{l<k} Axes[l].Points, so that its test vector has
+	// prod_k Axes[k].Points points. Interval, Points and Categorical are ignored,
+	// and multivariate functions cannot be interpolated.
+	Axes []Axis
+
+	// Joint is the function of a multivariate function, see Axes.
+	Joint func(x []float64) (y float64)
+
+	// Policy is the handling of the missing and out of grid values of the columns
+	// consumed by the function, by default the policy of the schema, see ValuePolicy.
+	Policy ValuePolicy
+
+	// Missing is the score of the missing and out of grid values with PolicyMissing,
+	// encoded at the missing index Points of the test vector, after the grid.
+	Missing float64
+}
+
+// Axis is the grid of an input of a multivariate Func, with the
+// same semantic as the Interval, Points and Categorical of a Func.
+type Axis struct {
+	Interval    [2]float64
+	Points      int
+	Categorical bool
 }
 
+// interpolationLogScale is the precision in bits of the fractional part of the
+// interpolated values: the server multiplies the test vector of the slope, which
+// is scaled by 2^{-interpolationLogScale}, by the integer round(frac * 2^{interpolationLogScale}).
+const interpolationLogScale = 8
+
 func NewScoringFunction(interval [2]float64, points int, scaling float64) Func {
 	return Func{
 		F: func(x float64) (y float64) {
@@ -33,53 +80,167 @@ func NewScoringFunction(interval [2]float64, points int, scaling float64) Func {
 	}
 }
 
+// NewCategoricalScoringFunction returns a categorical Func
+// mapping the i-th category to scores[i].
+func NewCategoricalScoringFunction(scores []float64) Func {
+
+	var max float64
+	for _, s := range scores {
+		max = math.Max(max, s)
+	}
+
+	return Func{
+		F: func(x float64) (y float64) {
+			return scores[int(x)]
+		},
+		Points:      len(scores),
+		Max:         max,
+		Categorical: true,
+	}
+}
+
+// NewCategoricalSetFunction returns a categorical Func over the given number of
+// categories, which returns score if the category is in the set and 0 otherwise.
+func NewCategoricalSetFunction(categories int, set []int, score float64) (f Func, err error) {
+
+	scores := make([]float64, categories)
+
+	for _, c := range set {
+
+		if c < 0 || c >= categories {
+			return Func{}, fmt.Errorf("category %d not in [0, %d)", c, categories)
+		}
+
+		scores[c] = score
+	}
+
+	return NewCategoricalScoringFunction(scores), nil
+}
+
 // TestVector is a set of polynomials encoding a function f(x) = y.
 type TestVector struct {
-	Value    structs.Vector[rlwe.Ciphertext]
-	Interval [2]float64
-	Points   int
+	Value       structs.Vector[rlwe.Ciphertext]
+	Interval    [2]float64
+	Points      int
+	Categorical bool
+
+	// Slope encodes the discrete slope (f(x_{i+1}) - f(x_i)) * 2^{-8} of an
+	// interpolated function, see Func.Interpolate, and is empty otherwise.
+	// It is zero from the last grid point on.
+	Slope structs.Vector[rlwe.Ciphertext]
+
+	// Columns are the columns consumed by the function, see Func.Columns.
+	Columns []int
+
+	// Axes are the grids of a multivariate function, see Func.Axes, whose
+	// test vector is categorical and indexed by the joint position.
+	Axes []Axis
+
+	// Policy is the policy of the function, see Func.Policy. The test vector
+	// has a missing index, Points, if and only if it is PolicyMissing.
+	Policy ValuePolicy
 }
 
-type TestVectors []TestVector
+// position returns the position in the test vector, the i-th of a request, at which
+// the row is looked up, the fraction of a step of an interpolated lookup, see gridPosition,
+// and the policies applied to the values of the row, see ValuePolicy. If the policies
+// include PolicySkip, the position is meaningless and the row must be skipped.
+func (t TestVector) position(row []float64, i int, interpolate bool) (position int, frac float64, applied policySet, err error) {
 
-func (tv TestVectors) BinarySize() (size int) {
+	columns := consumedColumns(t.Columns, i)
 
-	for _, v := range tv {
-		size += v.Value.BinarySize()
-		size += 24
+	for _, c := range columns {
+		if c < 0 || c >= len(row) {
+			return 0, 0, 0, fmt.Errorf("column %d not in [0, %d)", c, len(row))
+		}
+	}
+
+	if len(t.Axes) == 0 {
+
+		if len(columns) != 1 {
+			return 0, 0, 0, fmt.Errorf("univariate function on %d columns", len(columns))
+		}
+
+		var policy ValuePolicy
+		if position, frac, policy, err = lookup(row[columns[0]], t.Interval, t.Points, t.Categorical, interpolate, t.Policy); err != nil {
+			return
+		}
+
+		applied = 1 << policy
+
+	} else {
+
+		if len(columns) != len(t.Axes) {
+			return 0, 0, 0, fmt.Errorf("multivariate function on %d axes but %d columns", len(t.Axes), len(columns))
+		}
+
+		if position, applied, err = jointPosition(t.Axes, columns, row, t.Policy); err != nil {
+			return
+		}
+
+		if position >= t.Points {
+			return 0, 0, 0, fmt.Errorf("joint position %d not in [0, %d)", position, t.Points)
+		}
+	}
+
+	if applied.has(PolicyMissing) {
+		position, frac = t.Points, 0
 	}
 
 	return
 }
 
+type TestVectors []TestVector
+
+// Evaluate evaluates the sum of the functions of the test vectors on a row, the i-th
+// test vector consuming the columns of the row declared in its Columns, by default the
+// i-th column, and writes it on the constant coefficient of buffCt. The missing and out
+// of grid values are handled by the Policy of the test vectors, where PolicyDefault
+// is PolicyError, and buffCt is zero if the row is skipped.
 func (tv TestVectors) Evaluate(params hefloat.Parameters, values []float64, buffPoly ring.Poly, buffCt *rlwe.Ciphertext) (err error) {
-	if len(tv) != len(values) {
-		return fmt.Errorf("len(TestVectors) != len(values)")
-	}
+	_, err = tv.evaluate(params, values, buffPoly, buffCt)
+	return
+}
+
+// evaluate is TestVectors.Evaluate, which also returns the policies applied to the values of the row.
+func (tv TestVectors) evaluate(params hefloat.Parameters, values []float64, buffPoly ring.Poly, buffCt *rlwe.Ciphertext) (applied policySet, err error) {
 
 	N := params.N()
 	ringQ := params.RingQ()
 
-	for i := range values {
+	for i := range tv {
 
 		t := tv[i]
 
-		// Step size
-		interval := 1.0 / float64(t.Points)
+		interpolate := len(t.Slope) != 0
 
-		// Maps the value to [0, 1]
-		value := normalize(values[i], t.Interval[0], t.Interval[1])
+		if interpolate && len(t.Slope) != len(t.Value) {
+			return 0, fmt.Errorf("invalid TestVector: len(Slope)=%d != len(Value)=%d", len(t.Slope), len(t.Value))
+		}
 
-		if value + interval >= 1 {
-			return fmt.Errorf("%f not in [%f, %f] or too close to %f", values[i], t.Interval[0], t.Interval[1], t.Interval[1])
+		var position int
+		var frac float64
+		var policies policySet
+		if position, frac, policies, err = t.position(values, i, interpolate); err != nil {
+			return
 		}
 
-		// Computes the index given the value in [0, 1] and the step size
-		position := int(math.Round(value / interval))
+		applied |= policies
+
+		// The row contributes an encryption of zero
+		if applied.has(PolicySkip) {
+			buffCt.Value[0].Zero()
+			buffCt.Value[1].Zero()
+			return applied, nil
+		}
 
 		hi := int(position) / N       // Index of the ciphertext
 		lo := int(position) & (N - 1) // Index of X^{i}
 
+		if hi >= len(t.Value) {
+			return 0, fmt.Errorf("invalid TestVector: position %d but %d ciphertexts", position, len(t.Value))
+		}
+
 		buffPoly.Zero()
 		buffPoly.Coeffs[0][lo] = 1
 		ringQ.NTT(buffPoly, buffPoly)
@@ -91,6 +252,17 @@ func (tv TestVectors) Evaluate(params hefloat.Parameters, values []float64, buff
 			ringQ.MulCoeffsMontgomeryThenAdd(t.Value[hi].Value[0], buffPoly, buffCt.Value[0])
 			ringQ.MulCoeffsMontgomeryThenAdd(t.Value[hi].Value[1], buffPoly, buffCt.Value[1])
 		}
+
+		// Adds frac * (f(x_{i+1}) - f(x_i))
+		if c := uint64(frac * (1 << interpolationLogScale)); interpolate && c != 0 {
+
+			buffPoly.Zero()
+			buffPoly.Coeffs[0][lo] = c
+			ringQ.NTT(buffPoly, buffPoly)
+
+			ringQ.MulCoeffsMontgomeryThenAdd(t.Slope[hi].Value[0], buffPoly, buffCt.Value[0])
+			ringQ.MulCoeffsMontgomeryThenAdd(t.Slope[hi].Value[1], buffPoly, buffCt.Value[1])
+		}
 	}
 
 	return
@@ -99,57 +271,229 @@ func (tv TestVectors) Evaluate(params hefloat.Parameters, values []float64, buff
 // GenTestPolynomials generates a TestPolynomial from a function.
 func GenTestPolynomials(params hefloat.Parameters, f Func, ecd *hefloat.Encoder, enc *rlwe.Encryptor) (TestVector, error) {
 
-	points := f.Points
-	a, b := f.Interval[0], f.Interval[1]
+	if f.Categorical && f.Interpolate {
+		return TestVector{}, fmt.Errorf("categorical functions cannot be interpolated")
+	}
 
-	u := make([]float64, params.N())
+	if len(f.Axes) != 0 {
+
+		if f.Joint == nil || len(f.Columns) != len(f.Axes) || f.Interpolate {
+			return TestVector{}, fmt.Errorf("a multivariate function must have a Joint function, one column per axis and cannot be interpolated")
+		}
+
+		for k, a := range f.Axes {
+			if a.Points <= 0 {
+				return TestVector{}, fmt.Errorf("invalid axis %d: #points %d", k, a.Points)
+			}
+		}
+
+	} else if len(f.Columns) > 1 {
+		return TestVector{}, fmt.Errorf("a univariate function consumes one column but has %d", len(f.Columns))
+	}
+
+	tv := f.testVector()
 
-	interval := 1.0 / float64(points)
+	// The missing index is encoded after the grid
+	points := tv.Points
+	if f.Policy == PolicyMissing {
+		points++
+	}
+
+	u := make([]float64, params.N())
 
 	pt := hefloat.NewPlaintext(params, 0)
 	pt.IsBatched = false
 
 	N := params.N()
-	Value := make([]rlwe.Ciphertext, (points+N-1)/N)
-
-	var err error
 
 	ringQ := params.RingQ().AtLevel(0)
 
-	for i := range Value {
+	// encrypt encrypts the test vector of y(i), the value at the i-th position
+	encrypt := func(y func(i int) float64) (Value []rlwe.Ciphertext, err error) {
 
-		start := i * N
-		end := start + N
+		Value = make([]rlwe.Ciphertext, (points+N-1)/N)
+
+		for i := range Value {
+
+			start := i * N
+
+			u[0] = y(start)
+			for j := 1; j < N; j++ {
+				// Categorical functions are only defined on [0, Points)
+				if tv.Categorical && j+start >= points {
+					u[N-j] = 0
+					continue
+				}
+				u[N-j] = -y(j + start)
+			}
 
-		if end > points {
-			end = points
+			if err = ecd.Encode(u, pt); err != nil {
+				return nil, fmt.Errorf("ecd.Encode: %w", err)
+			}
+
+			var ct *rlwe.Ciphertext
+			if ct, err = enc.EncryptNew(pt); err != nil {
+				return nil, fmt.Errorf("enc.EncryptNew: %w", err)
+			}
+
+			ringQ.MForm(ct.Value[0], ct.Value[0])
+			ringQ.MForm(ct.Value[1], ct.Value[1])
+
+			Value[i] = *ct
 		}
 
-		u[0] = f.F(normalizeInv(interval*float64(start), a, b))
-		for j := 1; j < N; j++ {
-			u[N-j] = -f.F(normalizeInv(interval*float64(j+start), a, b))
+		return
+	}
+
+	var err error
+	if tv.Value, err = encrypt(func(i int) float64 {
+		if f.Policy == PolicyMissing && i == tv.Points {
+			return f.Missing
 		}
+		return f.value(i)
+	}); err != nil {
+		return TestVector{}, err
+	}
 
-		if err = ecd.Encode(u, pt); err != nil {
-			return TestVector{}, fmt.Errorf("ecd.Encode: %w", err)
+	if f.Interpolate {
+		if tv.Slope, err = encrypt(func(i int) float64 {
+			// There is no next grid point after the last one, nor after the missing index
+			if i >= tv.Points-1 {
+				return 0
+			}
+			return (f.value(i+1) - f.value(i)) / (1 << interpolationLogScale)
+		}); err != nil {
+			return TestVector{}, err
 		}
+	}
+
+	return tv, nil
+}
 
-		var ct *rlwe.Ciphertext
-		if ct, err = enc.EncryptNew(pt); err != nil {
-			return TestVector{}, fmt.Errorf("enc.EncryptNew: %w", err)
+// gridPosition returns the position in a test vector of the given number of points,
+// over the interval or on the category indexes, at which the value is looked up.
+// If interpolate, the position is the grid point below the value, and frac is the
+// distance of the value to the position, in steps, rounded to 2^{-8}, else the
+// position is the nearest grid point and frac is zero.
+func gridPosition(value float64, interval [2]float64, points int, categorical, interpolate bool) (position int, frac float64, err error) {
+
+	if categorical {
+
+		// The value is the index of the category
+		if value < 0 || value >= float64(points) || value != math.Trunc(value) {
+			return 0, 0, fmt.Errorf("%f is not a category index in [0, %d)", value, points)
 		}
 
-		ringQ.MForm(ct.Value[0], ct.Value[0])
-		ringQ.MForm(ct.Value[1], ct.Value[1])
+		return int(value), 0, nil
+	}
+
+	// Step size
+	step := 1.0 / float64(points)
 
-		Value[i] = *ct
+	// Maps the value to [0, 1]
+	x := normalize(value, interval[0], interval[1])
+
+	if !(x >= 0 && x+step < 1) {
+		return 0, 0, fmt.Errorf("%f not in [%f, %f] or too close to %f", value, interval[0], interval[1], interval[1])
+	}
+
+	if interpolate {
+		position := math.Floor(x / step)
+		return int(position), math.Round((x/step-position)*(1<<interpolationLogScale)) / (1 << interpolationLogScale), nil
+	}
+
+	// Computes the index given the value in [0, 1] and the step size
+	return int(math.Round(x / step)), 0, nil
+}
+
+// gridInput returns the input at the i-th position of a test vector of the given
+// number of points, over the interval or on the category indexes.
+func gridInput(i int, interval [2]float64, points int, categorical bool) float64 {
+	if categorical {
+		return float64(i)
+	}
+	return normalizeInv(1.0/float64(points)*float64(i), interval[0], interval[1])
+}
+
+// testVector returns the plaintext metadata of the test vector of f, without its ciphertexts.
+func (f Func) testVector() TestVector {
+
+	if len(f.Axes) != 0 {
+		return TestVector{
+			Points:      jointPoints(f.Axes),
+			Categorical: true,
+			Columns:     f.Columns,
+			Axes:        f.Axes,
+			Policy:      f.Policy,
+		}
 	}
 
 	return TestVector{
-		Interval: [2]float64{a, b},
-		Points:   points,
-		Value:    Value,
-	}, nil
+		Interval:    f.Interval,
+		Points:      f.Points,
+		Categorical: f.Categorical,
+		Columns:     f.Columns,
+		Policy:      f.Policy,
+	}
+}
+
+// value returns the value of f at the i-th position of its test vector.
+func (f Func) value(i int) float64 {
+	if len(f.Axes) != 0 {
+		return f.Joint(jointInput(f.Axes, i))
+	}
+	return f.F(gridInput(i, f.Interval, f.Points, f.Categorical))
+}
+
+// consumedColumns returns the columns consumed by the i-th function of a request, see Func.Columns.
+func consumedColumns(columns []int, i int) []int {
+	if len(columns) == 0 {
+		return []int{i}
+	}
+	return columns
+}
+
+// jointPoints returns the number of points of the product of the grids of the axes.
+func jointPoints(axes []Axis) (points int) {
+	points = 1
+	for _, a := range axes {
+		points *= a.Points
+	}
+	return
+}
+
+// jointPosition returns the joint position at which the values of the columns of the
+// row are looked up on the grids of the axes, see Func.Axes, and the policies applied
+// to the values, see TestVector.position.
+func jointPosition(axes []Axis, columns []int, row []float64, policy ValuePolicy) (position int, applied policySet, err error) {
+
+	for k := len(axes) - 1; k >= 0; k-- {
+
+		var p int
+		var axisPolicy ValuePolicy
+		if p, _, axisPolicy, err = lookup(row[columns[k]], axes[k].Interval, axes[k].Points, axes[k].Categorical, false, policy); err != nil {
+			return 0, 0, fmt.Errorf("axis %d: %w", k, err)
+		}
+
+		applied |= 1 << axisPolicy
+
+		position = position*axes[k].Points + p
+	}
+
+	return
+}
+
+// jointInput returns the inputs at the i-th joint position of the grids of the axes.
+func jointInput(axes []Axis, i int) (x []float64) {
+
+	x = make([]float64, len(axes))
+
+	for k, a := range axes {
+		x[k] = gridInput(i%a.Points, a.Interval, a.Points, a.Categorical)
+		i /= a.Points
+	}
+
+	return
 }
 
 // [a, b] -> [0, 1]
@@ -3,14 +3,27 @@ package pde
 import (
 	"fmt"
 	"math"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
 	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/ring"
 )
 
-func TestFunction(t *testing.T) {
+// functionFixture is the key material and the buffers shared by the tests of the test vectors.
+type functionFixture struct {
+	params   hefloat.Parameters
+	ecd      *hefloat.Encoder
+	enc      *rlwe.Encryptor
+	dec      *rlwe.Decryptor
+	buffPoly ring.Poly
+	buffCt   *rlwe.Ciphertext
+}
+
+func newFunctionFixture(t *testing.T) (fx functionFixture) {
 
 	params, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{
 		LogN:            12,
@@ -18,13 +31,48 @@ func TestFunction(t *testing.T) {
 		LogP:            []int{60},
 		LogDefaultScale: 40,
 	})
+	require.NoError(t, err)
 
-	kgen := hefloat.NewKeyGenerator(params)
-	sk := kgen.GenSecretKeyNew()
+	sk := hefloat.NewKeyGenerator(params).GenSecretKeyNew()
 
-	ecd := hefloat.NewEncoder(params)
-	enc := hefloat.NewEncryptor(params, sk)
-	dec := hefloat.NewDecryptor(params, sk)
+	fx = functionFixture{
+		params:   params,
+		ecd:      hefloat.NewEncoder(params),
+		enc:      hefloat.NewEncryptor(params, sk),
+		dec:      hefloat.NewDecryptor(params, sk),
+		buffPoly: params.RingQ().NewPoly(),
+		buffCt:   hefloat.NewCiphertext(params, 1, 0),
+	}
+
+	fx.buffCt.IsBatched = false
+
+	return
+}
+
+func TestFunction(t *testing.T) {
+
+	fx := newFunctionFixture(t)
+
+	for _, tc := range []struct {
+		name string
+		test func(t *testing.T, fx functionFixture)
+	}{
+		{"Numeric", testNumericFunction},
+		{"Categorical", testCategoricalFunction},
+		{"Interpolated", testInterpolatedFunction},
+		{"Multivariate", testMultivariateFunction},
+		{"ValuePolicies", testValuePolicies},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.test(t, fx)
+		})
+	}
+}
+
+func testNumericFunction(t *testing.T, fx functionFixture) {
+
+	params, ecd, enc, dec := fx.params, fx.ecd, fx.enc, fx.dec
+	buffPoly, buffCt := fx.buffPoly, fx.buffCt
 
 	a := -20.0
 	b := 20.0
@@ -46,11 +94,6 @@ func TestFunction(t *testing.T) {
 
 	polys := TestVectors([]TestVector{poly, poly})
 
-	buffCt := hefloat.NewCiphertext(params, 1, 0)
-	buffCt.IsBatched = false
-
-	buffPoly := params.RingQ().NewPoly()
-
 	for i := 0; i < points; i++ {
 		x := a + float64(i)*step
 		v := []float64{0}
@@ -60,6 +103,338 @@ func TestFunction(t *testing.T) {
 	}
 }
 
+func testCategoricalFunction(t *testing.T, fx functionFixture) {
+
+	params, ecd, enc, dec := fx.params, fx.ecd, fx.enc, fx.dec
+	buffPoly, buffCt := fx.buffPoly, fx.buffCt
+
+	// Region in {1, 3}, one score per sex and more categories than N for diagnosis codes
+	region, err := NewCategoricalSetFunction(5, []int{1, 3}, 1)
+	require.NoError(t, err)
+
+	sex := NewCategoricalScoringFunction([]float64{0.25, 0.5})
+
+	diagnosis, err := NewCategoricalSetFunction(params.N()+3, []int{7, params.N() + 1}, 2)
+	require.NoError(t, err)
+
+	// Numeric function in the same query
+	age := Func{
+		F: func(x float64) (y float64) {
+			if x >= 40 {
+				return 4
+			}
+			return 0
+		},
+		Interval: [2]float64{0, 128},
+		Points:   128,
+		Max:      4,
+	}
+
+	funcs := []Func{region, sex, diagnosis, age}
+
+	tvs := make(TestVectors, len(funcs))
+	for i := range funcs {
+		tvs[i], err = GenTestPolynomials(params, funcs[i], ecd, enc)
+		require.NoError(t, err)
+	}
+
+	require.True(t, tvs[0].Categorical)
+	require.Len(t, tvs[2].Value, 2)
+
+	for _, row := range [][]float64{
+		{0, 0, 0, 20},
+		{1, 1, 7, 41},
+		{3, 0, float64(params.N() + 1), 39},
+		{4, 1, float64(params.N() + 2), 100},
+	} {
+
+		var want float64
+		for i := range funcs {
+			want += funcs[i].F(row[i])
+		}
+
+		require.NoError(t, tvs.Evaluate(params, row, buffPoly, buffCt))
+
+		v := []float64{0}
+		require.NoError(t, ecd.Decode(dec.DecryptNew(buffCt), v))
+		require.InDelta(t, want, v[0], 1e-8)
+	}
+
+	// Not a category index
+	require.Error(t, tvs.Evaluate(params, []float64{5, 0, 0, 0}, buffPoly, buffCt))
+	require.Error(t, tvs.Evaluate(params, []float64{0, 0.5, 0, 0}, buffPoly, buffCt))
+
+	schema := Schema{
+		{Name: "region", Type: Categorical, Categories: []string{"a", "b", "c", "d", "e"}},
+		{Name: "sex", Type: Categorical, Categories: []string{"F", "M"}},
+	}
+
+	require.NoError(t, schema.CheckFuncs(funcs[:2]))
+	require.Error(t, schema.CheckFuncs([]Func{funcs[1], funcs[0]}))
+}
+
+func testInterpolatedFunction(t *testing.T, fx functionFixture) {
+
+	params, ecd, enc, dec := fx.params, fx.ecd, fx.enc, fx.dec
+	buffPoly, buffCt := fx.buffPoly, fx.buffCt
+
+	var err error
+
+	// Few points over several periods
+	nearest := Func{F: math.Sin, Interval: [2]float64{0, 8}, Points: 64, Max: 1}
+
+	interpolated := nearest
+	interpolated.Interpolate = true
+
+	tvs := make(TestVectors, 2)
+	for i, f := range []Func{nearest, interpolated} {
+		tvs[i], err = GenTestPolynomials(params, f, ecd, enc)
+		require.NoError(t, err)
+	}
+
+	require.Empty(t, tvs[0].Slope)
+	require.Len(t, tvs[1].Slope, len(tvs[1].Value))
+
+	var errNearest, errInterpolated float64
+
+	for x := 0.0; x < 7.8; x += 0.01 {
+
+		for i, f := range []Func{nearest, interpolated} {
+
+			require.NoError(t, tvs[i:i+1].Evaluate(params, []float64{x}, buffPoly, buffCt))
+
+			v := []float64{0}
+			require.NoError(t, ecd.Decode(dec.DecryptNew(buffCt), v))
+
+			// Matches the oracle on the same grid
+			want, err := Oracle{Funcs: []Func{f}}.Score([]float64{x})
+			require.NoError(t, err)
+			require.InDelta(t, want/float64(Scaling), v[0], 1e-8)
+
+			if f.Interpolate {
+				errInterpolated = math.Max(errInterpolated, math.Abs(v[0]-math.Sin(x)))
+			} else {
+				errNearest = math.Max(errNearest, math.Abs(v[0]-math.Sin(x)))
+			}
+		}
+	}
+
+	// First order: the error is quadratic in the step instead of linear
+	require.Less(t, errInterpolated, 0.005)
+	require.Less(t, 10*errInterpolated, errNearest)
+
+	// The last cell and the missing value
+	missing := interpolated
+	missing.Policy = PolicyMissing
+	missing.Missing = 0.5
+
+	tv, err := GenTestPolynomials(params, missing, ecd, enc)
+	require.NoError(t, err)
+
+	for _, x := range []float64{7.75, 7.87, math.NaN()} {
+
+		require.NoError(t, TestVectors{tv}.Evaluate(params, []float64{x}, buffPoly, buffCt))
+
+		v := []float64{0}
+		require.NoError(t, ecd.Decode(dec.DecryptNew(buffCt), v))
+
+		want, err := Oracle{Funcs: []Func{missing}}.Score([]float64{x})
+		require.NoError(t, err)
+		require.InDelta(t, want/float64(Scaling), v[0], 1e-8, "%v", x)
+	}
+
+	// The slope is zero at the last grid point and at the missing index
+	ct := tv.Slope[0].CopyNew()
+	params.RingQ().AtLevel(ct.Level()).IMForm(ct.Value[0], ct.Value[0])
+	params.RingQ().AtLevel(ct.Level()).IMForm(ct.Value[1], ct.Value[1])
+
+	slope := make([]float64, params.N())
+	require.NoError(t, ecd.Decode(dec.DecryptNew(ct), slope))
+
+	require.InDelta(t, (missing.value(63)-missing.value(62))/(1<<interpolationLogScale), -slope[params.N()-62], 1e-8)
+	require.InDelta(t, 0, slope[params.N()-63], 1e-8)
+	require.InDelta(t, 0, slope[params.N()-64], 1e-8)
+
+	// Categorical functions cannot be interpolated
+	_, err = GenTestPolynomials(params, Func{F: math.Sin, Points: 4, Categorical: true, Interpolate: true}, ecd, enc)
+	require.Error(t, err)
+}
+
+func testMultivariateFunction(t *testing.T, fx functionFixture) {
+
+	params, ecd, enc, dec := fx.params, fx.ecd, fx.enc, fx.dec
+	buffPoly, buffCt := fx.buffPoly, fx.buffCt
+
+	var err error
+
+	// Rows of (age, weight, height)
+	age := Func{
+		F:        func(x float64) (y float64) { return x / 128 },
+		Interval: [2]float64{0, 128},
+		Points:   128,
+		Max:      1,
+	}
+
+	// BMI > 30 on a grid of weights in [40, 168) kg and heights in [1.40, 2.04) m,
+	// whose test vector spans several ciphertexts
+	bmi := Func{
+		Joint: func(x []float64) (y float64) {
+			if x[0]/(x[1]*x[1]) > 30 {
+				return 1
+			}
+			return 0
+		},
+		Columns: []int{1, 2},
+		Axes: []Axis{
+			{Interval: [2]float64{40, 168}, Points: 128},
+			{Interval: [2]float64{1.40, 2.04}, Points: 64},
+		},
+		Max: 1,
+	}
+
+	funcs := []Func{age, bmi}
+
+	tvs := make(TestVectors, len(funcs))
+	for i := range funcs {
+		tvs[i], err = GenTestPolynomials(params, funcs[i], ecd, enc)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 128*64, tvs[1].Points)
+	require.Len(t, tvs[1].Value, 128*64/params.N())
+
+	for _, row := range [][]float64{
+		{40, 70, 1.80},
+		{40, 110, 1.80},
+		{80, 150, 1.50},
+		{80, 50, 2.02},
+	} {
+
+		want := age.F(row[0])
+		if row[1]/(row[2]*row[2]) > 30 {
+			want++
+		}
+
+		require.NoError(t, tvs.Evaluate(params, row, buffPoly, buffCt))
+
+		v := []float64{0}
+		require.NoError(t, ecd.Decode(dec.DecryptNew(buffCt), v))
+		require.InDelta(t, want, v[0], 1e-8)
+
+		// Matches the oracle on the same grid
+		score, err := Oracle{Funcs: funcs}.Score(row)
+		require.NoError(t, err)
+		require.InDelta(t, score/float64(Scaling), v[0], 1e-8)
+	}
+
+	// Out of the grid of the height
+	require.Error(t, tvs.Evaluate(params, []float64{40, 70, 2.10}, buffPoly, buffCt))
+
+	// Missing column
+	require.Error(t, tvs.Evaluate(params, []float64{40, 70}, buffPoly, buffCt))
+
+	// One column per axis
+	bmi.Columns = []int{1}
+	_, err = GenTestPolynomials(params, bmi, ecd, enc)
+	require.Error(t, err)
+}
+
+func testValuePolicies(t *testing.T, fx functionFixture) {
+
+	params, ecd, enc, dec := fx.params, fx.ecd, fx.enc, fx.dec
+	buffPoly, buffCt := fx.buffPoly, fx.buffCt
+
+	var err error
+
+	// The missing index of the age is the first coefficient of a second ciphertext
+	age := Func{
+		F:        func(x float64) (y float64) { return x / 1024 },
+		Interval: [2]float64{0, 128},
+		Points:   params.N(),
+		Max:      1,
+		Policy:   PolicyMissing,
+		Missing:  0.5,
+	}
+
+	sex := NewCategoricalScoringFunction([]float64{1, 2})
+	sex.Policy = PolicyClamp
+
+	weight := Func{
+		F:        func(x float64) (y float64) { return x / 1024 },
+		Interval: [2]float64{0, 256},
+		Points:   256,
+		Max:      1,
+		Policy:   PolicySkip,
+	}
+
+	funcs := []Func{age, sex, weight}
+
+	tvs := make(TestVectors, len(funcs))
+	for i := range funcs {
+		tvs[i], err = GenTestPolynomials(params, funcs[i], ecd, enc)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, tvs[0].Value, 2)
+	require.Len(t, tvs[2].Value, 1)
+
+	for _, tc := range []struct {
+		row     []float64
+		want    float64
+		applied []ValuePolicy
+	}{
+		{[]float64{64, 1, 128}, 64.0/1024 + 2 + 128.0/1024, nil},
+		{[]float64{math.NaN(), 0, 128}, 0.5 + 1 + 128.0/1024, []ValuePolicy{PolicyMissing}},
+		{[]float64{200, 5, 128}, 0.5 + 2 + 128.0/1024, []ValuePolicy{PolicyMissing, PolicyClamp}},
+		{[]float64{64, -1, 128}, 64.0/1024 + 1 + 128.0/1024, []ValuePolicy{PolicyClamp}},
+		{[]float64{64, 0.4, 128}, 64.0/1024 + 1 + 128.0/1024, []ValuePolicy{PolicyClamp}},
+		{[]float64{64, 1, math.NaN()}, 0, []ValuePolicy{PolicySkip}},
+		{[]float64{math.NaN(), 1, 300}, 0, []ValuePolicy{PolicyMissing, PolicySkip}},
+	} {
+
+		applied, err := tvs.evaluate(params, tc.row, buffPoly, buffCt)
+		require.NoError(t, err, "%v", tc.row)
+
+		for _, p := range []ValuePolicy{PolicyClamp, PolicyMissing, PolicySkip} {
+			require.Equal(t, slices.Contains(tc.applied, p), applied.has(p), "%v: %s", tc.row, p)
+		}
+
+		v := []float64{0}
+		require.NoError(t, ecd.Decode(dec.DecryptNew(buffCt), v))
+		require.InDelta(t, tc.want, v[0], 1e-8, "%v", tc.row)
+
+		// Matches the oracle
+		score, err := Oracle{Funcs: funcs}.Score(tc.row)
+		require.NoError(t, err)
+		require.InDelta(t, tc.want, score/float64(Scaling), 1e-8, "%v", tc.row)
+	}
+
+	// Missing values cannot be clamped
+	require.Error(t, tvs.Evaluate(params, []float64{64, math.NaN(), 128}, buffPoly, buffCt))
+
+	// PolicyDefault is PolicyError
+	tvs[1].Policy = PolicyDefault
+	require.Error(t, tvs.Evaluate(params, []float64{64, 5, 128}, buffPoly, buffCt))
+
+	// The policies of the schema
+	schema := Schema{
+		{Name: "age", Interval: [2]float64{0, 120}},
+		{Name: "sex", Type: Categorical, Categories: []string{"F", "M"}, Policy: PolicyClamp},
+		{Name: "weight", Interval: [2]float64{0, 250}, Policy: PolicySkip},
+	}
+
+	resolved, err := tvs.withPolicies(schema)
+	require.NoError(t, err)
+	require.Equal(t, PolicyClamp, resolved[1].Policy)
+	require.NoError(t, resolved.Evaluate(params, []float64{64, 5, 128}, buffPoly, buffCt))
+
+	// A test vector without a missing index
+	tvs[0].Policy = PolicyDefault
+	schema[0].Policy = PolicyMissing
+	_, err = tvs.withPolicies(schema)
+	require.Error(t, err)
+}
+
 func runTimed(f func()) {
 	now := time.Now()
 	f()
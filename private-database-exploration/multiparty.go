@@ -0,0 +1,1066 @@
+package pde
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"math/bits"
+	"slices"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
+	"github.com/tuneinsight/lattigo/v5/mhe"
+	"github.com/tuneinsight/lattigo/v5/ring"
+	"github.com/tuneinsight/lattigo/v5/utils"
+	"github.com/tuneinsight/lattigo/v5/utils/sampling"
+)
+
+// DefaultDecryptionSecurity is the default statistical security parameter lambda
+// of the noise flooding of the decryption shares, see MultipartySetup.DecryptionNoiseFlooding.
+const DefaultDecryptionSecurity = 20
+
+// MultipartySetup is the public setup of the multiparty key generation, which the client
+// and the hospitals agree on before generating the keys: the parameters of the configuration,
+// the Shamir points of the hospitals, the threshold of the decryption and the seed of the
+// common reference polynomials of the keys.
+type MultipartySetup struct {
+	Config        Config
+	Parameters    map[int]*hefloat.Parameters
+	Bootstrapping bootstrapping.Parameters
+
+	// Hospitals are the public Shamir points of the hospitals, which must be unique and
+	// not zero. The client is the party of point zero.
+	Hospitals []mhe.ShamirPublicPoint
+	// Threshold is the number of hospitals needed with the client to decrypt.
+	Threshold int
+	// DecryptionSecurity is the statistical security parameter of the decryption shares.
+	DecryptionSecurity int
+	// Seed is the common reference string of the keys.
+	Seed []byte
+
+	repackGaloisElements        []uint64
+	bootstrappingGaloisElements []uint64
+	paramsSparse                *rlwe.Parameters
+}
+
+// NewMultipartySetup returns the setup of the keys of the configuration, shared by the client
+// and the hospitals with the given Shamir points, any threshold of which can decrypt with the
+// client. The seed is the common reference string agreed on by all the parties.
+func NewMultipartySetup(cfg Config, hospitals []mhe.ShamirPublicPoint, threshold int, seed []byte) (s *MultipartySetup, err error) {
+
+	if err = cfg.Check(); err != nil {
+		return nil, fmt.Errorf("cfg.Check: %w", err)
+	}
+
+	var paramsEval hefloat.Parameters
+	if paramsEval, err = cfg.ParametersEval(); err != nil {
+		return nil, fmt.Errorf("cfg.ParametersEval: %w", err)
+	}
+
+	var btpParams bootstrapping.Parameters
+	if btpParams, err = cfg.ParametersBootstrapping(paramsEval); err != nil {
+		return nil, fmt.Errorf("cfg.ParametersBootstrapping: %w", err)
+	}
+
+	return newMultipartySetup(cfg, paramsEval, btpParams, hospitals, threshold, seed)
+}
+
+// newMultipartySetup returns the setup of the keys of the given parameters of degree
+// 2^{LogNEval} and of their bootstrapping, without checking the configuration.
+func newMultipartySetup(cfg Config, paramsEval hefloat.Parameters, btpParams bootstrapping.Parameters, hospitals []mhe.ShamirPublicPoint, threshold int, seed []byte) (s *MultipartySetup, err error) {
+
+	if threshold < 1 || threshold > len(hospitals) {
+		return nil, fmt.Errorf("invalid threshold=%d: must be in [1, %d]", threshold, len(hospitals))
+	}
+
+	ids := map[mhe.ShamirPublicPoint]bool{}
+	for _, id := range hospitals {
+
+		if id == 0 || ids[id] {
+			return nil, fmt.Errorf("invalid hospital ID %d: must be unique and not zero", id)
+		}
+
+		ids[id] = true
+	}
+
+	if btpParams.ResidualParameters.N() != btpParams.BootstrappingParameters.N() {
+		return nil, fmt.Errorf("residual and bootstrapping parameters with different ring degrees are not supported")
+	}
+
+	s = &MultipartySetup{
+		Config:             cfg,
+		Bootstrapping:      btpParams,
+		Hospitals:          slices.Clone(hospitals),
+		Threshold:          threshold,
+		DecryptionSecurity: DefaultDecryptionSecurity,
+		Seed:               slices.Clone(seed),
+	}
+
+	if s.Parameters, err = NewRingSwitchingParameters(paramsEval, cfg.LogNPack, cfg.EvaluationKeys); err != nil {
+		return nil, fmt.Errorf("NewRingSwitchingParameters: %w", err)
+	}
+
+	pMin := *s.Parameters[cfg.LogNPack]
+	s.repackGaloisElements = GaloisElementsForPack(pMin, pMin.LogN())
+
+	paramsN2 := btpParams.BootstrappingParameters
+	s.bootstrappingGaloisElements = append(btpParams.GaloisElements(paramsN2), paramsN2.GaloisElementForComplexConjugation())
+
+	if btpParams.EphemeralSecretWeight != 0 {
+
+		var paramsSparse rlwe.Parameters
+		if paramsSparse, err = rlwe.NewParametersFromLiteral(rlwe.ParametersLiteral{
+			LogN: paramsN2.LogN(),
+			Q:    paramsN2.Q()[:1],
+			P:    paramsN2.P()[:1],
+		}); err != nil {
+			return nil, fmt.Errorf("rlwe.NewParametersFromLiteral: %w", err)
+		}
+
+		s.paramsSparse = &paramsSparse
+	}
+
+	if _, err = s.DecryptionNoiseFlooding(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// parties returns the Shamir points of the client and of the hospitals.
+func (s *MultipartySetup) parties() []mhe.ShamirPublicPoint {
+	return append([]mhe.ShamirPublicPoint{0}, s.Hospitals...)
+}
+
+// DecryptionNoiseFlooding returns the distribution of the noise added by each party to its
+// decryption share, which hides the noise of the decrypted ciphertext, and thus the secret keys.
+//
+// The noise e of a ciphertext of degree 2^{LogNEval} after a rescaling is dominated by the
+// rounding e0 + e1*s of the rescaling, with |e0|, |e1| <= 1/2, so |e| <= B = (1 + |s|_1)/2.
+// The collective secret key s is the sum of the ternary secret keys of Hamming weight H of
+// the #parties parties, so |s|_1 <= #parties * H. The flooding is a discrete Gaussian of standard
+// deviation sigma = 2^{DecryptionSecurity} * B, so that the statistical distance between the
+// decryption with and without e is at most |e|/(2*sigma) <= 2^{-DecryptionSecurity-1} per
+// coefficient. The flooding of the parties adds an error of standard deviation about
+// sigma * sqrt(#parties * N)/Scale to each decoded slot, which must stay below 2^{-5} so
+// that the binary output of the circuit can be decrypted.
+func (s *MultipartySetup) DecryptionNoiseFlooding() (noise ring.DiscreteGaussian, err error) {
+
+	params := *s.Parameters[s.Config.LogNEval]
+
+	xs, ok := params.Xs().(ring.Ternary)
+	if !ok {
+		return noise, fmt.Errorf("the secret distribution %v is not ternary", params.Xs())
+	}
+
+	h := float64(xs.H)
+	if h == 0 {
+		h = xs.P * float64(params.N())
+	}
+
+	parties := float64(len(s.Hospitals) + 1)
+
+	sigma := math.Exp2(float64(s.DecryptionSecurity)) * (1 + parties*h) / 2
+
+	if std := sigma * math.Sqrt(parties*float64(params.N())) / params.DefaultScale().Float64(); std > 1.0/32 {
+		return noise, fmt.Errorf("invalid decryption security=%d: the noise flooding adds an error of 2^{%.2f} to the output", s.DecryptionSecurity, math.Log2(std))
+	}
+
+	return ring.DiscreteGaussian{Sigma: sigma, Bound: 6 * sigma}, nil
+}
+
+// Party is a participant of the multiparty key generation and of the threshold
+// decryption: the client (the scientist) or a hospital. The secret keys of the
+// collective keys are the sums of the secret keys of the parties, which never
+// leave their party: the parties only exchange the public shares of the keys,
+// see Party.GenKeyShares.
+type Party struct {
+	// ID is the public Shamir point of the party: zero for the client.
+	ID mhe.ShamirPublicPoint
+
+	// Setup is the setup of the keys agreed on by the parties.
+	Setup *MultipartySetup
+
+	// Sk are the shares of the secret keys, indexed by their LogN.
+	Sk map[int]*rlwe.SecretKey
+
+	// SkSparse is the share of the ephemeral sparse secret key of the bootstrapping,
+	// whose support is disjoint from the ones of the other parties.
+	SkSparse *rlwe.SecretKey
+
+	// ThresholdShare is the Shamir share of the sum of the secret keys of the hospitals
+	// of degree 2^{LogNEval}, nil for the client, see Party.AggregateThresholdShares.
+	ThresholdShare *mhe.ShamirSecretShare
+
+	// ephSk is the ephemeral secret key of the relinearization key between its two rounds.
+	ephSk *rlwe.SecretKey
+}
+
+// NewParty generates the shares of the secret keys of a party of the setup.
+func NewParty(id mhe.ShamirPublicPoint, setup *MultipartySetup) (p *Party, err error) {
+
+	if !slices.Contains(setup.parties(), id) {
+		return nil, fmt.Errorf("party %d is not a party of the setup", id)
+	}
+
+	p = &Party{
+		ID:    id,
+		Setup: setup,
+		Sk:    map[int]*rlwe.SecretKey{},
+	}
+
+	for LogN, pi := range setup.Parameters {
+		p.Sk[LogN] = rlwe.NewKeyGenerator(pi).GenSecretKeyNew()
+	}
+
+	if setup.paramsSparse != nil {
+		if p.SkSparse, err = setup.genSparseSecretKey(id, rand.Reader); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// genSparseSecretKey returns the share of the party of the ephemeral sparse secret key of the
+// bootstrapping, using the bytes of r as randomness. The ephemeral key must be ternary with a
+// Hamming weight of EphemeralSecretWeight, as the key for which the bootstrapping parameters
+// are sized, and a sum of keys with overlapping supports would not be: its coefficients could
+// collide to 0 or to +/-2. The k-th of the p parties thus samples a ternary key whose support
+// is a random subset of the coefficients of index k mod p, of weight floor(h/p) or ceil(h/p)
+// such that the weights sum to h, and the sum of the keys is ternary of weight exactly h.
+func (s *MultipartySetup) genSparseSecretKey(id mhe.ShamirPublicPoint, r io.Reader) (sk *rlwe.SecretKey, err error) {
+
+	params := *s.paramsSparse
+	N := params.N()
+
+	parties := s.parties()
+	k := slices.Index(parties, id)
+	h := s.Bootstrapping.EphemeralSecretWeight
+
+	weight := h / len(parties)
+	if k < h%len(parties) {
+		weight++
+	}
+
+	positions := make([]int, 0, N/len(parties)+1)
+	for i := k; i < N; i += len(parties) {
+		positions = append(positions, i)
+	}
+
+	if weight > len(positions) {
+		return nil, fmt.Errorf("invalid ephemeral secret weight=%d: larger than N=%d", h, N)
+	}
+
+	sk = rlwe.NewSecretKey(params)
+
+	ringQP := params.RingQP().AtLevel(sk.LevelQ(), sk.LevelP())
+
+	for i := 0; i < weight; i++ {
+
+		// Partial Fisher-Yates shuffle of the positions
+		var j, sign *big.Int
+		if j, err = rand.Int(r, big.NewInt(int64(len(positions)-i))); err != nil {
+			return nil, fmt.Errorf("rand.Int: %w", err)
+		}
+
+		if sign, err = rand.Int(r, big.NewInt(2)); err != nil {
+			return nil, fmt.Errorf("rand.Int: %w", err)
+		}
+
+		positions[i], positions[i+int(j.Int64())] = positions[i+int(j.Int64())], positions[i]
+
+		for l, qi := range ringQP.RingQ.ModuliChain()[:sk.LevelQ()+1] {
+			if sign.Sign() == 0 {
+				sk.Value.Q.Coeffs[l][positions[i]] = 1
+			} else {
+				sk.Value.Q.Coeffs[l][positions[i]] = qi - 1
+			}
+		}
+	}
+
+	if levelP := sk.LevelP(); levelP > -1 {
+		ringQP.ExtendBasisSmallNormAndCenter(sk.Value.Q, levelP, sk.Value.Q, sk.Value.P)
+	}
+
+	ringQP.NTT(sk.Value, sk.Value)
+	ringQP.MForm(sk.Value, sk.Value)
+
+	return
+}
+
+// KeyShares are the public shares of a party in the generation of the collective keys:
+// the public keys, the ring switching and repacking keys, see RepackEvaluationKeySet,
+// and the bootstrapping keys. The ephemeral sparse secret key of the bootstrapping is
+// the sum of the sparse secret keys of the parties, whose supports are disjoint so that
+// it is ternary of weight EphemeralSecretWeight, see Party.SkSparse. The relinearization
+// key needs a second round, see Party.GenRelinearizationShare.
+type KeyShares struct {
+	// ID is the Shamir point of the party, and zero for aggregated shares.
+	ID mhe.ShamirPublicPoint
+	// PublicKeys are the shares of the public keys of degree 2^{LogNPack} and 2^{LogNEval}.
+	PublicKeys []mhe.PublicKeyGenShare
+	// RingSwitchingKeys are the shares of the keys from the degree 2^{i} to 2^{i+1}, for i in [LogNPack, LogNEval).
+	RingSwitchingKeys []mhe.EvaluationKeyGenShare
+	// RepackKeys are the shares of the Galois keys of the repacking.
+	RepackKeys []mhe.GaloisKeyGenShare
+	// SparseKeys are the shares of the dense-to-sparse and sparse-to-dense keys of the
+	// bootstrapping, if it has an ephemeral secret key.
+	SparseKeys []mhe.EvaluationKeyGenShare
+	// BootstrappingKeys are the shares of the Galois keys of the bootstrapping.
+	BootstrappingKeys []mhe.GaloisKeyGenShare
+	// Relinearization is the share of the first round of the relinearization key.
+	Relinearization mhe.RelinearizationKeyGenShare
+}
+
+// multipartyCRPs are the common reference polynomials of the keys, sampled from the seed.
+type multipartyCRPs struct {
+	publicKeys        []mhe.PublicKeyGenCRP
+	ringSwitchingKeys []mhe.EvaluationKeyGenCRP
+	repackKeys        []mhe.GaloisKeyGenCRP
+	sparseKeys        []mhe.EvaluationKeyGenCRP
+	bootstrappingKeys []mhe.GaloisKeyGenCRP
+	relinearization   mhe.RelinearizationKeyGenCRP
+}
+
+// publicKeyLogN returns the ring degrees of the collective public keys.
+func (s *MultipartySetup) publicKeyLogN() []int {
+	return []int{s.Config.LogNPack, s.Config.LogNEval}
+}
+
+// sparseKeyParameters returns the parameters of the dense-to-sparse and sparse-to-dense keys.
+func (s *MultipartySetup) sparseKeyParameters() []rlwe.EvaluationKeyParameters {
+	return []rlwe.EvaluationKeyParameters{{LevelQ: utils.Pointy(0), LevelP: utils.Pointy(0)}, {}}
+}
+
+// crps samples the common reference polynomials of the keys from the seed,
+// in the same order for all the parties.
+func (s *MultipartySetup) crps() (crp multipartyCRPs, err error) {
+
+	crs, err := sampling.NewKeyedPRNG(s.Seed)
+	if err != nil {
+		return crp, fmt.Errorf("sampling.NewKeyedPRNG: %w", err)
+	}
+
+	for _, LogN := range s.publicKeyLogN() {
+		crp.publicKeys = append(crp.publicKeys, mhe.NewPublicKeyGenProtocol(*s.Parameters[LogN]).SampleCRP(crs))
+	}
+
+	for i := s.Config.LogNPack; i < s.Config.LogNEval; i++ {
+		evkg := mhe.NewEvaluationKeyGenProtocol(*s.Parameters[i+1])
+		crp.ringSwitchingKeys = append(crp.ringSwitchingKeys, evkg.SampleCRP(crs, s.Config.EvaluationKeys))
+	}
+
+	gkg := mhe.NewGaloisKeyGenProtocol(*s.Parameters[s.Config.LogNPack])
+	for range s.repackGaloisElements {
+		crp.repackKeys = append(crp.repackKeys, gkg.SampleCRP(crs, s.Config.EvaluationKeys))
+	}
+
+	paramsN2 := s.Bootstrapping.BootstrappingParameters
+
+	if s.paramsSparse != nil {
+		evkg := mhe.NewEvaluationKeyGenProtocol(paramsN2)
+		for _, evkParams := range s.sparseKeyParameters() {
+			crp.sparseKeys = append(crp.sparseKeys, evkg.SampleCRP(crs, evkParams))
+		}
+	}
+
+	gkg = mhe.NewGaloisKeyGenProtocol(paramsN2)
+	for range s.bootstrappingGaloisElements {
+		crp.bootstrappingKeys = append(crp.bootstrappingKeys, gkg.SampleCRP(crs))
+	}
+
+	crp.relinearization = mhe.NewRelinearizationKeyGenProtocol(paramsN2).SampleCRP(crs)
+
+	return
+}
+
+// newKeyShares allocates the shares of the keys of the setup.
+func (s *MultipartySetup) newKeyShares(id mhe.ShamirPublicPoint) (shares KeyShares) {
+
+	shares.ID = id
+
+	for _, LogN := range s.publicKeyLogN() {
+		shares.PublicKeys = append(shares.PublicKeys, mhe.NewPublicKeyGenProtocol(*s.Parameters[LogN]).AllocateShare())
+	}
+
+	for i := s.Config.LogNPack; i < s.Config.LogNEval; i++ {
+		evkg := mhe.NewEvaluationKeyGenProtocol(*s.Parameters[i+1])
+		shares.RingSwitchingKeys = append(shares.RingSwitchingKeys, evkg.AllocateShare(s.Config.EvaluationKeys))
+	}
+
+	gkg := mhe.NewGaloisKeyGenProtocol(*s.Parameters[s.Config.LogNPack])
+	for _, galEl := range s.repackGaloisElements {
+		share := gkg.AllocateShare(s.Config.EvaluationKeys)
+		share.GaloisElement = galEl
+		shares.RepackKeys = append(shares.RepackKeys, share)
+	}
+
+	paramsN2 := s.Bootstrapping.BootstrappingParameters
+
+	if s.paramsSparse != nil {
+		evkg := mhe.NewEvaluationKeyGenProtocol(paramsN2)
+		for _, evkParams := range s.sparseKeyParameters() {
+			shares.SparseKeys = append(shares.SparseKeys, evkg.AllocateShare(evkParams))
+		}
+	}
+
+	gkg = mhe.NewGaloisKeyGenProtocol(paramsN2)
+	for _, galEl := range s.bootstrappingGaloisElements {
+		share := gkg.AllocateShare()
+		share.GaloisElement = galEl
+		shares.BootstrappingKeys = append(shares.BootstrappingKeys, share)
+	}
+
+	_, shares.Relinearization, _ = mhe.NewRelinearizationKeyGenProtocol(paramsN2).AllocateShare()
+
+	return
+}
+
+// checkKeyShares returns an error if the shares do not have the keys of the setup.
+func (s *MultipartySetup) checkKeyShares(shares KeyShares) (err error) {
+
+	if len(shares.PublicKeys) != len(s.publicKeyLogN()) {
+		return fmt.Errorf("invalid #PublicKeys=%d: expected %d", len(shares.PublicKeys), len(s.publicKeyLogN()))
+	}
+
+	if len(shares.RingSwitchingKeys) != s.Config.LogNEval-s.Config.LogNPack {
+		return fmt.Errorf("invalid #RingSwitchingKeys=%d: expected %d", len(shares.RingSwitchingKeys), s.Config.LogNEval-s.Config.LogNPack)
+	}
+
+	if len(shares.SparseKeys) != len(s.newKeyShares(0).SparseKeys) {
+		return fmt.Errorf("invalid #SparseKeys=%d", len(shares.SparseKeys))
+	}
+
+	for _, keys := range []struct {
+		name   string
+		shares []mhe.GaloisKeyGenShare
+		galEls []uint64
+	}{
+		{"RepackKeys", shares.RepackKeys, s.repackGaloisElements},
+		{"BootstrappingKeys", shares.BootstrappingKeys, s.bootstrappingGaloisElements},
+	} {
+
+		if len(keys.shares) != len(keys.galEls) {
+			return fmt.Errorf("invalid #%s=%d: expected %d", keys.name, len(keys.shares), len(keys.galEls))
+		}
+
+		for i := range keys.shares {
+			if keys.shares[i].GaloisElement != keys.galEls[i] {
+				return fmt.Errorf("invalid %s[%d]: Galois element %d, expected %d", keys.name, i, keys.shares[i].GaloisElement, keys.galEls[i])
+			}
+		}
+	}
+
+	return
+}
+
+// bootstrappingSecretKey returns the share of the secret key of the bootstrapping,
+// i.e. the secret key of degree 2^{LogNEval} extended to the moduli of the bootstrapping.
+func (p *Party) bootstrappingSecretKey() (sk *rlwe.SecretKey) {
+
+	paramsN2 := p.Setup.Bootstrapping.BootstrappingParameters
+
+	ringQ := paramsN2.RingQ()
+	ringP := paramsN2.RingP()
+	buff := ringQ.NewPoly()
+
+	skN1 := p.Sk[p.Setup.Config.LogNEval]
+
+	sk = rlwe.NewSecretKey(paramsN2)
+	rlwe.ExtendBasisSmallNormAndCenterNTTMontgomery(ringQ, ringQ, skN1.Value.Q, buff, sk.Value.Q)
+	rlwe.ExtendBasisSmallNormAndCenterNTTMontgomery(ringQ, ringP, skN1.Value.Q, buff, sk.Value.P)
+
+	return
+}
+
+// GenKeyShares generates the public shares of the party in the collective keys, which
+// are sent to the client to be aggregated, see MultipartySetup.AggregateKeyShares.
+// It is the first round of the relinearization key.
+func (p *Party) GenKeyShares() (shares KeyShares, err error) {
+
+	s := p.Setup
+
+	var crp multipartyCRPs
+	if crp, err = s.crps(); err != nil {
+		return
+	}
+
+	shares = s.newKeyShares(p.ID)
+
+	for k, LogN := range s.publicKeyLogN() {
+		mhe.NewPublicKeyGenProtocol(*s.Parameters[LogN]).GenShare(p.Sk[LogN], crp.publicKeys[k], &shares.PublicKeys[k])
+	}
+
+	// Maps the smaller keys to the larger ring degree with Y = X^{N/n}
+	for k := range shares.RingSwitchingKeys {
+
+		i := s.Config.LogNPack + k
+		pOut := *s.Parameters[i+1]
+		skIn := mapSecretKey(pOut, p.Sk[i], p.Sk[i+1].LevelQ())
+
+		if err = mhe.NewEvaluationKeyGenProtocol(pOut).GenShare(skIn, p.Sk[i+1], crp.ringSwitchingKeys[k], &shares.RingSwitchingKeys[k]); err != nil {
+			return shares, fmt.Errorf("evkg.GenShare: %w", err)
+		}
+	}
+
+	gkg := mhe.NewGaloisKeyGenProtocol(*s.Parameters[s.Config.LogNPack])
+	for k, galEl := range s.repackGaloisElements {
+		if err = gkg.GenShare(p.Sk[s.Config.LogNPack], galEl, crp.repackKeys[k], &shares.RepackKeys[k]); err != nil {
+			return shares, fmt.Errorf("gkg.GenShare: %w", err)
+		}
+	}
+
+	paramsN2 := s.Bootstrapping.BootstrappingParameters
+	skN2 := p.bootstrappingSecretKey()
+
+	if s.paramsSparse != nil {
+
+		evkg := mhe.NewEvaluationKeyGenProtocol(paramsN2)
+
+		if err = evkg.GenShare(skN2, p.SkSparse, crp.sparseKeys[0], &shares.SparseKeys[0]); err != nil {
+			return shares, fmt.Errorf("evkg.GenShare: %w", err)
+		}
+
+		skSparse := mapSecretKey(paramsN2, p.SkSparse, paramsN2.MaxLevelQ())
+
+		if err = evkg.GenShare(skSparse, skN2, crp.sparseKeys[1], &shares.SparseKeys[1]); err != nil {
+			return shares, fmt.Errorf("evkg.GenShare: %w", err)
+		}
+	}
+
+	gkg = mhe.NewGaloisKeyGenProtocol(paramsN2)
+	for k, galEl := range s.bootstrappingGaloisElements {
+		if err = gkg.GenShare(skN2, galEl, crp.bootstrappingKeys[k], &shares.BootstrappingKeys[k]); err != nil {
+			return shares, fmt.Errorf("gkg.GenShare: %w", err)
+		}
+	}
+
+	rkg := mhe.NewRelinearizationKeyGenProtocol(paramsN2)
+	p.ephSk, _, _ = rkg.AllocateShare()
+	rkg.GenShareRoundOne(skN2, crp.relinearization, p.ephSk, &shares.Relinearization)
+
+	return
+}
+
+// AggregateKeyShares aggregates the key shares of the client and of each hospital of the setup.
+func (s *MultipartySetup) AggregateKeyShares(shares []KeyShares) (agg KeyShares, err error) {
+
+	if err = checkParties(s.parties(), shares, func(share KeyShares) mhe.ShamirPublicPoint { return share.ID }); err != nil {
+		return
+	}
+
+	agg = s.newKeyShares(0)
+
+	paramsN2 := s.Bootstrapping.BootstrappingParameters
+
+	for _, share := range shares {
+
+		if err = s.checkKeyShares(share); err != nil {
+			return agg, fmt.Errorf("party %d: %w", share.ID, err)
+		}
+
+		for k, LogN := range s.publicKeyLogN() {
+			mhe.NewPublicKeyGenProtocol(*s.Parameters[LogN]).AggregateShares(agg.PublicKeys[k], share.PublicKeys[k], &agg.PublicKeys[k])
+		}
+
+		for k := range agg.RingSwitchingKeys {
+			evkg := mhe.NewEvaluationKeyGenProtocol(*s.Parameters[s.Config.LogNPack+k+1])
+			if err = evkg.AggregateShares(agg.RingSwitchingKeys[k], share.RingSwitchingKeys[k], &agg.RingSwitchingKeys[k]); err != nil {
+				return agg, fmt.Errorf("party %d: evkg.AggregateShares: %w", share.ID, err)
+			}
+		}
+
+		gkg := mhe.NewGaloisKeyGenProtocol(*s.Parameters[s.Config.LogNPack])
+		for k := range agg.RepackKeys {
+			if err = gkg.AggregateShares(agg.RepackKeys[k], share.RepackKeys[k], &agg.RepackKeys[k]); err != nil {
+				return agg, fmt.Errorf("party %d: gkg.AggregateShares: %w", share.ID, err)
+			}
+		}
+
+		evkg := mhe.NewEvaluationKeyGenProtocol(paramsN2)
+		for k := range agg.SparseKeys {
+			if err = evkg.AggregateShares(agg.SparseKeys[k], share.SparseKeys[k], &agg.SparseKeys[k]); err != nil {
+				return agg, fmt.Errorf("party %d: evkg.AggregateShares: %w", share.ID, err)
+			}
+		}
+
+		gkg = mhe.NewGaloisKeyGenProtocol(paramsN2)
+		for k := range agg.BootstrappingKeys {
+			if err = gkg.AggregateShares(agg.BootstrappingKeys[k], share.BootstrappingKeys[k], &agg.BootstrappingKeys[k]); err != nil {
+				return agg, fmt.Errorf("party %d: gkg.AggregateShares: %w", share.ID, err)
+			}
+		}
+
+		mhe.NewRelinearizationKeyGenProtocol(paramsN2).AggregateShares(agg.Relinearization, share.Relinearization, &agg.Relinearization)
+	}
+
+	return
+}
+
+// RelinearizationShare is the public share of a party in the second round of the
+// relinearization key, see Party.GenRelinearizationShare.
+type RelinearizationShare struct {
+	ID    mhe.ShamirPublicPoint
+	Share mhe.RelinearizationKeyGenShare
+}
+
+// GenRelinearizationShare generates the share of the party in the second round of the
+// relinearization key, from the aggregated shares of the first round, see Party.GenKeyShares.
+func (p *Party) GenRelinearizationShare(agg KeyShares) (share RelinearizationShare, err error) {
+
+	if p.ephSk == nil {
+		return share, fmt.Errorf("party %d has not generated its key shares", p.ID)
+	}
+
+	paramsN2 := p.Setup.Bootstrapping.BootstrappingParameters
+
+	rkg := mhe.NewRelinearizationKeyGenProtocol(paramsN2)
+
+	share.ID = p.ID
+	_, _, share.Share = rkg.AllocateShare()
+	rkg.GenShareRoundTwo(p.ephSk, p.bootstrappingSecretKey(), agg.Relinearization, &share.Share)
+
+	p.ephSk = nil
+
+	return
+}
+
+// InitMultiparty generates the shares of the client in the keys of the setup, instead of
+// Client.Init. The keys are generated in three steps, in which the parties only exchange
+// their public shares:
+//   - each party, the client with InitMultiparty and each hospital with NewParty and
+//     Party.GenKeyShares, generates its key shares;
+//   - the client aggregates the key shares with MultipartySetup.AggregateKeyShares, and
+//     each party generates its share of the second round of the relinearization key from
+//     the aggregated shares with Party.GenRelinearizationShare;
+//   - the client generates the collective keys with Client.FinalizeMultiparty.
+//
+// The hospitals then share the secret key of degree 2^{LogNEval} among them, see
+// Party.GenThresholdShares, so that the decryption of a result requires the client
+// and any threshold of the hospitals, see Client.ThresholdDecrypt.
+func (c *Client) InitMultiparty(setup *MultipartySetup) (shares KeyShares, err error) {
+
+	c.Config = setup.Config
+	c.Parameters = setup.Parameters
+	c.Ski = nil
+	c.Pk = nil
+
+	if c.Party, err = NewParty(0, setup); err != nil {
+		return shares, fmt.Errorf("NewParty: %w", err)
+	}
+
+	return c.Party.GenKeyShares()
+}
+
+// FinalizeMultiparty generates the collective public keys of the client and the evaluation
+// keys of the requests from the aggregated key shares and the shares of the second round of
+// the relinearization key of the client and of each hospital, see Client.InitMultiparty.
+func (c *Client) FinalizeMultiparty(agg KeyShares, relin []RelinearizationShare) (evk EvaluationKeys, err error) {
+
+	if c.Party == nil {
+		return evk, fmt.Errorf("client has no key share: Client.InitMultiparty was not called")
+	}
+
+	s := c.Party.Setup
+
+	if err = s.checkKeyShares(agg); err != nil {
+		return evk, fmt.Errorf("aggregated shares: %w", err)
+	}
+
+	if err = checkParties(s.parties(), relin, func(share RelinearizationShare) mhe.ShamirPublicPoint { return share.ID }); err != nil {
+		return
+	}
+
+	var crp multipartyCRPs
+	if crp, err = s.crps(); err != nil {
+		return
+	}
+
+	c.Pk = map[int]*rlwe.PublicKey{}
+
+	for k, LogN := range s.publicKeyLogN() {
+		params := *s.Parameters[LogN]
+		c.Pk[LogN] = rlwe.NewPublicKey(params)
+		mhe.NewPublicKeyGenProtocol(params).GenPublicKey(agg.PublicKeys[k], crp.publicKeys[k], c.Pk[LogN])
+	}
+
+	rpk := RepackEvaluationKeySet{
+		Parameters:        s.Parameters,
+		RingSwitchingKeys: map[int]map[int]*rlwe.EvaluationKey{},
+		RepackKeys:        map[int]rlwe.EvaluationKeySet{},
+	}
+
+	for i := s.Config.LogNPack; i < s.Config.LogNEval+1; i++ {
+		rpk.RingSwitchingKeys[i] = map[int]*rlwe.EvaluationKey{}
+	}
+
+	for k := range agg.RingSwitchingKeys {
+
+		i := s.Config.LogNPack + k
+		pOut := *s.Parameters[i+1]
+
+		key := rlwe.NewEvaluationKey(pOut, s.Config.EvaluationKeys)
+		if err = mhe.NewEvaluationKeyGenProtocol(pOut).GenEvaluationKey(agg.RingSwitchingKeys[k], crp.ringSwitchingKeys[k], key); err != nil {
+			return EvaluationKeys{}, fmt.Errorf("evkg.GenEvaluationKey: %w", err)
+		}
+
+		rpk.RingSwitchingKeys[i][i+1] = key
+	}
+
+	pMin := *s.Parameters[s.Config.LogNPack]
+
+	var gks []*rlwe.GaloisKey
+	if gks, err = genGaloisKeys(pMin, agg.RepackKeys, crp.repackKeys, s.Config.EvaluationKeys); err != nil {
+		return EvaluationKeys{}, err
+	}
+
+	rpk.RepackKeys[s.Config.LogNPack] = rlwe.NewMemEvaluationKeySet(nil, gks...)
+
+	paramsN2 := s.Bootstrapping.BootstrappingParameters
+
+	evkBoot := bootstrapping.EvaluationKeys{}
+
+	if s.paramsSparse != nil {
+
+		evkg := mhe.NewEvaluationKeyGenProtocol(paramsN2)
+		evks := []**rlwe.EvaluationKey{&evkBoot.EvkDenseToSparse, &evkBoot.EvkSparseToDense}
+
+		for k, evkParams := range s.sparseKeyParameters() {
+
+			*evks[k] = rlwe.NewEvaluationKey(paramsN2, evkParams)
+
+			if err = evkg.GenEvaluationKey(agg.SparseKeys[k], crp.sparseKeys[k], *evks[k]); err != nil {
+				return EvaluationKeys{}, fmt.Errorf("evkg.GenEvaluationKey: %w", err)
+			}
+		}
+	}
+
+	if gks, err = genGaloisKeys(paramsN2, agg.BootstrappingKeys, crp.bootstrappingKeys); err != nil {
+		return EvaluationKeys{}, err
+	}
+
+	rkg := mhe.NewRelinearizationKeyGenProtocol(paramsN2)
+
+	_, _, round2 := rkg.AllocateShare()
+	for _, share := range relin {
+		rkg.AggregateShares(round2, share.Share, &round2)
+	}
+
+	rlk := rlwe.NewRelinearizationKey(paramsN2)
+	rkg.GenRelinearizationKey(agg.Relinearization, round2, rlk)
+
+	evkBoot.MemEvaluationKeySet = rlwe.NewMemEvaluationKeySet(rlk, gks...)
+
+	return EvaluationKeys{
+		RepackEvaluationKeySet: rpk,
+		EvaluationKeys:         evkBoot,
+	}, nil
+}
+
+// genGaloisKeys generates the Galois keys of the aggregated shares.
+func genGaloisKeys(params hefloat.Parameters, agg []mhe.GaloisKeyGenShare, crp []mhe.GaloisKeyGenCRP, evkParams ...rlwe.EvaluationKeyParameters) (gks []*rlwe.GaloisKey, err error) {
+
+	gkg := mhe.NewGaloisKeyGenProtocol(params)
+
+	gks = make([]*rlwe.GaloisKey, len(agg))
+
+	for k := range agg {
+
+		gks[k] = rlwe.NewGaloisKey(params, evkParams...)
+
+		if err = gkg.GenGaloisKey(agg[k], crp[k], gks[k]); err != nil {
+			return nil, fmt.Errorf("gkg.GenGaloisKey: %w", err)
+		}
+	}
+
+	return
+}
+
+// checkParties returns an error unless the shares are exactly one share of each party.
+func checkParties[T any](parties []mhe.ShamirPublicPoint, shares []T, id func(T) mhe.ShamirPublicPoint) error {
+
+	seen := map[mhe.ShamirPublicPoint]bool{}
+
+	for _, share := range shares {
+
+		i := id(share)
+
+		if !slices.Contains(parties, i) {
+			return fmt.Errorf("invalid share: party %d is not in the parties %v", i, parties)
+		}
+
+		if seen[i] {
+			return fmt.Errorf("invalid share: duplicate share of party %d", i)
+		}
+
+		seen[i] = true
+	}
+
+	if len(seen) != len(parties) {
+		return fmt.Errorf("invalid shares: got the shares of %d parties, expected %d", len(seen), len(parties))
+	}
+
+	return nil
+}
+
+// GenThresholdShares returns the Shamir shares of the secret key of degree 2^{LogNEval} of
+// the hospital, indexed by their recipient: one for each hospital of the setup, including
+// itself. Unlike the key shares, the Shamir shares are secret and each must be sent to its
+// recipient only, over a private channel, see Party.AggregateThresholdShares.
+func (p *Party) GenThresholdShares() (shares map[mhe.ShamirPublicPoint]mhe.ShamirSecretShare, err error) {
+
+	if p.ID == 0 {
+		return nil, fmt.Errorf("the client does not take part in the threshold")
+	}
+
+	s := p.Setup
+	params := *s.Parameters[s.Config.LogNEval]
+
+	thr := mhe.NewThresholdizer(params)
+
+	// The secret key is shared with a random polynomial of degree threshold-1
+	var poly mhe.ShamirPolynomial
+	if poly, err = thr.GenShamirPolynomial(s.Threshold, p.Sk[params.LogN()]); err != nil {
+		return nil, fmt.Errorf("thr.GenShamirPolynomial: %w", err)
+	}
+
+	shares = map[mhe.ShamirPublicPoint]mhe.ShamirSecretShare{}
+
+	for _, recipient := range s.Hospitals {
+		share := thr.AllocateThresholdSecretShare()
+		thr.GenShamirSecretShare(recipient, poly, &share)
+		shares[recipient] = share
+	}
+
+	return
+}
+
+// AggregateThresholdShares aggregates the Shamir shares received by the hospital, indexed by
+// their sender, into its share of the sum of the secret keys of the hospitals, such that any
+// threshold of the hospitals can decrypt with the client, see Party.GenDecryptionShare.
+func (p *Party) AggregateThresholdShares(shares map[mhe.ShamirPublicPoint]mhe.ShamirSecretShare) (err error) {
+
+	if p.ID == 0 {
+		return fmt.Errorf("the client does not take part in the threshold")
+	}
+
+	s := p.Setup
+
+	senders := make([]mhe.ShamirPublicPoint, 0, len(shares))
+	for id := range shares {
+		senders = append(senders, id)
+	}
+
+	if err = checkParties(s.Hospitals, senders, func(id mhe.ShamirPublicPoint) mhe.ShamirPublicPoint { return id }); err != nil {
+		return
+	}
+
+	thr := mhe.NewThresholdizer(*s.Parameters[s.Config.LogNEval])
+
+	agg := thr.AllocateThresholdSecretShare()
+	for _, id := range s.Hospitals {
+		if err = thr.AggregateShares(agg, shares[id], &agg); err != nil {
+			return fmt.Errorf("thr.AggregateShares: %w", err)
+		}
+	}
+
+	p.ThresholdShare = &agg
+
+	return
+}
+
+// DecryptionShare is the share of a party in the decryption of a ciphertext of degree
+// 2^{LogNEval}, see Party.GenDecryptionShare.
+type DecryptionShare struct {
+	// ID is the Shamir point of the party.
+	ID mhe.ShamirPublicPoint
+	// Active are the Shamir points of the hospitals taking part in the decryption,
+	// for which the Shamir share of the hospital was combined.
+	Active []mhe.ShamirPublicPoint
+	Share  mhe.KeySwitchShare
+}
+
+// GenDecryptionShare returns the share of the hospital in the decryption of ct, a ciphertext
+// of degree 2^{LogNEval}. active are the Shamir points of the threshold hospitals taking part
+// in the decryption, which must include the hospital. The client does not take part in the
+// threshold and its share is generated by Client.ThresholdDecrypt.
+func (p *Party) GenDecryptionShare(ct *rlwe.Ciphertext, active []mhe.ShamirPublicPoint) (share DecryptionShare, err error) {
+
+	if p.ThresholdShare == nil {
+		return share, fmt.Errorf("party %d has no threshold share", p.ID)
+	}
+
+	s := p.Setup
+	params := *s.Parameters[s.Config.LogNEval]
+
+	if ct.Value[0].N() != params.N() {
+		return share, fmt.Errorf("invalid ciphertext: degree %d, expected 2^{LogNEval}=%d", ct.Value[0].N(), params.N())
+	}
+
+	if err = s.checkActive(active); err != nil {
+		return
+	}
+
+	if !slices.Contains(active, p.ID) {
+		return share, fmt.Errorf("party %d is not in the active parties", p.ID)
+	}
+
+	cmb := mhe.NewCombiner(*params.GetRLWEParameters(), p.ID, active, s.Threshold)
+
+	sk := rlwe.NewSecretKey(params)
+	if err = cmb.GenAdditiveShare(active, p.ID, *p.ThresholdShare, sk); err != nil {
+		return share, fmt.Errorf("cmb.GenAdditiveShare: %w", err)
+	}
+
+	share = DecryptionShare{ID: p.ID, Active: slices.Clone(active)}
+
+	if share.Share, err = s.genDecryptionShare(sk, ct); err != nil {
+		return
+	}
+
+	return
+}
+
+// checkActive returns an error unless active are threshold distinct hospitals of the setup.
+func (s *MultipartySetup) checkActive(active []mhe.ShamirPublicPoint) (err error) {
+
+	if len(active) != s.Threshold {
+		return fmt.Errorf("invalid #active parties=%d: must be equal to threshold=%d", len(active), s.Threshold)
+	}
+
+	seen := map[mhe.ShamirPublicPoint]bool{}
+	for _, id := range active {
+
+		if !slices.Contains(s.Hospitals, id) || seen[id] {
+			return fmt.Errorf("invalid active party %d: must be a distinct hospital of the setup", id)
+		}
+
+		seen[id] = true
+	}
+
+	return
+}
+
+// genDecryptionShare returns the share of the secret key sk in the decryption of ct,
+// flooded with the noise of MultipartySetup.DecryptionNoiseFlooding.
+func (s *MultipartySetup) genDecryptionShare(sk *rlwe.SecretKey, ct *rlwe.Ciphertext) (share mhe.KeySwitchShare, err error) {
+
+	params := *s.Parameters[s.Config.LogNEval]
+
+	var noise ring.DiscreteGaussian
+	if noise, err = s.DecryptionNoiseFlooding(); err != nil {
+		return
+	}
+
+	cks, err := mhe.NewKeySwitchProtocol(params, noise)
+	if err != nil {
+		return share, fmt.Errorf("mhe.NewKeySwitchProtocol: %w", err)
+	}
+
+	share = cks.AllocateShare(ct.Level())
+	cks.GenShare(sk, rlwe.NewSecretKey(params), ct, &share)
+
+	return
+}
+
+// ThresholdDecrypt decrypts the score, a ciphertext of degree 2^{LogNEval}, with the
+// decryption shares of the threshold active hospitals, see Party.GenDecryptionShare,
+// and the share of the client.
+func (c Client) ThresholdDecrypt(score *rlwe.Ciphertext, shares []DecryptionShare) (v []complex128, err error) {
+
+	var pt *rlwe.Plaintext
+	if pt, err = c.thresholdDecryptNew(score, shares); err != nil {
+		return
+	}
+
+	LogN := bits.Len64(uint64(score.Value[0].N() - 1))
+
+	v = make([]complex128, score.Slots())
+	return v, hefloat.NewEncoder(*c.Parameters[LogN]).Decode(pt, v)
+}
+
+func (c Client) thresholdDecryptNew(ct *rlwe.Ciphertext, shares []DecryptionShare) (pt *rlwe.Plaintext, err error) {
+
+	if c.Party == nil {
+		return nil, fmt.Errorf("client has no key share: keys were not generated with Client.InitMultiparty")
+	}
+
+	s := c.Party.Setup
+	params := *s.Parameters[s.Config.LogNEval]
+
+	if ct.Value[0].N() != params.N() {
+		return nil, fmt.Errorf("invalid ciphertext: degree %d, expected 2^{LogNEval}=%d", ct.Value[0].N(), params.N())
+	}
+
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("no decryption shares")
+	}
+
+	// The shares must be of the active set their Shamir shares were combined for
+	active := shares[0].Active
+
+	if err = s.checkActive(active); err != nil {
+		return
+	}
+
+	for _, share := range shares {
+		if len(share.Active) != len(active) || !slices.Equal(share.Active, active) {
+			return nil, fmt.Errorf("invalid share of party %d: active parties %v, expected %v", share.ID, share.Active, active)
+		}
+	}
+
+	if err = checkParties(active, shares, func(share DecryptionShare) mhe.ShamirPublicPoint { return share.ID }); err != nil {
+		return
+	}
+
+	var share mhe.KeySwitchShare
+	if share, err = s.genDecryptionShare(c.Party.Sk[params.LogN()], ct); err != nil {
+		return
+	}
+
+	var noise ring.DiscreteGaussian
+	if noise, err = s.DecryptionNoiseFlooding(); err != nil {
+		return
+	}
+
+	cks, err := mhe.NewKeySwitchProtocol(params, noise)
+	if err != nil {
+		return nil, fmt.Errorf("mhe.NewKeySwitchProtocol: %w", err)
+	}
+
+	for i := range shares {
+
+		if shares[i].Share.Level() != ct.Level() {
+			return nil, fmt.Errorf("invalid share of party %d: level %d, expected %d", shares[i].ID, shares[i].Share.Level(), ct.Level())
+		}
+
+		if err = cks.AggregateShares(share, shares[i].Share, &share); err != nil {
+			return nil, fmt.Errorf("cks.AggregateShares: %w", err)
+		}
+	}
+
+	// The output of the key-switch is encrypted under the zero key
+	ctZero := rlwe.NewCiphertext(params, 1, ct.Level())
+	cks.KeySwitch(ct, share, ctZero)
+
+	return rlwe.NewDecryptor(params, rlwe.NewSecretKey(params)).DecryptNew(ctZero), nil
+}
+
+// mapSecretKey maps a secret key to the ring degree of params with Y = X^{N/n}
+// and extends it from its first modulus to the given level.
+func mapSecretKey(params hefloat.Parameters, sk *rlwe.SecretKey, levelQ int) (skOut *rlwe.SecretKey) {
+
+	ringQ := params.RingQ()
+	buff := ringQ.NewPoly()
+
+	skOut = rlwe.NewSecretKey(params)
+
+	ring.MapSmallDimensionToLargerDimensionNTT(sk.Value.Q, skOut.Value.Q)
+	rlwe.ExtendBasisSmallNormAndCenterNTTMontgomery(ringQ, ringQ.AtLevel(levelQ), skOut.Value.Q, buff, skOut.Value.Q)
+
+	return
+}
@@ -0,0 +1,291 @@
+package pde
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
+	"github.com/tuneinsight/lattigo/v5/mhe"
+	"github.com/tuneinsight/lattigo/v5/ring"
+	"github.com/tuneinsight/lattigo/v5/utils"
+)
+
+func TestMultiparty(t *testing.T) {
+
+	cfg, err := NewConfig(ProfileTestInsecure)
+	require.NoError(t, err)
+
+	cfg.LogNEval = cfg.LogNPack + 1
+
+	paramsEval, err := hefloat.NewParametersFromLiteral(hefloat.ParametersLiteral{
+		LogN:            cfg.LogNEval,
+		LogQ:            []int{60, 45},
+		LogP:            []int{61},
+		LogDefaultScale: 45,
+		Xs:              ring.Ternary{H: 192},
+	})
+	require.NoError(t, err)
+
+	btpParams, err := bootstrapping.NewParametersFromLiteral(paramsEval, bootstrapping.ParametersLiteral{
+		LogN: utils.Pointy(paramsEval.LogN()),
+		LogP: []int{61, 61, 61},
+		Xs:   ring.Ternary{H: 192},
+	})
+	require.NoError(t, err)
+
+	ids := []mhe.ShamirPublicPoint{1, 2, 3}
+
+	_, err = newMultipartySetup(cfg, paramsEval, btpParams, ids, 4, nil)
+	require.Error(t, err)
+
+	_, err = newMultipartySetup(cfg, paramsEval, btpParams, []mhe.ShamirPublicPoint{1, 1}, 1, nil)
+	require.Error(t, err)
+
+	setup, err := newMultipartySetup(cfg, paramsEval, btpParams, ids, 2, []byte("pde multiparty test"))
+	require.NoError(t, err)
+
+	// The hospitals only exchange serialized public shares with the client
+	transfer := func(src interface{ MarshalBinary() ([]byte, error) }, dst interface{ UnmarshalBinary([]byte) error }) {
+		data, err := src.MarshalBinary()
+		require.NoError(t, err)
+		require.NoError(t, dst.UnmarshalBinary(data))
+	}
+
+	client := Client{}
+
+	hospitals := make([]*Party, len(ids))
+	for i, id := range ids {
+		hospitals[i], err = NewParty(id, setup)
+		require.NoError(t, err)
+	}
+
+	// Round one
+	shares := make([]KeyShares, len(ids)+1)
+
+	shares[0], err = client.InitMultiparty(setup)
+	require.NoError(t, err)
+
+	for i, h := range hospitals {
+		share, err := h.GenKeyShares()
+		require.NoError(t, err)
+		transfer(share, &shares[i+1])
+	}
+
+	_, err = setup.AggregateKeyShares(shares[1:])
+	require.Error(t, err)
+
+	_, err = setup.AggregateKeyShares(append(shares[:3:3], shares[1]))
+	require.Error(t, err)
+
+	agg, err := setup.AggregateKeyShares(shares)
+	require.NoError(t, err)
+
+	// Round two of the relinearization key
+	relin := make([]RelinearizationShare, len(ids)+1)
+
+	relin[0], err = client.Party.GenRelinearizationShare(agg)
+	require.NoError(t, err)
+
+	for i, h := range hospitals {
+
+		var received KeyShares
+		transfer(agg, &received)
+
+		share, err := h.GenRelinearizationShare(received)
+		require.NoError(t, err)
+		transfer(share, &relin[i+1])
+	}
+
+	evk, err := client.FinalizeMultiparty(agg, relin)
+	require.NoError(t, err)
+
+	// The hospitals share their secret keys over private channels
+	thresholdShares := map[mhe.ShamirPublicPoint]map[mhe.ShamirPublicPoint]mhe.ShamirSecretShare{}
+	for _, h := range hospitals {
+		thresholdShares[h.ID] = map[mhe.ShamirPublicPoint]mhe.ShamirSecretShare{}
+	}
+
+	for _, h := range hospitals {
+		out, err := h.GenThresholdShares()
+		require.NoError(t, err)
+		for recipient, share := range out {
+			thresholdShares[recipient][h.ID] = share
+		}
+	}
+
+	for _, h := range hospitals {
+		require.NoError(t, h.AggregateThresholdShares(thresholdShares[h.ID]))
+	}
+
+	_, err = client.Party.GenThresholdShares()
+	require.Error(t, err)
+
+	// Packs the constant coefficients of ciphertexts of degree 2^{LogNPack}
+	// and merges two packed ciphertexts into a ciphertext of degree 2^{LogNEval}.
+	paramsPack := *setup.Parameters[cfg.LogNPack]
+	ecd := hefloat.NewEncoder(paramsPack)
+	enc := client.encryptor(cfg.LogNPack)
+
+	encrypt := func(v float64) *rlwe.Ciphertext {
+		pt := hefloat.NewPlaintext(paramsPack, 0)
+		pt.IsBatched = false
+		require.NoError(t, ecd.Encode([]float64{v, 1, 2, 3}, pt))
+		ct, err := enc.EncryptNew(pt)
+		require.NoError(t, err)
+		return ct
+	}
+
+	eval := NewRepackEvaluator(&evk.RepackEvaluationKeySet)
+
+	even, err := eval.Pack(map[int]*rlwe.Ciphertext{0: encrypt(0.5), 1: encrypt(-0.25)})
+	require.NoError(t, err)
+
+	odd, err := eval.Pack(map[int]*rlwe.Ciphertext{0: encrypt(1.5), 1: encrypt(0.75)})
+	require.NoError(t, err)
+
+	ct, err := eval.MergeNew(even, odd)
+	require.NoError(t, err)
+
+	want := make([]float64, paramsEval.N())
+	copy(want, []float64{0.5, 1.5, -0.25, 0.75})
+
+	active := []mhe.ShamirPublicPoint{hospitals[0].ID, hospitals[2].ID}
+
+	decryptionShares := func(ct *rlwe.Ciphertext) (shares []DecryptionShare) {
+		shares = make([]DecryptionShare, len(active))
+		for i, h := range []*Party{hospitals[0], hospitals[2]} {
+			share, err := h.GenDecryptionShare(ct, active)
+			require.NoError(t, err)
+			transfer(share, &shares[i])
+		}
+		return
+	}
+
+	decShares := decryptionShares(ct)
+
+	decode := func(c Client, shares []DecryptionShare) (have []float64) {
+		pt, err := c.thresholdDecryptNew(ct, shares)
+		require.NoError(t, err)
+		have = make([]float64, paramsEval.N())
+		require.NoError(t, hefloat.NewEncoder(paramsEval).Decode(pt, have))
+		return
+	}
+
+	have := decode(client, decShares)
+	for i := range want {
+		require.InDelta(t, want[i], have[i], 1e-3)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+
+		// Less than threshold hospitals
+		_, err := client.thresholdDecryptNew(ct, decShares[:1])
+		require.Error(t, err)
+
+		_, err = hospitals[0].GenDecryptionShare(ct, active[:1])
+		require.Error(t, err)
+
+		// Not an active hospital
+		_, err = hospitals[1].GenDecryptionShare(ct, active)
+		require.Error(t, err)
+
+		// The same share twice
+		_, err = client.thresholdDecryptNew(ct, []DecryptionShare{decShares[0], decShares[0]})
+		require.Error(t, err)
+
+		// A share of an other active set
+		other, err := hospitals[1].GenDecryptionShare(ct, []mhe.ShamirPublicPoint{hospitals[1].ID, hospitals[2].ID})
+		require.NoError(t, err)
+
+		_, err = client.thresholdDecryptNew(ct, []DecryptionShare{decShares[0], other})
+		require.Error(t, err)
+
+		// The share of the client is required
+		party, err := NewParty(0, setup)
+		require.NoError(t, err)
+
+		impostor := client
+		impostor.Party = party
+
+		have := decode(impostor, decShares)
+
+		var diff float64
+		for i := range want {
+			diff = math.Max(diff, math.Abs(want[i]-have[i]))
+		}
+
+		require.Greater(t, diff, 1.0)
+	})
+
+	t.Run("NoiseFlooding", func(t *testing.T) {
+
+		noise, err := setup.DecryptionNoiseFlooding()
+		require.NoError(t, err)
+
+		// (1 + 4*192)/2 * 2^{20} for the sum of the keys of the client and the three hospitals
+		require.Equal(t, 769.0/2*(1<<DefaultDecryptionSecurity), noise.Sigma)
+
+		// The flooding would drown the output
+		noisy := *setup
+		noisy.DecryptionSecurity = 40
+		_, err = noisy.DecryptionNoiseFlooding()
+		require.Error(t, err)
+	})
+
+	t.Run("SparseSecretKey", func(t *testing.T) {
+
+		require.NotNil(t, setup.paramsSparse)
+
+		// The sum of the sparse keys of the parties is ternary of weight EphemeralSecretWeight
+		ringQ := setup.paramsSparse.RingQ().AtLevel(0)
+
+		sum := ringQ.NewPoly()
+		for _, p := range append([]*Party{client.Party}, hospitals...) {
+			sk := p.SkSparse.Value.Q.CopyNew()
+			ringQ.IMForm(*sk, *sk)
+			ringQ.INTT(*sk, *sk)
+			ringQ.Add(sum, *sk, sum)
+		}
+
+		q := ringQ.ModuliChain()[0]
+
+		var weight int
+		for _, c := range sum.Coeffs[0] {
+			require.Contains(t, []uint64{0, 1, q - 1}, c)
+			if c != 0 {
+				weight++
+			}
+		}
+
+		require.Equal(t, setup.Bootstrapping.EphemeralSecretWeight, weight)
+	})
+
+	t.Run("Bootstrapping", func(t *testing.T) {
+
+		btp, err := bootstrapping.NewEvaluator(btpParams, &evk.EvaluationKeys)
+		require.NoError(t, err)
+
+		values := []float64{0.5, -0.25, 0.125}
+
+		pt := hefloat.NewPlaintext(paramsEval, 0)
+		require.NoError(t, hefloat.NewEncoder(paramsEval).Encode(values, pt))
+
+		ct, err := client.encryptor(paramsEval.LogN()).EncryptNew(pt)
+		require.NoError(t, err)
+
+		ct, err = btp.Bootstrap(ct)
+		require.NoError(t, err)
+		require.Equal(t, paramsEval.MaxLevel(), ct.Level())
+
+		have, err := client.ThresholdDecrypt(ct, decryptionShares(ct))
+		require.NoError(t, err)
+
+		// The noise flooding of the decryption shares is amplified by the decoding
+		for i := range values {
+			require.InDelta(t, values[i], real(have[i]), 1e-2)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package pde
+
+import (
+	"fmt"
+)
+
+// Oracle is the cleartext reference of the circuit of a request: it evaluates
+// the scoring functions and the two thresholds exactly, on the same grid as the
+// encrypted test vectors, see Server.Precision.
+type Oracle struct {
+	// Funcs are the scoring functions of the columns.
+	Funcs []Func
+	// Threshold0 is the local threshold: the minimum score of a matching row.
+	Threshold0 float64
+	// Threshold1 is the global threshold: the minimum number of matching rows.
+	Threshold1 float64
+}
+
+// Oracle returns the Oracle of the request selecting the patients meeting the
+// criteria and testing whether there are at least minPatients of them, see
+// Client.GenEncryptedCriteria.
+func (c Criteria) Oracle(minPatients int) Oracle {
+	return Oracle{
+		Funcs:      c.Funcs,
+		Threshold0: c.Threshold,
+		Threshold1: float64(minPatients),
+	}
+}
+
+// OracleResult is the exact output of each stage of the circuit on a database.
+type OracleResult struct {
+	// Scores are the scores of the rows, i.e. sum_j Funcs[j](row[j]) at
+	// the positions of the values in the test vectors, scaled back by Scaling.
+	Scores []float64
+	// Indicators are the local thresholds of the rows:
+	// Step(Scores[i] - Threshold0 + 0.5), or zero if the row is skipped.
+	Indicators []float64
+	// Skipped are the rows skipped by PolicySkip.
+	Skipped []bool
+	// Count is the sum of the Indicators.
+	Count float64
+	// Decision is the global threshold: Step(Count - Threshold1 + 0.5).
+	Decision float64
+}
+
+// Evaluate evaluates the circuit on the database, with the policies of the schema
+// of the database for the functions with PolicyDefault, as Server.ProcessRequest.
+// It returns an error if a value cannot be looked up, as TestVectors.Evaluate.
+func (o Oracle) Evaluate(db *Database) (res OracleResult, err error) {
+
+	if o.Funcs, err = withPolicies(o.Funcs, db.Schema); err != nil {
+		return res, fmt.Errorf("withPolicies: %w", err)
+	}
+
+	rows, _ := db.Dims()
+
+	res.Scores = make([]float64, rows)
+	res.Indicators = make([]float64, rows)
+	res.Skipped = make([]bool, rows)
+
+	for i := 0; i < rows; i++ {
+
+		if res.Scores[i], res.Skipped[i], err = o.score(db.GetRow(i)); err != nil {
+			return OracleResult{}, fmt.Errorf("row %d: %w", i, err)
+		}
+
+		if !res.Skipped[i] {
+			res.Indicators[i] = step(res.Scores[i] - o.Threshold0 + 0.5)
+		}
+
+		res.Count += res.Indicators[i]
+	}
+
+	res.Decision = step(res.Count - o.Threshold1 + 0.5)
+
+	return
+}
+
+// Score returns the score of a row, which is zero if the row is skipped, see PolicySkip.
+// The functions with PolicyDefault have PolicyError.
+func (o Oracle) Score(row []float64) (score float64, err error) {
+	score, _, err = o.score(row)
+	return
+}
+
+// score is Oracle.Score, which also returns whether the row is skipped.
+func (o Oracle) score(row []float64) (score float64, skipped bool, err error) {
+
+	for j, f := range o.Funcs {
+
+		var position int
+		var frac float64
+		var applied policySet
+		if position, frac, applied, err = f.testVector().position(row, j, f.Interpolate); err != nil {
+			return 0, false, err
+		}
+
+		if applied.has(PolicySkip) {
+			return 0, true, nil
+		}
+
+		if applied.has(PolicyMissing) {
+			score += f.Missing * float64(Scaling)
+			continue
+		}
+
+		y := f.value(position)
+
+		if f.Interpolate {
+			y += frac * (f.value(position+1) - y)
+		}
+
+		score += y * float64(Scaling)
+	}
+
+	return
+}
+
+// step is the step function approximated by the comparisons:
+// 1 if x > 0, 1/2 if x = 0 and 0 otherwise.
+func step(x float64) float64 {
+	switch {
+	case x > 0:
+		return 1
+	case x == 0:
+		return 0.5
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,82 @@
+package pde
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestOracle(t *testing.T) {
+
+	schema := Schema{
+		{Name: "age", Interval: [2]float64{0, 120}},
+		{Name: "sex", Type: Categorical, Categories: []string{"F", "M"}},
+	}
+
+	c, err := CompileCriteria("age > 40 AND sex = M", schema, 121)
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(0))
+
+	rows := 512
+	data := make([]float64, 2*rows)
+	for i := 0; i < rows; i++ {
+		data[2*i] = float64(r.Intn(121))
+		data[2*i+1] = float64(r.Intn(2))
+	}
+
+	db := Database{Dense: mat.NewDense(rows, 2, data), Schema: schema}
+
+	var want int
+	for i := 0; i < rows; i++ {
+		if c.Match(db.GetRow(i)) {
+			want++
+		}
+	}
+
+	for _, tc := range []struct {
+		minPatients int
+		decision    float64
+	}{
+		{want, 1},
+		{want + 1, 0},
+	} {
+
+		res, err := c.Oracle(tc.minPatients).Evaluate(&db)
+		require.NoError(t, err)
+
+		for i := 0; i < rows; i++ {
+
+			row := db.GetRow(i)
+			require.Equal(t, c.Score(row), res.Scores[i], "%v", row)
+
+			var indicator float64
+			if c.Match(row) {
+				indicator = 1
+			}
+
+			require.Equal(t, indicator, res.Indicators[i], "%v", row)
+		}
+
+		require.Equal(t, float64(want), res.Count)
+		require.Equal(t, tc.decision, res.Decision)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+
+		oracle := c.Oracle(want)
+
+		for _, row := range [][]float64{
+			{200, 0},
+			{-1, 0},
+			{40, 2},
+			{40, 0.5},
+			{40},
+		} {
+			_, err := oracle.Score(row)
+			require.Error(t, err, "%v", row)
+		}
+	})
+}
@@ -1,29 +1,284 @@
 package pde
 
 import (
+	"fmt"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
 	"github.com/tuneinsight/lattigo/v5/he/hefloat"
 	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
 	"github.com/tuneinsight/lattigo/v5/ring"
 	"github.com/tuneinsight/lattigo/v5/utils"
 )
 
-var LogNPack = 12
-var LogNEval = 16
-var LogScale = 45
-var Scaling = 1 << 16
-var DBSize = 1 << 14
-var Features = 16
-
-var ParametersLiteralLogN16 = hefloat.ParametersLiteral{
-	LogN:            LogNEval,
-	LogQ:            []int{60, LogScale, LogScale, LogScale, LogScale, LogScale, LogScale, LogScale, LogScale},
-	LogP:            []int{48, 55, 55},
-	LogDefaultScale: LogScale,
-	Xs:              ring.Ternary{H: 192},
-}
-
-var BootstrappingParametersLiteral = bootstrapping.ParametersLiteral{
-	LogN: utils.Pointy(LogNEval),
-	LogP: []int{61, 61, 61, 61, 61},
-	Xs:   ring.Ternary{H: 192},
+// Scaling is the scaling factor of the scoring functions: the test vectors
+// encode F(x)/Scaling and the scheme-switching scales them back by Scaling.
+const Scaling = 1 << 16
+
+// maxThresholdLogPrecision is the maximum ThresholdLogPrecision of the configurations
+// of NewConfig. A larger precision does not reduce the degrees of the sign polynomials,
+// so the thresholds tolerate larger errors than the ones of the scheme-switching.
+const maxThresholdLogPrecision = 5
+
+// Names of the parameter profiles, see NewConfig.
+const (
+	// ProfileTestInsecure are small and insecure parameters, to test the
+	// full pipeline, including the bootstrapping, in a few seconds.
+	ProfileTestInsecure = "test-insecure"
+
+	// Profile128 are the parameters of the presentation, with 128-bit
+	// security. The server needs about 22GB of RAM.
+	Profile128 = "128-bit"
+
+	// ProfileLowMemory are 128-bit secure parameters with fewer levels after the
+	// bootstrapping, which reduces the size of the keys and of the ciphertexts
+	// at the cost of more bootstrappings during the thresholds.
+	ProfileLowMemory = "low-memory"
+)
+
+// Config is the configuration of the circuit, shared by the client and the server.
+// The parameters of the ring degrees 2^{LogNPack} to 2^{LogNEval-1} are derived
+// from the parameters of degree 2^{LogNEval}, see NewRingSwitchingParameters.
+type Config struct {
+	// Profile is the name of the profile of the configuration.
+	Profile string
+
+	// LogNPack is the ring degree of the test vectors and of the repacking.
+	LogNPack int
+	// LogNEval is the ring degree of the scheme-switching and of the thresholds.
+	LogNEval int
+
+	// Parameters are the parameters of degree 2^{LogNEval}.
+	Parameters hefloat.ParametersLiteral
+	// Bootstrapping are the parameters of the bootstrapping and of the scheme-switching.
+	Bootstrapping bootstrapping.ParametersLiteral
+	// EvaluationKeys are the parameters of the ring switching and repacking keys.
+	EvaluationKeys rlwe.EvaluationKeyParameters
+
+	// MaxScore is the maximum sum of Func.Max of the scoring functions of a request,
+	// which bounds the resolution of its local threshold, see Config.CheckLocalThreshold.
+	MaxScore float64
+	// ThresholdLogPrecision is the precision in bits of the scores after the scheme-switching
+	// and of the counts after the inner sum: the thresholds tolerate errors of up to
+	// 2^{-ThresholdLogPrecision} of their inputs. It cannot be greater than the
+	// Config.SchemeSwitchingLogPrecision.
+	ThresholdLogPrecision int
+
+	// DBSize and Features are the dimensions of the synthetic database, see NewDatabase.
+	DBSize   int
+	Features int
+}
+
+// NewConfig returns the configuration of the given profile.
+func NewConfig(profile string) (cfg Config, err error) {
+
+	var logQ, logP, btpLogP []int
+
+	cfg = Config{
+		Profile:  profile,
+		LogNPack: 12,
+		MaxScore: MaxCriteriaWeight,
+	}
+
+	switch profile {
+	case ProfileTestInsecure:
+		cfg.LogNEval = 13
+		cfg.DBSize, cfg.Features = 1<<11, 4
+		logQ, logP, btpLogP = []int{60, 45, 45, 45, 45, 45, 45, 45, 45}, []int{48, 55, 55}, []int{61, 61, 61, 61, 61}
+	case Profile128:
+		cfg.LogNEval = 16
+		cfg.DBSize, cfg.Features = 1<<14, 16
+		logQ, logP, btpLogP = []int{60, 45, 45, 45, 45, 45, 45, 45, 45}, []int{48, 55, 55}, []int{61, 61, 61, 61, 61}
+	case ProfileLowMemory:
+		cfg.LogNEval = 16
+		cfg.DBSize, cfg.Features = 1<<14, 16
+		logQ, logP, btpLogP = []int{60, 45, 45, 45, 45, 45}, []int{48, 55}, []int{61, 61, 61, 61}
+	default:
+		return cfg, fmt.Errorf("unknown profile %q", profile)
+	}
+
+	cfg.Parameters = hefloat.ParametersLiteral{
+		LogN:            cfg.LogNEval,
+		LogQ:            logQ,
+		LogP:            logP,
+		LogDefaultScale: 45,
+		Xs:              ring.Ternary{H: 192},
+	}
+
+	cfg.Bootstrapping = bootstrapping.ParametersLiteral{
+		LogN: utils.Pointy(cfg.LogNEval),
+		LogP: btpLogP,
+		Xs:   ring.Ternary{H: 192},
+	}
+
+	cfg.EvaluationKeys = rlwe.EvaluationKeyParameters{
+		LevelQ:               utils.Pointy(0),
+		LevelP:               utils.Pointy(0),
+		BaseTwoDecomposition: utils.Pointy(30),
+	}
+
+	var logPrec int
+	if logPrec, err = cfg.SchemeSwitchingLogPrecision(); err != nil {
+		return cfg, fmt.Errorf("cfg.SchemeSwitchingLogPrecision: %w", err)
+	}
+
+	cfg.ThresholdLogPrecision = utils.Min(logPrec, maxThresholdLogPrecision)
+
+	return cfg, cfg.Check()
+}
+
+// Check returns an error if the configuration is invalid.
+func (cfg Config) Check() (err error) {
+
+	if cfg.LogNPack < 1 || cfg.LogNPack >= cfg.LogNEval {
+		return fmt.Errorf("invalid config: LogNPack=%d must be in [1, LogNEval=%d)", cfg.LogNPack, cfg.LogNEval)
+	}
+
+	if cfg.Parameters.LogN != cfg.LogNEval {
+		return fmt.Errorf("invalid config: Parameters.LogN=%d != LogNEval=%d", cfg.Parameters.LogN, cfg.LogNEval)
+	}
+
+	if cfg.Bootstrapping.LogN != nil && *cfg.Bootstrapping.LogN != cfg.LogNEval {
+		return fmt.Errorf("invalid config: Bootstrapping.LogN=%d != LogNEval=%d", *cfg.Bootstrapping.LogN, cfg.LogNEval)
+	}
+
+	if cfg.DBSize < 1 || cfg.Features < 1 {
+		return fmt.Errorf("invalid config: the synthetic database must have at least one row and one column")
+	}
+
+	var paramsEval hefloat.Parameters
+	if paramsEval, err = cfg.ParametersEval(); err != nil {
+		return
+	}
+
+	if cfg.MaxScore <= 0 || cfg.ThresholdLogPrecision < 1 {
+		return fmt.Errorf("invalid config: MaxScore=%f and ThresholdLogPrecision=%d must be positive", cfg.MaxScore, cfg.ThresholdLogPrecision)
+	}
+
+	if _, err = NewRingSwitchingParameters(paramsEval, cfg.LogNPack, cfg.EvaluationKeys); err != nil {
+		return fmt.Errorf("invalid config: NewRingSwitchingParameters: %w", err)
+	}
+
+	var logPrec int
+	if logPrec, err = cfg.SchemeSwitchingLogPrecision(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	if cfg.ThresholdLogPrecision > logPrec {
+		return fmt.Errorf("invalid config: ThresholdLogPrecision=%d is greater than the precision of the scheme-switching %d", cfg.ThresholdLogPrecision, logPrec)
+	}
+
+	// The thresholds of the largest requests
+	if err = cfg.CheckLocalThreshold(cfg.MaxScore); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	if err = cfg.CheckGlobalThreshold(cfg.DBSize); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	return
+}
+
+// SchemeSwitchingLogPrecision returns an estimate of the precision in bits of the scores
+// after the scheme-switching. The error of the EvalMod of the bootstrapping is about
+// 2^{LogN} * Q[0] / 2^{Mod1 LogScale} on the coefficients at the default scale, which
+// encode the scores divided by Scaling.
+func (cfg Config) SchemeSwitchingLogPrecision() (logPrec int, err error) {
+
+	var paramsEval hefloat.Parameters
+	if paramsEval, err = cfg.ParametersEval(); err != nil {
+		return
+	}
+
+	var paramsBtp bootstrapping.Parameters
+	if paramsBtp, err = cfg.ParametersBootstrapping(paramsEval); err != nil {
+		return
+	}
+
+	logErr := float64(paramsEval.LogN()) + math.Log2(float64(paramsEval.Q()[0])) - float64(paramsBtp.Mod1ParametersLiteral.LogScale)
+	logErr += math.Log2(Scaling) - math.Log2(paramsEval.DefaultScale().Float64())
+
+	return int(math.Floor(-logErr)), nil
+}
+
+// CheckLocalThreshold returns an error if the local threshold of a request
+// whose sum of Func.Max is max cannot be evaluated with the configuration.
+func (cfg Config) CheckLocalThreshold(max float64) (err error) {
+
+	if max <= 0 || max > cfg.MaxScore {
+		return fmt.Errorf("the sum of the maximums of the functions %f is not in (0, MaxScore=%f]", max, cfg.MaxScore)
+	}
+
+	return cfg.checkThreshold(cfg.LocalThresholdPolynomial(max))
+}
+
+// CheckGlobalThreshold returns an error if the global threshold
+// on the given number of rows cannot be evaluated with the configuration.
+func (cfg Config) CheckGlobalThreshold(rows int) (err error) {
+
+	if rows < 1 {
+		return fmt.Errorf("invalid #rows=%d", rows)
+	}
+
+	return cfg.checkThreshold(cfg.GlobalThresholdPolynomial(rows))
+}
+
+// checkThreshold returns an error if the parameters do not have
+// enough levels to evaluate a threshold with the sign polynomial p.
+func (cfg Config) checkThreshold(p SignPolynomial) (err error) {
+
+	var paramsEval hefloat.Parameters
+	if paramsEval, err = cfg.ParametersEval(); err != nil {
+		return
+	}
+
+	// The thresholds multiply by the normalization before evaluating the sign
+	if depth := p.Depth() + 1; paramsEval.MaxLevel() < depth {
+		return fmt.Errorf("the parameters have %d levels but the threshold with %v needs at least %d", paramsEval.MaxLevel(), p, depth)
+	}
+
+	return
+}
+
+// LocalThresholdPolynomial returns the sign polynomial of the local threshold of a
+// request whose sum of Func.Max is max, which distinguishes the scores differing
+// by 1/2 normalized by 1/(max+1/2), the bound of Score - t0 + 1/2 for a score in
+// [0, max] and t0 in [0, max+1], see Client.GenPrivateThreshold.
+func (cfg Config) LocalThresholdPolynomial(max float64) SignPolynomial {
+	return NewSignPolynomial(max+0.5, cfg.ThresholdLogPrecision)
+}
+
+// GlobalThresholdPolynomial returns the sign polynomial of the global threshold of
+// a request on the given number of rows, which distinguishes the counts differing
+// by 1/2 normalized by 1/rows.
+func (cfg Config) GlobalThresholdPolynomial(rows int) SignPolynomial {
+	return NewSignPolynomial(float64(rows), cfg.ThresholdLogPrecision)
+}
+
+// ParametersEval returns the parameters of degree 2^{LogNEval}.
+func (cfg Config) ParametersEval() (params hefloat.Parameters, err error) {
+	if params, err = hefloat.NewParametersFromLiteral(cfg.Parameters); err != nil {
+		return params, fmt.Errorf("hefloat.NewParametersFromLiteral: %w", err)
+	}
+	return
+}
+
+// ParametersBootstrapping returns the parameters of the bootstrapping of paramsEval.
+func (cfg Config) ParametersBootstrapping(paramsEval hefloat.Parameters) (params bootstrapping.Parameters, err error) {
+	if params, err = bootstrapping.NewParametersFromLiteral(paramsEval, cfg.Bootstrapping); err != nil {
+		return params, fmt.Errorf("bootstrapping.NewParametersFromLiteral: %w", err)
+	}
+	return
+}
+
+// CheckParameters returns an error if the parameters, indexed by their LogN,
+// do not include the ring degrees 2^{LogNPack} and 2^{LogNEval} of the configuration.
+func (cfg Config) CheckParameters(params map[int]*hefloat.Parameters) (err error) {
+	for _, LogN := range []int{cfg.LogNPack, cfg.LogNEval} {
+		if p, ok := params[LogN]; !ok || p == nil {
+			return fmt.Errorf("missing the parameters of LogN=%d", LogN)
+		}
+	}
+	return
 }
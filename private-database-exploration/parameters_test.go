@@ -0,0 +1,123 @@
+package pde
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+
+	for _, profile := range []string{ProfileTestInsecure, Profile128, ProfileLowMemory} {
+		t.Run(profile, func(t *testing.T) {
+			cfg, err := NewConfig(profile)
+			require.NoError(t, err)
+			require.Equal(t, profile, cfg.Profile)
+		})
+	}
+
+	_, err := NewConfig("unknown")
+	require.Error(t, err)
+
+	for _, tc := range []struct {
+		name   string
+		modify func(cfg *Config)
+	}{
+		{"LogNPack", func(cfg *Config) { cfg.LogNPack = cfg.LogNEval }},
+		{"Parameters.LogN", func(cfg *Config) { cfg.LogNEval++ }},
+		{"Bootstrapping.LogN", func(cfg *Config) { *cfg.Bootstrapping.LogN = cfg.LogNEval + 1 }},
+		{"Levels", func(cfg *Config) { cfg.Parameters.LogQ = cfg.Parameters.LogQ[:3] }},
+		{"DBSize", func(cfg *Config) { cfg.DBSize = 0 }},
+		{"MaxScore", func(cfg *Config) { cfg.MaxScore = 0 }},
+		{"ThresholdLogPrecision", func(cfg *Config) { cfg.ThresholdLogPrecision = 32 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := NewConfig(ProfileTestInsecure)
+			require.NoError(t, err)
+			tc.modify(&cfg)
+			require.Error(t, cfg.Check())
+		})
+	}
+
+	t.Run("ThresholdLogPrecision", func(t *testing.T) {
+
+		cfg, err := NewConfig(ProfileTestInsecure)
+		require.NoError(t, err)
+
+		// 2^{13} * 2^{60} / 2^{60} * 2^{16} / 2^{45}
+		logPrec, err := cfg.SchemeSwitchingLogPrecision()
+		require.NoError(t, err)
+		require.Equal(t, 16, logPrec)
+		require.Equal(t, 5, cfg.ThresholdLogPrecision)
+	})
+
+	t.Run("ThresholdPolynomials", func(t *testing.T) {
+
+		cfg, err := NewConfig(ProfileTestInsecure)
+		require.NoError(t, err)
+
+		// The precomputed polynomials of the default configurations
+		require.Equal(t, SignPolynomial{8, 12, []int{15, 15, 15}}, cfg.LocalThresholdPolynomial(cfg.MaxScore))
+		require.Equal(t, MinimaxCompositePolynomialForSignThreshold0, cfg.LocalThresholdPolynomial(cfg.MaxScore).Coefficients())
+		require.Equal(t, SignPolynomial{16, 20, []int{15, 15, 15, 15, 15}}, cfg.GlobalThresholdPolynomial(1<<15))
+		require.Equal(t, MinimaxCompositePolynomialForSignThreshold1, cfg.GlobalThresholdPolynomial(1<<15).Coefficients())
+
+		// The resolution follows the number of rows
+		require.Equal(t, 12, cfg.GlobalThresholdPolynomial(300).LogAlpha)
+		require.Equal(t, 20, cfg.GlobalThresholdPolynomial(1<<16).LogAlpha)
+
+		// The resolution follows the sum of Func.Max of the request
+		require.Equal(t, 4, cfg.LocalThresholdPolynomial(5).LogAlpha)
+		require.Equal(t, 12, cfg.LocalThresholdPolynomial(256).LogAlpha)
+
+		// The thresholds of a request are checked against the configuration
+		require.NoError(t, cfg.CheckLocalThreshold(5))
+		require.Error(t, cfg.CheckLocalThreshold(0))
+		require.Error(t, cfg.CheckLocalThreshold(cfg.MaxScore+1))
+		require.NoError(t, cfg.CheckGlobalThreshold(1<<20))
+		require.Error(t, cfg.CheckGlobalThreshold(0))
+
+		// Generated at runtime
+		p := NewSignPolynomial(4, cfg.ThresholdLogPrecision)
+		require.Equal(t, SignPolynomial{4, 8, []int{15, 15}}, p)
+
+		poly := p.Polynomial()
+		for _, x := range []float64{1.0 / 16, 0.5, 1} {
+			y, _ := poly.Evaluate(x).Real().Float64()
+			require.InDelta(t, 1, y, 1e-2, x)
+			y, _ = poly.Evaluate(-x).Real().Float64()
+			require.InDelta(t, -1, y, 1e-2, -x)
+		}
+
+		// Concurrent calls generate a polynomial once, and do not block the cached ones
+		q := SignPolynomial{4, 9, []int{15, 15}}
+
+		var wg sync.WaitGroup
+		coeffs := make([][][]string, 4)
+		for i := range coeffs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if i%2 == 0 {
+					coeffs[i] = q.Coefficients()
+				} else {
+					coeffs[i] = cfg.GlobalThresholdPolynomial(1 << 15).Coefficients()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		require.Equal(t, coeffs[0], coeffs[2])
+		require.Equal(t, MinimaxCompositePolynomialForSignThreshold1, coeffs[1])
+		require.Equal(t, MinimaxCompositePolynomialForSignThreshold1, coeffs[3])
+		require.Equal(t, coeffs[0], q.Coefficients())
+		require.Equal(t, p.Coefficients(), p.Coefficients())
+	})
+
+	t.Run("CheckParameters", func(t *testing.T) {
+		cfg, err := NewConfig(ProfileTestInsecure)
+		require.NoError(t, err)
+		require.Error(t, cfg.CheckParameters(nil))
+	})
+}
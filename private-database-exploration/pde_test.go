@@ -1,31 +1,45 @@
 package pde
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"gonum.org/v1/gonum/mat"
 )
 
-func TestPDE(t *testing.T) {
+var flagProfile = flag.String("profile", Profile128, "parameter profile of TestPDE: test-insecure, 128-bit or low-memory")
 
-	t.Log("Create Client")
-	client := NewClient()
+func TestPDE(t *testing.T) {
 
-	t.Log("Generate Evaluation Keys (might take 30 to 60sec)")
-	now := time.Now()
-	evk, err := client.Init()
+	cfg, err := NewConfig(*flagProfile)
 	require.NoError(t, err)
 
 	t.Log("Generate Pre-Processing Matrix")
 
 	// 16 dummy scoring function
-	funcs := make([]Func, Features)
+	funcs := make([]Func, cfg.Features)
+	for i := range funcs {
+		funcs[i] = NewScoringFunction([2]float64{0, 4}, 2<<cfg.LogNPack, 1/float64(Scaling))
+	}
+
+	// The local threshold distinguishes the scores of the dummy scoring functions
+	cfg.MaxScore = 0
 	for i := range funcs {
-		funcs[i] = NewScoringFunction([2]float64{0, 4}, 2<<LogNPack, 1/float64(Scaling))
+		cfg.MaxScore += funcs[i].Max
 	}
 
+	t.Log("Create Client")
+	client := NewClient()
+
+	t.Log("Generate Evaluation Keys (might take 30 to 60sec)")
+	now := time.Now()
+	evk, err := client.Init(cfg)
+	require.NoError(t, err)
+
 	t.Log("Generating Encrypted Functions")
 	tvs, err := client.GenEncryptedFunction(funcs)
 	require.NoError(t, err)
@@ -34,7 +48,7 @@ func TestPDE(t *testing.T) {
 	privThresh0, err := client.GenPrivateThreshold(12, funcs)
 	require.NoError(t, err)
 
-	privThresh1, err := client.GenPrivateThreshold(float64(DBSize/100), nil)
+	privThresh1, err := client.GenPrivateThreshold(float64(cfg.DBSize/100), nil)
 	require.NoError(t, err)
 
 	// Client request
@@ -53,13 +67,13 @@ func TestPDE(t *testing.T) {
 	server.SecretKeyBootstrapping = false // Use dummy bootstrapper during threshold (much faster),
 	// only possible if server.SkDebug is set
 	t.Log("Loading Dataset")
-	db := NewDatabase(DBSize, Features)
+	db := NewDatabase(cfg.DBSize, cfg.Features)
 
-	btp, err := NewBootstrappingEvaluator(request)
+	btp, err := server.NewBootstrapper(cfg, request)
 	require.NoError(t, err)
 
 	t.Log("Processing Request")
-	score, err := server.ProcessRequest(request, &db, btp)
+	score, err := server.ProcessRequest(context.Background(), cfg, request, &db, btp)
 	require.NoError(t, err)
 
 	t.Log("Client Response Decryption")
@@ -67,4 +81,41 @@ func TestPDE(t *testing.T) {
 	require.NoError(t, err)
 
 	fmt.Printf("Result: %10.7f\n", v[0])
+
+	t.Log("Processing Federated Request")
+
+	// The same patients split among two hospitals
+	rows, cols := db.Dims()
+	hospitals := []Database{
+		{Dense: db.Slice(0, rows/2, 0, cols).(*mat.Dense)},
+		{Dense: db.Slice(rows/2, rows, 0, cols).(*mat.Dense)},
+	}
+
+	partials := make([]PartialCount, len(hospitals))
+	for i := range hospitals {
+		partials[i], err = server.ProcessPartialRequest(context.Background(), cfg, request, &hospitals[i], btp)
+		require.NoError(t, err)
+	}
+
+	score, err = server.Aggregate(context.Background(), cfg, request, partials, btp)
+	require.NoError(t, err)
+
+	w, err := client.Decrypt(score)
+	require.NoError(t, err)
+
+	fmt.Printf("Federated Result: %10.7f\n", w[0])
+	require.InDelta(t, real(v[0]), real(w[0]), 0.1)
+
+	t.Log("Processing Noisy Count")
+
+	server.Accountant = NewPrivacyAccountant(PrivacyBudget{Epsilon: 1}, PrivacyBudget{Epsilon: 0.5})
+	request.Privacy = &DifferentialPrivacy{Noise: DiscreteLaplace, Epsilon: 0.5}
+
+	score, err = server.Aggregate(context.Background(), cfg, request, partials, btp)
+	require.NoError(t, err)
+
+	w, err = client.Decrypt(score)
+	require.NoError(t, err)
+
+	fmt.Printf("Noisy Count: %10.7f\n", w[0])
 }
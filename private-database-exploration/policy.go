@@ -0,0 +1,241 @@
+package pde
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+)
+
+// ValuePolicy is the handling of the values of a column which are missing, i.e.
+// NaN, or out of the grid of a test vector, see TestVectors.Evaluate. It is chosen
+// per column in the Schema, and can be overridden per function in the request, see
+// Func.Policy.
+type ValuePolicy int
+
+const (
+	// PolicyDefault is the policy of the column in the schema, or
+	// PolicyError if the column has none or the database no schema.
+	PolicyDefault ValuePolicy = iota
+	// PolicyError returns an error, which aborts the request.
+	PolicyError
+	// PolicyClamp evaluates the function at the first or last point of its grid,
+	// or at the nearest category index. Missing values cannot be clamped and return
+	// an error.
+	PolicyClamp
+	// PolicyMissing evaluates the function at its missing index, Points, the score
+	// of which is encrypted by the client, see Func.Missing.
+	PolicyMissing
+	// PolicySkip replaces the score of the whole row by an encryption of zero, as for
+	// the padding of the last chunk of rows, and excludes the row from the count
+	// whatever the local threshold, see Server.ExcludeRows.
+	PolicySkip
+)
+
+// String returns the name of the policy.
+func (p ValuePolicy) String() string {
+	switch p {
+	case PolicyDefault:
+		return "default"
+	case PolicyError:
+		return "error"
+	case PolicyClamp:
+		return "clamp"
+	case PolicyMissing:
+		return "missing"
+	case PolicySkip:
+		return "skip"
+	default:
+		return fmt.Sprintf("ValuePolicy(%d)", int(p))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (p ValuePolicy) MarshalText() ([]byte, error) {
+	if p < PolicyDefault || p > PolicySkip {
+		return nil, fmt.Errorf("invalid value policy: %d", int(p))
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (p *ValuePolicy) UnmarshalText(text []byte) error {
+	for q := PolicyDefault; q <= PolicySkip; q++ {
+		if string(text) == q.String() {
+			*p = q
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid value policy: %q", text)
+}
+
+// PolicyCounts are the numbers of rows whose values were handled by each ValuePolicy,
+// see Server.Policies. A row is counted once per policy applied to any of its values.
+// The counts are kept by the server and are not sent to the client.
+type PolicyCounts struct {
+	Clamped atomic.Int64
+	Missing atomic.Int64
+	Skipped atomic.Int64
+}
+
+// policySet is the set of the policies applied to the values of a row.
+type policySet uint8
+
+func (ps policySet) has(p ValuePolicy) bool {
+	return ps&(1<<p) != 0
+}
+
+// counts returns the Clamped, Missing and Skipped counts.
+func (pc *PolicyCounts) counts() [3]int64 {
+	return [3]int64{pc.Clamped.Load(), pc.Missing.Load(), pc.Skipped.Load()}
+}
+
+// addCounts adds the Clamped, Missing and Skipped counts, if pc is not nil.
+func (pc *PolicyCounts) addCounts(counts [3]int64) {
+
+	if pc == nil {
+		return
+	}
+
+	pc.Clamped.Add(counts[0])
+	pc.Missing.Add(counts[1])
+	pc.Skipped.Add(counts[2])
+}
+
+// add counts the row whose values were handled by the policies, if pc is not nil.
+func (pc *PolicyCounts) add(ps policySet) {
+
+	if pc == nil {
+		return
+	}
+
+	if ps.has(PolicyClamp) {
+		pc.Clamped.Add(1)
+	}
+
+	if ps.has(PolicyMissing) {
+		pc.Missing.Add(1)
+	}
+
+	if ps.has(PolicySkip) {
+		pc.Skipped.Add(1)
+	}
+}
+
+// lookup returns the position of the value on a grid as gridPosition, applying the
+// policy if the value is missing or out of the grid. The position of a missing value
+// is left to the caller, as it depends on the whole test vector.
+func lookup(value float64, interval [2]float64, points int, categorical, interpolate bool, policy ValuePolicy) (position int, frac float64, applied ValuePolicy, err error) {
+
+	if position, frac, err = gridPosition(value, interval, points, categorical, interpolate); err == nil {
+		return position, frac, PolicyDefault, nil
+	}
+
+	switch policy {
+	case PolicyClamp:
+
+		if math.IsNaN(value) {
+			return 0, 0, PolicyDefault, fmt.Errorf("cannot clamp a missing value: %w", err)
+		}
+
+		if categorical {
+			return int(math.Max(0, math.Min(math.Round(value), float64(points-1)))), 0, PolicyClamp, nil
+		}
+
+		if value < interval[0] {
+			return 0, 0, PolicyClamp, nil
+		}
+
+		// The last point is evaluated as the end of the last step
+		if interpolate {
+			return points - 2, 1, PolicyClamp, nil
+		}
+
+		return points - 1, 0, PolicyClamp, nil
+
+	case PolicyMissing, PolicySkip:
+		return 0, 0, policy, nil
+
+	default:
+		return 0, 0, PolicyDefault, err
+	}
+}
+
+// policy returns the policy of the schema for a function consuming the columns,
+// which must share the same policy.
+func (s Schema) policy(columns []int) (policy ValuePolicy, err error) {
+
+	if s == nil {
+		return PolicyError, nil
+	}
+
+	for k, c := range columns {
+
+		if c < 0 || c >= len(s) {
+			return PolicyDefault, fmt.Errorf("column %d not in [0, %d)", c, len(s))
+		}
+
+		p := s[c].Policy
+		if p == PolicyDefault {
+			p = PolicyError
+		}
+
+		if k != 0 && p != policy {
+			return PolicyDefault, fmt.Errorf("the columns %v have different policies", columns)
+		}
+
+		policy = p
+	}
+
+	return
+}
+
+// withPolicies returns a copy of the test vectors whose PolicyDefault is replaced by
+// the policy of the schema. A test vector without a missing index, see Func.Missing,
+// cannot have the PolicyMissing of the schema.
+func (tv TestVectors) withPolicies(schema Schema) (resolved TestVectors, err error) {
+
+	resolved = make(TestVectors, len(tv))
+
+	for i, t := range tv {
+
+		if t.Policy == PolicyDefault {
+
+			if t.Policy, err = schema.policy(consumedColumns(t.Columns, i)); err != nil {
+				return nil, fmt.Errorf("TestVectors[%d]: %w", i, err)
+			}
+
+			if t.Policy == PolicyMissing {
+				return nil, fmt.Errorf("TestVectors[%d]: the policy of the schema is %s but the test vector has no missing index", i, PolicyMissing)
+			}
+		}
+
+		resolved[i] = t
+	}
+
+	return
+}
+
+// withPolicies returns a copy of the functions whose PolicyDefault is replaced by
+// the policy of the schema, see TestVectors.withPolicies.
+func withPolicies(funcs []Func, schema Schema) (resolved []Func, err error) {
+
+	resolved = make([]Func, len(funcs))
+
+	for i, f := range funcs {
+
+		if f.Policy == PolicyDefault {
+
+			if f.Policy, err = schema.policy(consumedColumns(f.Columns, i)); err != nil {
+				return nil, fmt.Errorf("Funcs[%d]: %w", i, err)
+			}
+
+			if f.Policy == PolicyMissing {
+				return nil, fmt.Errorf("Funcs[%d]: the policy of the schema is %s but the function has no missing index", i, PolicyMissing)
+			}
+		}
+
+		resolved[i] = f
+	}
+
+	return
+}
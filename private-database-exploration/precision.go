@@ -0,0 +1,345 @@
+package pde
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/utils"
+)
+
+// StagePrecision is the precision of the decrypted values of a stage of the
+// circuit against their cleartext reference computed by an Oracle.
+type StagePrecision struct {
+	// Values is the number of values compared.
+	Values int `json:"values"`
+	// MaxError and MeanError are the maximum and mean absolute errors of the values.
+	MaxError  float64 `json:"max_error"`
+	MeanError float64 `json:"mean_error"`
+	// Misclassified is the number of rows on the wrong side of a threshold, see PrecisionReport.
+	Misclassified int `json:"misclassified"`
+}
+
+// add adds the error of a value to the precision.
+func (p *StagePrecision) add(have, want float64, misclassified int) {
+	err := math.Abs(have - want)
+	p.Values++
+	p.MaxError = math.Max(p.MaxError, err)
+	p.MeanError += (err - p.MeanError) / float64(p.Values)
+	p.Misclassified += misclassified
+}
+
+// PrecisionReport is the precision of each stage of the circuit of a request on a
+// database, see Server.Precision.
+//
+// The scores of the rows after the lookup tables, the packing, the merging and the
+// scheme-switching are compared with Oracle.Scores, and a row is misclassified if
+// the exact local threshold of its decrypted score differs from the exact local
+// threshold of its score. The local thresholds of the rows are compared with the
+// exact local thresholds of their scores, including the skipped rows, which are
+// only excluded from the count by Server.ExcludeRows, and a row is misclassified
+// if its error is at least 1/2. The aggregated local threshold is compared with
+// Oracle.Count, and its misclassified rows are its rounded error.
+// The global threshold is compared with Oracle.Decision, and is misclassified
+// if its error is at least 1/2.
+type PrecisionReport struct {
+	Lookup          StagePrecision `json:"lookup"`
+	Packing         StagePrecision `json:"packing"`
+	Merging         StagePrecision `json:"merging"`
+	SchemeSwitching StagePrecision `json:"scheme_switching"`
+	LocalThreshold  StagePrecision `json:"local_threshold"`
+	InnerSum        StagePrecision `json:"inner_sum"`
+	GlobalThreshold StagePrecision `json:"global_threshold"`
+}
+
+// Precision evaluates the request on the database as Server.ProcessRequest, on a single
+// goroutine and without checkpoints, decrypts the output of each stage with the secret
+// keys of s.SkDebug and compares it with the output of the oracle. The global threshold
+// is only evaluated if the request has a PrivateThreshold1.
+//
+// The lookup tables are evaluated twice, once for each row to measure their precision,
+// and once by Server.EncryptedLookupTablesAndRingPacking, and the local thresholds of
+// the two halves of each scheme-switched ciphertext are evaluated separately, so that
+// each row can be compared with the oracle. Precision is a debug tool and must only
+// be used with test data.
+func (s Server) Precision(ctx context.Context, cfg Config, r Request, db *Database, btp Bootstrapper, o Oracle) (report PrecisionReport, err error) {
+
+	if s, err = s.setup(cfg, r, btp); err != nil {
+		return report, fmt.Errorf("s.setup: %w", err)
+	}
+
+	if r.PrivateThreshold0 == nil {
+		return report, fmt.Errorf("missing the PrivateThreshold0")
+	}
+
+	if err = cfg.CheckLocalThreshold(r.PrivateThreshold0.Max); err != nil {
+		return report, fmt.Errorf("cfg.CheckLocalThreshold: %w", err)
+	}
+
+	if s.DecPack == nil || s.DecEval == nil {
+		return report, fmt.Errorf("the precision requires the secret keys of degree 2^%d and 2^%d in SkDebug", cfg.LogNPack, cfg.LogNEval)
+	}
+
+	var tvs TestVectors
+	if tvs, err = r.TestVectors.withPolicies(db.Schema); err != nil {
+		return report, fmt.Errorf("r.TestVectors.withPolicies: %w", err)
+	}
+
+	r.TestVectors = &tvs
+
+	// The skipped rows of the request only
+	s.Policies = &PolicyCounts{}
+
+	var want OracleResult
+	if want, err = o.Evaluate(db); err != nil {
+		return report, fmt.Errorf("o.Evaluate: %w", err)
+	}
+
+	// indicator returns the exact local threshold of the score of the i-th row,
+	// before the skipped rows are excluded from the count
+	indicator := func(i int) float64 {
+		return step(want.Scores[i] - o.Threshold0 + 0.5)
+	}
+
+	// misclassified returns 1 if the exact local threshold of the score differs from the oracle
+	misclassified := func(i int, score float64) int {
+		if step(score-o.Threshold0+0.5) != indicator(i) {
+			return 1
+		}
+		return 0
+	}
+
+	rows := db.Size()
+
+	paramsPack := s.ParamsPack
+	paramsEval := s.ParamsEval
+
+	NPack := paramsPack.N()
+	NEval := paramsEval.N()
+	ratio := NEval / NPack
+	logRatio := bits.Len64(uint64(ratio)) - 1
+	logSlots := paramsEval.LogN() - 1
+
+	t0 := r.PrivateThreshold0.Threshold
+	c := r.PrivateThreshold0.Normalization
+
+	buffPoly := paramsPack.RingQ().NewPoly()
+	buffCt := hefloat.NewCiphertext(paramsPack, 1, paramsPack.MaxLevel())
+
+	count := hefloat.NewCiphertext(paramsEval, 1, paramsEval.MaxLevel())
+
+	for i := 0; i < rows; i += NEval {
+
+		end := utils.Min(i+NEval, rows)
+
+		res := make([]*rlwe.Ciphertext, (end-i+NPack-1)/NPack)
+
+		for j := range res {
+
+			start := i + j*NPack
+			stop := utils.Min(start+NPack, end)
+
+			// ENCRYPTED LOOKUP-TABLES
+			for k := start; k < stop; k++ {
+
+				if err = ctx.Err(); err != nil {
+					return
+				}
+
+				if err = r.TestVectors.Evaluate(paramsPack, db.GetRow(k), buffPoly, buffCt); err != nil {
+					return report, fmt.Errorf("r.TestVectors.Evaluate: %w", err)
+				}
+
+				var have []float64
+				if have, err = s.decodeCoeffs(buffCt); err != nil {
+					return
+				}
+
+				score := have[0] * float64(Scaling)
+				report.Lookup.add(score, want.Scores[k], misclassified(k, score))
+			}
+
+			// RING-PACKING
+			if res[j], err = s.EncryptedLookupTablesAndRingPacking(ctx, db, r.TestVectors, start, stop, NPack); err != nil {
+				return report, fmt.Errorf("s.EncryptedLookupTablesAndRingPacking: %w", err)
+			}
+
+			var have []float64
+			if have, err = s.decodeCoeffs(res[j]); err != nil {
+				return
+			}
+
+			for k := start; k < stop; k++ {
+				score := have[k-start] * float64(Scaling)
+				report.Packing.add(score, want.Scores[k], misclassified(k, score))
+			}
+		}
+
+		// RING MERGING
+		var merged *rlwe.Ciphertext
+		if merged, err = s.RingMerging(ctx, res); err != nil {
+			return report, fmt.Errorf("s.RingMerging: %w", err)
+		}
+
+		var have []float64
+		if have, err = s.decodeCoeffs(merged); err != nil {
+			return
+		}
+
+		// The k-th row of the j-th packed ciphertext is the coefficient
+		// k*ratio + j' of the merged ciphertext, where j' is the bit-reversal
+		// of j, as the merging interleaves the coefficients of its inputs
+		coeff := make([]int, end-i)
+		for k := range coeff {
+			coeff[k] = (k%NPack)*ratio + int(utils.BitReverse64(k/NPack, logRatio))
+		}
+
+		for k := range coeff {
+			score := have[coeff[k]] * float64(Scaling)
+			report.Merging.add(score, want.Scores[i+k], misclassified(i+k, score))
+		}
+
+		// SCHEME-SWITCHING
+		var real, imag *rlwe.Ciphertext
+		if real, imag, err = s.SchemeSwitch(merged); err != nil {
+			return report, fmt.Errorf("s.SchemeSwitch: %w", err)
+		}
+
+		// The coefficient c of the merged ciphertext is the slot of index the bit-reversal
+		// of c mod N/2 of the first output for c < N/2, else of the second output
+		halves := []*rlwe.Ciphertext{real, imag}
+		slots := make([][]float64, len(halves))
+
+		for h := range halves {
+			if slots[h], err = s.decodeSlots(halves[h]); err != nil {
+				return
+			}
+		}
+
+		slot := func(c int) (h, k int) {
+			return c >> logSlots, int(utils.BitReverse64(c&(NEval/2-1), logSlots))
+		}
+
+		for k := range coeff {
+			h, j := slot(coeff[k])
+			report.SchemeSwitching.add(slots[h][j], want.Scores[i+k], misclassified(i+k, slots[h][j]))
+		}
+
+		// LOCAL-THRESHOLD
+		for h := range halves {
+
+			if err = ctx.Err(); err != nil {
+				return
+			}
+
+			indicators := hefloat.NewCiphertext(paramsEval, 1, paramsEval.MaxLevel())
+
+			if err = s.LocalThreshold(halves[h], t0, c, indicators); err != nil {
+				return report, fmt.Errorf("s.LocalThreshold: %w", err)
+			}
+
+			if slots[h], err = s.decodeSlots(indicators); err != nil {
+				return
+			}
+
+			if err = s.GetEvaluator().Add(count, indicators, count); err != nil {
+				return report, fmt.Errorf("eval.Add: %w", err)
+			}
+		}
+
+		for k := range coeff {
+
+			h, j := slot(coeff[k])
+
+			var wrong int
+			if math.Abs(slots[h][j]-indicator(i+k)) >= 0.5 {
+				wrong = 1
+			}
+
+			report.LocalThreshold.add(slots[h][j], indicator(i+k), wrong)
+		}
+	}
+
+	// AGGREGATION
+	if err = s.InnerSum(ctx, count); err != nil {
+		return report, fmt.Errorf("s.InnerSum: %w", err)
+	}
+
+	if err = s.ExcludeRows(ctx, count, t0, c, s.excludedRows(rows)); err != nil {
+		return report, fmt.Errorf("s.ExcludeRows: %w", err)
+	}
+
+	var v []float64
+	if v, err = s.decodeSlots(count); err != nil {
+		return
+	}
+
+	report.InnerSum.add(v[0], want.Count, int(math.Round(math.Abs(v[0]-want.Count))))
+
+	if r.PrivateThreshold1 == nil {
+		return
+	}
+
+	// GLOBAL THRESHOLD
+	if err = cfg.CheckGlobalThreshold(rows); err != nil {
+		return report, fmt.Errorf("cfg.CheckGlobalThreshold: %w", err)
+	}
+
+	var decision *rlwe.Ciphertext
+	if decision, err = s.GlobalThreshold(ctx, count, r.PrivateThreshold1.Threshold, rows); err != nil {
+		return report, fmt.Errorf("s.GlobalThreshold: %w", err)
+	}
+
+	if v, err = s.decodeSlots(decision); err != nil {
+		return
+	}
+
+	var wrong int
+	if math.Abs(v[0]-want.Decision) >= 0.5 {
+		wrong = 1
+	}
+
+	report.GlobalThreshold.add(v[0], want.Decision, wrong)
+
+	return
+}
+
+// decodeCoeffs decrypts the coefficients of a ciphertext of degree 2^{LogNPack}
+// or 2^{LogNEval} with the secret keys of s.SkDebug.
+func (s Server) decodeCoeffs(ct *rlwe.Ciphertext) (v []float64, err error) {
+
+	dec, ecd := s.DecEval, s.EcdEval
+	if ct.Value[0].N() == s.ParamsPack.N() {
+		dec, ecd = s.DecPack, s.EcdPack
+	}
+
+	pt := dec.DecryptNew(ct)
+	pt.IsBatched = false
+
+	v = make([]float64, ct.Value[0].N())
+	if err = ecd.Decode(pt, v); err != nil {
+		return nil, fmt.Errorf("ecd.Decode: %w", err)
+	}
+
+	return
+}
+
+// decodeSlots decrypts the real part of the slots of a ciphertext
+// of degree 2^{LogNEval} with the secret key of s.SkDebug.
+func (s Server) decodeSlots(ct *rlwe.Ciphertext) (v []float64, err error) {
+
+	values := make([]complex128, ct.Slots())
+	if err = s.EcdEval.Decode(s.DecEval.DecryptNew(ct), values); err != nil {
+		return nil, fmt.Errorf("ecd.Decode: %w", err)
+	}
+
+	v = make([]float64, len(values))
+	for i := range values {
+		v[i] = real(values[i])
+	}
+
+	return
+}
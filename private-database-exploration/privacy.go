@@ -0,0 +1,369 @@
+package pde
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// NoiseDistribution is the distribution of the noise added to the count
+// of patients in the differentially private output mode. The noise is sampled
+// with the exact integer samplers of Canonne, Kamath and Steinke, "The Discrete
+// Gaussian for Differential Privacy" (2020), which only draw uniform integers,
+// so that they do not leak through floating-point rounding (Mironov, 2012).
+type NoiseDistribution int
+
+const (
+	// DiscreteLaplace is the two-sided geometric distribution
+	// Pr[z] ~ exp(-epsilon*|z|), which is (epsilon, 0)-DP.
+	DiscreteLaplace = NoiseDistribution(iota)
+	// DiscreteGaussian is the distribution Pr[z] ~ exp(-z^2/(2*sigma^2)) on
+	// the integers with sigma = sqrt(2ln(1.25/delta))/epsilon, which is
+	// (epsilon, delta)-DP for epsilon < 1, as the continuous Gaussian.
+	DiscreteGaussian
+)
+
+func (n NoiseDistribution) String() string {
+	switch n {
+	case DiscreteLaplace:
+		return "DiscreteLaplace"
+	case DiscreteGaussian:
+		return "DiscreteGaussian"
+	default:
+		return fmt.Sprintf("NoiseDistribution(%d)", int(n))
+	}
+}
+
+// DifferentialPrivacy are the parameters of the noisy count output mode.
+// Each patient contributes at most one to the count, so the noise is
+// calibrated for a sensitivity of one.
+type DifferentialPrivacy struct {
+	Noise   NoiseDistribution
+	Epsilon float64
+	// Delta is ignored for the DiscreteLaplace distribution.
+	Delta float64
+}
+
+// Check returns an error if the parameters are not valid for the noise distribution.
+func (dp DifferentialPrivacy) Check() error {
+	switch dp.Noise {
+	case DiscreteLaplace:
+		if !(dp.Epsilon > 0) || math.IsInf(dp.Epsilon, 0) {
+			return fmt.Errorf("invalid epsilon=%v: must be positive", dp.Epsilon)
+		}
+	case DiscreteGaussian:
+		if !(dp.Epsilon > 0 && dp.Epsilon < 1) {
+			return fmt.Errorf("invalid epsilon=%v: must be in (0, 1) for the DiscreteGaussian distribution", dp.Epsilon)
+		}
+		if !(dp.Delta > 0 && dp.Delta < 1) {
+			return fmt.Errorf("invalid delta=%v: must be in (0, 1) for the DiscreteGaussian distribution", dp.Delta)
+		}
+	default:
+		return fmt.Errorf("invalid noise distribution: %v", dp.Noise)
+	}
+	return nil
+}
+
+// Cost returns the privacy budget consumed by one noisy count.
+func (dp DifferentialPrivacy) Cost() PrivacyBudget {
+	if dp.Noise == DiscreteLaplace {
+		return PrivacyBudget{Epsilon: dp.Epsilon}
+	}
+	return PrivacyBudget{Epsilon: dp.Epsilon, Delta: dp.Delta}
+}
+
+// StandardDeviation returns the standard deviation of the noise, or for the
+// DiscreteGaussian its parameter sigma, which is an upper bound.
+func (dp DifferentialPrivacy) StandardDeviation() float64 {
+	switch dp.Noise {
+	case DiscreteLaplace:
+		q := math.Exp(-dp.Epsilon)
+		return math.Sqrt(2*q) / (1 - q)
+	case DiscreteGaussian:
+		return math.Sqrt(dp.variance())
+	default:
+		return math.NaN()
+	}
+}
+
+// variance returns the parameter sigma^2 of the DiscreteGaussian.
+func (dp DifferentialPrivacy) variance() float64 {
+	return 2 * math.Log(1.25/dp.Delta) / (dp.Epsilon * dp.Epsilon)
+}
+
+// Sample returns a sample of the noise, using the bytes of r as randomness.
+// The parameters are converted exactly to rationals, and the sample is then
+// drawn with integer arithmetic only.
+func (dp DifferentialPrivacy) Sample(r io.Reader) (noise int64, err error) {
+
+	if err = dp.Check(); err != nil {
+		return 0, err
+	}
+
+	var z *big.Int
+
+	switch dp.Noise {
+	case DiscreteLaplace:
+		epsilon := new(big.Rat).SetFloat64(dp.Epsilon)
+		z, err = sampleDiscreteLaplace(r, epsilon.Num(), epsilon.Denom())
+	default:
+		z, err = sampleDiscreteGaussian(r, new(big.Rat).SetFloat64(dp.variance()))
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !z.IsInt64() {
+		return 0, fmt.Errorf("noise %v overflows an int64", z)
+	}
+
+	return z.Int64(), nil
+}
+
+// sampleBernoulli returns true with probability p in [0, 1].
+func sampleBernoulli(r io.Reader, p *big.Rat) (bool, error) {
+
+	u, err := rand.Int(r, p.Denom())
+	if err != nil {
+		return false, fmt.Errorf("rand.Int: %w", err)
+	}
+
+	return u.Cmp(p.Num()) < 0, nil
+}
+
+// sampleBernoulliExp returns true with probability exp(-gamma) for gamma >= 0,
+// see Algorithm 1 of Canonne, Kamath and Steinke.
+func sampleBernoulliExp(r io.Reader, gamma *big.Rat) (bool, error) {
+
+	one := big.NewRat(1, 1)
+
+	// exp(-gamma) = exp(-1)^floor(gamma) * exp(-(gamma - floor(gamma)))
+	g := new(big.Rat).Set(gamma)
+	for g.Cmp(one) > 0 {
+
+		b, err := sampleBernoulliExp(r, one)
+		if err != nil || !b {
+			return false, err
+		}
+
+		g.Sub(g, one)
+	}
+
+	// For gamma in [0, 1], K is the first index k such that Bernoulli(gamma/k)
+	// fails, and Pr[K odd] = exp(-gamma)
+	k := int64(1)
+	for {
+
+		a, err := sampleBernoulli(r, new(big.Rat).Quo(g, big.NewRat(k, 1)))
+		if err != nil {
+			return false, err
+		}
+
+		if !a {
+			return k%2 == 1, nil
+		}
+
+		k++
+	}
+}
+
+// sampleDiscreteLaplace returns a sample of Pr[z] ~ exp(-|z|*s/t) for s, t > 0,
+// see Algorithm 2 of Canonne, Kamath and Steinke.
+func sampleDiscreteLaplace(r io.Reader, s, t *big.Int) (z *big.Int, err error) {
+
+	one := big.NewRat(1, 1)
+	half := big.NewRat(1, 2)
+
+	for {
+
+		// U + t*V is geometric of parameter 1 - exp(-1/t)
+		var u *big.Int
+		if u, err = rand.Int(r, t); err != nil {
+			return nil, fmt.Errorf("rand.Int: %w", err)
+		}
+
+		var d bool
+		if d, err = sampleBernoulliExp(r, new(big.Rat).SetFrac(u, t)); err != nil {
+			return nil, err
+		}
+
+		if !d {
+			continue
+		}
+
+		v := new(big.Int)
+		for {
+
+			var a bool
+			if a, err = sampleBernoulliExp(r, one); err != nil {
+				return nil, err
+			}
+
+			if !a {
+				break
+			}
+
+			v.Add(v, big.NewInt(1))
+		}
+
+		z = new(big.Int).Mul(t, v)
+		z.Add(z, u)
+		z.Quo(z, s)
+
+		var negative bool
+		if negative, err = sampleBernoulli(r, half); err != nil {
+			return nil, err
+		}
+
+		// Rejects -0, which would double the probability of 0
+		if negative && z.Sign() == 0 {
+			continue
+		}
+
+		if negative {
+			z.Neg(z)
+		}
+
+		return
+	}
+}
+
+// sampleDiscreteGaussian returns a sample of Pr[z] ~ exp(-z^2/(2*sigma2)) for sigma2 > 0,
+// see Algorithm 3 of Canonne, Kamath and Steinke.
+func sampleDiscreteGaussian(r io.Reader, sigma2 *big.Rat) (z *big.Int, err error) {
+
+	// t = floor(sigma) + 1
+	t := new(big.Int).Quo(sigma2.Num(), sigma2.Denom())
+	t.Sqrt(t)
+	t.Add(t, big.NewInt(1))
+
+	// sigma^2/t and 2*sigma^2
+	shift := new(big.Rat).Quo(sigma2, new(big.Rat).SetInt(t))
+	scale := new(big.Rat).Mul(sigma2, big.NewRat(2, 1))
+
+	for {
+
+		if z, err = sampleDiscreteLaplace(r, big.NewInt(1), t); err != nil {
+			return nil, err
+		}
+
+		// Accepts with probability exp(-(|z| - sigma^2/t)^2/(2*sigma^2))
+		gamma := new(big.Rat).SetInt(new(big.Int).Abs(z))
+		gamma.Sub(gamma, shift)
+		gamma.Mul(gamma, gamma)
+		gamma.Quo(gamma, scale)
+
+		var c bool
+		if c, err = sampleBernoulliExp(r, gamma); err != nil {
+			return nil, err
+		}
+
+		if c {
+			return
+		}
+	}
+}
+
+// PrivacyBudget is an amount of (epsilon, delta) differential privacy.
+type PrivacyBudget struct {
+	Epsilon float64
+	Delta   float64
+}
+
+// PrivacyAccountant keeps track of the privacy budget spent by the noisy
+// counts on the database of each hospital, under sequential composition:
+// the costs of the queries add up and must not exceed the budget.
+// It is safe for concurrent use.
+type PrivacyAccountant struct {
+	Budget PrivacyBudget
+	// MaxCost is the largest cost of a single noisy count, i.e. the minimum privacy
+	// of each answer set by the hospitals, whatever the parameters of the request.
+	MaxCost PrivacyBudget
+	mu      sync.Mutex
+	spent   map[string]PrivacyBudget
+}
+
+// NewPrivacyAccountant returns an accountant granting the given budget to each
+// hospital, of which a single noisy count can spend at most maxCost.
+func NewPrivacyAccountant(budget, maxCost PrivacyBudget) *PrivacyAccountant {
+	return &PrivacyAccountant{
+		Budget:  budget,
+		MaxCost: maxCost,
+		spent:   map[string]PrivacyBudget{},
+	}
+}
+
+// Check returns an error if the parameters of a request are invalid or cost more than a.MaxCost.
+func (a *PrivacyAccountant) Check(dp DifferentialPrivacy) (err error) {
+
+	if err = dp.Check(); err != nil {
+		return
+	}
+
+	if cost := dp.Cost(); cost.Epsilon > a.MaxCost.Epsilon || cost.Delta > a.MaxCost.Delta {
+		return fmt.Errorf("invalid privacy: the cost %+v of the noisy count exceeds the maximum %+v of the hospitals", cost, a.MaxCost)
+	}
+
+	return
+}
+
+// Spend charges the cost to each of the hospitals. If the budget of
+// any of them would be exceeded, nothing is charged and an error is
+// returned. A hospital listed several times is charged once.
+func (a *PrivacyAccountant) Spend(hospitals []string, cost PrivacyBudget) (err error) {
+
+	if cost.Epsilon < 0 || cost.Delta < 0 {
+		return fmt.Errorf("invalid cost: %+v", cost)
+	}
+
+	set := map[string]bool{}
+	for _, h := range hospitals {
+		set[h] = true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ids := make([]string, 0, len(set))
+	for h := range set {
+		ids = append(ids, h)
+	}
+
+	sort.Strings(ids)
+
+	for _, h := range ids {
+		// Tolerates the rounding errors of the sum of the costs
+		if s := a.spent[h]; s.Epsilon+cost.Epsilon > a.Budget.Epsilon+1e-9 || s.Delta+cost.Delta > a.Budget.Delta+1e-12 {
+			return fmt.Errorf("privacy budget of hospital %q exceeded: spent %+v, cost %+v, budget %+v", h, s, cost, a.Budget)
+		}
+	}
+
+	if a.spent == nil {
+		a.spent = map[string]PrivacyBudget{}
+	}
+
+	for _, h := range ids {
+		s := a.spent[h]
+		a.spent[h] = PrivacyBudget{Epsilon: s.Epsilon + cost.Epsilon, Delta: s.Delta + cost.Delta}
+	}
+
+	return
+}
+
+// Spent returns the budget spent on the database of the hospital.
+func (a *PrivacyAccountant) Spent(hospital string) PrivacyBudget {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.spent[hospital]
+}
+
+// Remaining returns the budget left for the database of the hospital.
+func (a *PrivacyAccountant) Remaining(hospital string) PrivacyBudget {
+	s := a.Spent(hospital)
+	return PrivacyBudget{Epsilon: a.Budget.Epsilon - s.Epsilon, Delta: a.Budget.Delta - s.Delta}
+}
@@ -0,0 +1,124 @@
+package pde
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
+	"github.com/tuneinsight/lattigo/v5/utils/sampling"
+)
+
+func TestPrivacy(t *testing.T) {
+
+	prng, err := sampling.NewKeyedPRNG([]byte("pde privacy test"))
+	require.NoError(t, err)
+
+	for _, dp := range []DifferentialPrivacy{
+		{Noise: DiscreteLaplace, Epsilon: 0.5},
+		{Noise: DiscreteGaussian, Epsilon: 0.5, Delta: 1e-6},
+	} {
+		t.Run(dp.Noise.String(), func(t *testing.T) {
+
+			require.NoError(t, dp.Check())
+
+			n := 1 << 16
+
+			var mean, variance float64
+			for i := 0; i < n; i++ {
+				z, err := dp.Sample(prng)
+				require.NoError(t, err)
+
+				mean += float64(z)
+				variance += float64(z * z)
+			}
+
+			mean /= float64(n)
+			variance = variance/float64(n) - mean*mean
+
+			sigma := dp.StandardDeviation()
+
+			require.InDelta(t, 0, mean, 5*sigma/math.Sqrt(float64(n)))
+			require.InDelta(t, sigma, math.Sqrt(variance), 0.05*sigma)
+		})
+	}
+
+	t.Run("Check", func(t *testing.T) {
+		for _, dp := range []DifferentialPrivacy{
+			{Noise: DiscreteLaplace, Epsilon: 0},
+			{Noise: DiscreteLaplace, Epsilon: math.Inf(1)},
+			{Noise: DiscreteGaussian, Epsilon: 1, Delta: 1e-6},
+			{Noise: DiscreteGaussian, Epsilon: 0.5, Delta: 0},
+			{Noise: NoiseDistribution(2), Epsilon: 0.5},
+		} {
+			require.Error(t, dp.Check(), "%+v", dp)
+		}
+	})
+
+	t.Run("Accountant", func(t *testing.T) {
+
+		a := NewPrivacyAccountant(PrivacyBudget{Epsilon: 1, Delta: 1e-5}, PrivacyBudget{Epsilon: 0.5, Delta: 1e-5})
+
+		laplace := DifferentialPrivacy{Noise: DiscreteLaplace, Epsilon: 0.1}
+		gaussian := DifferentialPrivacy{Noise: DiscreteGaussian, Epsilon: 0.5, Delta: 1e-5}
+
+		require.NoError(t, a.Check(laplace))
+		require.NoError(t, a.Check(gaussian))
+
+		// The hospitals set the minimum privacy of each answer
+		require.Error(t, a.Check(DifferentialPrivacy{Noise: DiscreteLaplace, Epsilon: 0.75}))
+		require.Error(t, a.Check(DifferentialPrivacy{Noise: DiscreteGaussian, Epsilon: 0.5, Delta: 1e-4}))
+		require.Error(t, a.Check(DifferentialPrivacy{Noise: DiscreteLaplace}))
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, a.Spend([]string{"A", "B", "A"}, laplace.Cost()))
+		}
+
+		require.InDelta(t, 0.5, a.Spent("A").Epsilon, 1e-12)
+
+		// B would exceed its budget: nothing is charged
+		require.NoError(t, a.Spend([]string{"B"}, gaussian.Cost()))
+		require.Error(t, a.Spend([]string{"C", "B"}, laplace.Cost()))
+		require.Equal(t, PrivacyBudget{}, a.Spent("C"))
+
+		// Up to the rounding errors of the sum of the costs
+		for i := 0; i < 5; i++ {
+			require.NoError(t, a.Spend([]string{"A"}, laplace.Cost()))
+		}
+
+		require.Error(t, a.Spend([]string{"A"}, laplace.Cost()))
+		require.InDelta(t, 0, a.Remaining("A").Epsilon, 1e-9)
+	})
+
+	t.Run("AddNoise", func(t *testing.T) {
+
+		fx := newFunctionFixture(t)
+		params, ecd, enc, dec := fx.params, fx.ecd, fx.enc, fx.dec
+
+		s := Server{
+			Bootstrapper: BootstrappingEvaluator{Evaluator: bootstrapping.Evaluator{Evaluator: hefloat.NewEvaluator(params, nil)}},
+		}
+
+		count := 1234.0
+
+		pt := hefloat.NewPlaintext(params, params.MaxLevel())
+		require.NoError(t, ecd.Encode([]float64{count}, pt))
+
+		ct, err := enc.EncryptNew(pt)
+		require.NoError(t, err)
+
+		dp := DifferentialPrivacy{Noise: DiscreteLaplace, Epsilon: 0.1}
+		require.NoError(t, s.AddNoise(ct, dp))
+
+		v := make([]float64, params.MaxSlots())
+		require.NoError(t, ecd.Decode(dec.DecryptNew(ct), v))
+
+		// The noise is an integer and the same in all the slots
+		noise := math.Round(v[0] - count)
+		require.InDelta(t, count+noise, v[0], 1e-3)
+		require.InDelta(t, noise, math.Round(v[1]), 1e-3)
+
+		require.Error(t, s.AddNoise(ct, DifferentialPrivacy{Noise: DiscreteGaussian, Epsilon: 2}))
+	})
+}
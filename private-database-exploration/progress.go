@@ -0,0 +1,129 @@
+package pde
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memorySampleInterval is the minimum interval between two samples of the memory
+// reported in the progress events, since runtime.ReadMemStats stops the world.
+const memorySampleInterval = time.Second
+
+// Stage is a stage of the evaluation of a request.
+type Stage string
+
+const (
+	// StageLookupPacking counts the ciphertexts of 2^{LogNPack} rows
+	// whose lookup tables are evaluated and packed.
+	StageLookupPacking = Stage("lookup-packing")
+
+	// StageMerging counts the ciphertexts of 2^{LogNEval} rows merged.
+	StageMerging = Stage("merging")
+
+	// StageSchemeSwitching counts the merged ciphertexts scheme-switched
+	// and added to the local threshold.
+	StageSchemeSwitching = Stage("scheme-switching")
+
+	// StageInnerSum is the sum of the local thresholds of the rows.
+	StageInnerSum = Stage("inner-sum")
+
+	// StageAggregation is the aggregation of the partial counts,
+	// followed by the global threshold or the noise of a noisy count.
+	StageAggregation = Stage("aggregation")
+)
+
+// Progress is a progress event of the evaluation of a request:
+// the number of items of the stage done out of their total.
+type Progress struct {
+	Stage Stage `json:"stage"`
+	Done  int   `json:"done"`
+	Total int   `json:"total"`
+
+	// Elapsed is the time since the start of Server.ProcessPartialRequest
+	// or of Server.Aggregate.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// Memory is the number of bytes of allocated heap objects, see
+	// runtime.MemStats.HeapAlloc, sampled at most once per second.
+	Memory uint64 `json:"memory"`
+}
+
+// Observer is an interface receiving the progress events of a Server.
+// The events of the workers of a request are received one at a time.
+type Observer interface {
+	Observe(p Progress)
+}
+
+// ObserverFunc is a function implementing the Observer interface.
+type ObserverFunc func(p Progress)
+
+// Observe calls f(p).
+func (f ObserverFunc) Observe(p Progress) {
+	f(p)
+}
+
+// progress counts the items done of each stage of a request
+// and reports them to an Observer.
+type progress struct {
+	observer Observer
+	start    time.Time
+
+	mu    sync.Mutex
+	done  map[Stage]int
+	total map[Stage]int
+
+	// sampled is the time in nanoseconds of the last sample of memory.
+	sampled atomic.Int64
+	memory  atomic.Uint64
+}
+
+func newProgress(observer Observer, total map[Stage]int) *progress {
+	return &progress{
+		observer: observer,
+		start:    time.Now(),
+		done:     map[Stage]int{},
+		total:    total,
+	}
+}
+
+// step marks one item of the stage as done.
+func (p *progress) step(stage Stage) {
+
+	if p == nil || p.observer == nil {
+		return
+	}
+
+	// Sampled outside of the lock, so that the other workers are not blocked
+	memory := p.sampleMemory()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done[stage]++
+
+	p.observer.Observe(Progress{
+		Stage:   stage,
+		Done:    p.done[stage],
+		Total:   p.total[stage],
+		Elapsed: time.Since(p.start),
+		Memory:  memory,
+	})
+}
+
+// sampleMemory returns the last sample of the memory, which is sampled
+// again if it is older than memorySampleInterval.
+func (p *progress) sampleMemory() uint64 {
+
+	now := time.Now().UnixNano()
+	last := p.sampled.Load()
+
+	if (last == 0 || now-last >= int64(memorySampleInterval)) && p.sampled.CompareAndSwap(last, now) {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		p.memory.Store(m.HeapAlloc)
+	}
+
+	return p.memory.Load()
+}
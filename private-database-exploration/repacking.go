@@ -37,8 +37,26 @@ func NewRepackEvaluator(evk *RepackEvaluationKeySet) *RepackEvaluator {
 	}
 }
 
+// ShallowCopy creates a shallow copy of the evaluator in which the keys and the
+// precomputed monomials are shared with the receiver. The returned evaluator can
+// be used concurrently with the receiver.
+func (eval RepackEvaluator) ShallowCopy() *RepackEvaluator {
+
+	Evaluators := map[int]*rlwe.Evaluator{}
+
+	for LogN, e := range eval.Evaluators {
+		Evaluators[LogN] = e.ShallowCopy()
+	}
+
+	return &RepackEvaluator{
+		RepackEvaluationKeySet: eval.RepackEvaluationKeySet,
+		Evaluators:             Evaluators,
+		XPow2NTT:               eval.XPow2NTT,
+	}
+}
+
 func (eval RepackEvaluator) Pack(cts map[int]*rlwe.Ciphertext) (ct *rlwe.Ciphertext, err error) {
-	return eval.Evaluators[LogNPack].Pack(cts, LogNPack, true)
+	return eval.Evaluators[eval.MinLogN()].Pack(cts, eval.MinLogN(), true)
 }
 
 // Merge merges two ciphertexts of degree N/2 into a ciphertext of degre N:
@@ -23,7 +23,10 @@ func (rpk RepackEvaluationKeySet) MaxLogN() (maxLogN int) {
 	return utils.GetSortedKeys(rpk.Parameters)[len(rpk.Parameters)-1]
 }
 
-func (rpk *RepackEvaluationKeySet) GenRingSwitchingKeys(p hefloat.Parameters, sk *rlwe.SecretKey, minLogN int, evkParams rlwe.EvaluationKeyParameters) (ski map[int]*rlwe.SecretKey, err error) {
+// NewRingSwitchingParameters returns the parameters of the ring degrees 2^{minLogN}, ..., 2^{p.LogN()},
+// indexed by their LogN. The parameters of the smaller ring degrees have the moduli of the
+// evaluation keys, see evkParams.
+func NewRingSwitchingParameters(p hefloat.Parameters, minLogN int, evkParams rlwe.EvaluationKeyParameters) (Parameters map[int]*hefloat.Parameters, err error) {
 
 	if minLogN >= p.LogN() {
 		return nil, fmt.Errorf("invalid minLogN: cannot be equal or larger than params.LogN()")
@@ -34,15 +37,9 @@ func (rpk *RepackEvaluationKeySet) GenRingSwitchingKeys(p hefloat.Parameters, sk
 	Q := p.Q()
 	P := p.P()
 
-	Parameters := map[int]*hefloat.Parameters{}
+	Parameters = map[int]*hefloat.Parameters{}
 	Parameters[p.LogN()] = &p
 
-	ski = map[int]*rlwe.SecretKey{}
-	ski[p.LogN()] = sk
-
-	kgen := map[int]*rlwe.KeyGenerator{}
-	kgen[p.LogN()] = rlwe.NewKeyGenerator(p)
-
 	for i := minLogN; i < p.LogN(); i++ {
 
 		var pi hefloat.Parameters
@@ -50,16 +47,35 @@ func (rpk *RepackEvaluationKeySet) GenRingSwitchingKeys(p hefloat.Parameters, sk
 			LogN:            i,
 			Q:               Q[:LevelQ+1],
 			P:               P[:LevelP+1],
-			LogDefaultScale: LogScale,
+			LogDefaultScale: p.LogDefaultScale(),
 		}); err != nil {
 			return nil, fmt.Errorf("rlwe.NewParametersFromLiteral: %w", err)
 		}
 
-		kgen[i] = rlwe.NewKeyGenerator(pi)
-		ski[i] = kgen[i].GenSecretKeyNew()
 		Parameters[i] = &pi
 	}
 
+	return
+}
+
+func (rpk *RepackEvaluationKeySet) GenRingSwitchingKeys(p hefloat.Parameters, sk *rlwe.SecretKey, minLogN int, evkParams rlwe.EvaluationKeyParameters) (ski map[int]*rlwe.SecretKey, err error) {
+
+	var Parameters map[int]*hefloat.Parameters
+	if Parameters, err = NewRingSwitchingParameters(p, minLogN, evkParams); err != nil {
+		return nil, err
+	}
+
+	ski = map[int]*rlwe.SecretKey{}
+	ski[p.LogN()] = sk
+
+	kgen := map[int]*rlwe.KeyGenerator{}
+	kgen[p.LogN()] = rlwe.NewKeyGenerator(p)
+
+	for i := minLogN; i < p.LogN(); i++ {
+		kgen[i] = rlwe.NewKeyGenerator(Parameters[i])
+		ski[i] = kgen[i].GenSecretKeyNew()
+	}
+
 	// Ring switching evaluation keys
 	RingSwitchingKeys := map[int]map[int]*rlwe.EvaluationKey{}
 
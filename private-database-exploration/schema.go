@@ -0,0 +1,411 @@
+package pde
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ColumnType is the type of the values of a column.
+type ColumnType int
+
+const (
+	// Numeric columns store real values in Interval.
+	Numeric ColumnType = iota
+	// Categorical columns store the index of a category in Categories.
+	Categorical
+)
+
+// String returns the name of the column type.
+func (t ColumnType) String() string {
+	switch t {
+	case Numeric:
+		return "numeric"
+	case Categorical:
+		return "categorical"
+	default:
+		return fmt.Sprintf("ColumnType(%d)", int(t))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t ColumnType) MarshalText() ([]byte, error) {
+	switch t {
+	case Numeric, Categorical:
+		return []byte(t.String()), nil
+	default:
+		return nil, fmt.Errorf("invalid column type: %d", int(t))
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *ColumnType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "numeric":
+		*t = Numeric
+	case "categorical":
+		*t = Categorical
+	default:
+		return fmt.Errorf("invalid column type: %q", text)
+	}
+	return nil
+}
+
+// Column is the public description of an attribute of the patients.
+type Column struct {
+	Name string     `json:"name"`
+	Type ColumnType `json:"type"`
+	Unit string     `json:"unit,omitempty"`
+
+	// Interval is the public range [min, max] of the values of a numeric column.
+	Interval [2]float64 `json:"interval"`
+
+	// Categories are the labels of a categorical column, whose
+	// values are the indexes of the labels, i.e. in [0, len(Categories)).
+	Categories []string `json:"categories,omitempty"`
+
+	// Points is the number of points of the public grid of a numeric
+	// column, see Column.Grid, or DefaultGridPoints if zero.
+	Points int `json:"points,omitempty"`
+
+	// Policy is the handling of the missing and out of grid values of the
+	// column, PolicyError if PolicyDefault, see ValuePolicy.
+	Policy ValuePolicy `json:"policy,omitempty"`
+}
+
+// DefaultGridPoints is the default number of points of the public grid of a numeric column.
+const DefaultGridPoints = 256
+
+// Schema is the public description of the columns of a Database.
+type Schema []Column
+
+// ReadSchema reads a JSON encoded schema.
+func ReadSchema(r io.Reader) (s Schema, err error) {
+
+	if err = json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, fmt.Errorf("json.Decode: %w", err)
+	}
+
+	if err = s.Check(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Check checks that the schema is well formed.
+func (s Schema) Check() (err error) {
+
+	if len(s) == 0 {
+		return fmt.Errorf("invalid schema: no column")
+	}
+
+	names := map[string]bool{}
+
+	for i, c := range s {
+
+		if c.Name == "" {
+			return fmt.Errorf("invalid schema: column %d has no name", i)
+		}
+
+		if names[c.Name] {
+			return fmt.Errorf("invalid schema: duplicate column %q", c.Name)
+		}
+
+		names[c.Name] = true
+
+		switch c.Type {
+		case Numeric:
+			if !(c.Interval[0] < c.Interval[1]) {
+				return fmt.Errorf("invalid schema: column %q has an invalid interval [%f, %f]", c.Name, c.Interval[0], c.Interval[1])
+			}
+			if c.Points < 0 || c.Points != 0 && c.Points <= 2 {
+				return fmt.Errorf("invalid schema: column %q has an invalid #points=%d: must be greater than 2", c.Name, c.Points)
+			}
+		case Categorical:
+			if len(c.Categories) == 0 {
+				return fmt.Errorf("invalid schema: column %q has no category", c.Name)
+			}
+			if c.Points != 0 {
+				return fmt.Errorf("invalid schema: categorical column %q has #points=%d", c.Name, c.Points)
+			}
+		default:
+			return fmt.Errorf("invalid schema: column %q has an invalid type %d", c.Name, int(c.Type))
+		}
+
+		if c.Policy < PolicyDefault || c.Policy > PolicySkip {
+			return fmt.Errorf("invalid schema: column %q has an invalid policy %d", c.Name, int(c.Policy))
+		}
+	}
+
+	return
+}
+
+// Index returns the index of the column with the given name, or -1.
+func (s Schema) Index(name string) int {
+	for i := range s {
+		if s[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Range returns the range [min, max] of the values of the column.
+func (c Column) Range() [2]float64 {
+	if c.Type == Categorical {
+		return [2]float64{0, float64(len(c.Categories) - 1)}
+	}
+	return c.Interval
+}
+
+// Grid returns the public grid of the column: the category indexes of a categorical
+// column, else Points-2 steps over the range of the column extended by two steps, as
+// the last step cannot be evaluated, see TestVectors.Evaluate. A function tabulated on
+// the public grid, see Column.Func, has no plaintext metadata beyond the schema.
+func (c Column) Grid() Axis {
+
+	if c.Type == Categorical {
+		return Axis{Points: len(c.Categories), Categorical: true}
+	}
+
+	points := c.Points
+	if points == 0 {
+		points = DefaultGridPoints
+	}
+
+	return numericGrid(c.Interval, points)
+}
+
+// numericGrid returns the grid of points-2 steps over the interval extended by two steps.
+func numericGrid(interval [2]float64, points int) Axis {
+	a, b := interval[0], interval[1]
+	step := (b - a) / float64(points-2)
+	return Axis{Interval: [2]float64{a, a + float64(points)*step}, Points: points}
+}
+
+// Func returns the function f of the values of the column, tabulated on the
+// public grid of the column, with maximum max and the policy of the column.
+func (c Column) Func(f func(x float64) (y float64), max float64) Func {
+	grid := c.Grid()
+	return Func{
+		F:           f,
+		Interval:    grid.Interval,
+		Points:      grid.Points,
+		Max:         max,
+		Categorical: grid.Categorical,
+		Policy:      c.Policy,
+	}
+}
+
+// Parse parses a CSV field of the column: a real number for numeric columns,
+// and a label or the index of a label for categorical columns.
+func (c Column) Parse(field string) (value float64, err error) {
+
+	if value, err = c.parse(field); err != nil {
+		return
+	}
+
+	return value, c.Validate(value)
+}
+
+// Load parses a CSV field of the column as Parse, but keeps the invalid values handled
+// by the policy of the column, see Column.Handles, where the fields that cannot be
+// parsed are missing values, i.e. NaN.
+func (c Column) Load(field string) (value float64, err error) {
+
+	if value, err = c.parse(field); err != nil {
+		value = math.NaN()
+	}
+
+	if verr := c.Validate(value); verr != nil {
+
+		if c.Handles(value) {
+			return value, nil
+		}
+
+		if err == nil {
+			err = verr
+		}
+
+		return 0, err
+	}
+
+	return
+}
+
+// Handles returns true if the policy of the column handles the invalid value:
+// PolicyMissing and PolicySkip handle all values, and PolicyClamp the values
+// which are not missing.
+func (c Column) Handles(value float64) bool {
+	switch c.Policy {
+	case PolicyMissing, PolicySkip:
+		return true
+	case PolicyClamp:
+		return !math.IsNaN(value)
+	default:
+		return false
+	}
+}
+
+// parse parses a CSV field of the column without validating its value.
+func (c Column) parse(field string) (value float64, err error) {
+
+	field = strings.TrimSpace(field)
+
+	if c.Type == Categorical {
+
+		for i := range c.Categories {
+			if c.Categories[i] == field {
+				return float64(i), nil
+			}
+		}
+
+		var idx int
+		if idx, err = strconv.Atoi(field); err != nil {
+			return 0, fmt.Errorf("unknown category %q", field)
+		}
+
+		value = float64(idx)
+
+	} else if value, err = strconv.ParseFloat(field, 64); err != nil {
+		return 0, fmt.Errorf("strconv.ParseFloat: %w", err)
+	}
+
+	return
+}
+
+// Validate checks that the value is in the range of the column.
+func (c Column) Validate(value float64) (err error) {
+
+	if math.IsNaN(value) {
+		return fmt.Errorf("value is NaN")
+	}
+
+	r := c.Range()
+
+	if value < r[0] || value > r[1] {
+		return fmt.Errorf("value %v not in [%v, %v]", value, r[0], r[1])
+	}
+
+	if c.Type == Categorical && value != math.Trunc(value) {
+		return fmt.Errorf("value %v is not a category index", value)
+	}
+
+	return
+}
+
+// CheckFunc checks that f can be evaluated on all values of the column, i.e. that
+// the range of the column is inside the interval of f, excluding its last step,
+// or, for categorical functions, that f is defined on all the categories of the
+// column, see TestVectors.Evaluate.
+func (c Column) CheckFunc(f Func) (err error) {
+
+	r := c.Range()
+
+	// Categorical functions are defined on [0, f.Points)
+	if f.Categorical {
+
+		if c.Type != Categorical {
+			return fmt.Errorf("column %q: categorical function on a %s column", c.Name, c.Type)
+		}
+
+		if f.Points < len(c.Categories) {
+			return fmt.Errorf("column %q: categorical function defined on %d categories but the column has %d", c.Name, f.Points, len(c.Categories))
+		}
+
+		return
+	}
+
+	a, b := f.Interval[0], f.Interval[1]
+
+	if f.Points <= 0 || !(a < b) {
+		return fmt.Errorf("column %q: invalid function interval [%v, %v] or #points %d", c.Name, a, b, f.Points)
+	}
+
+	if r[0] < a || normalize(r[1], a, b)+1/float64(f.Points) >= 1 {
+		return fmt.Errorf("column %q: range [%v, %v] not inside the function interval [%v, %v)", c.Name, r[0], r[1], a, b-(b-a)/float64(f.Points))
+	}
+
+	return
+}
+
+// CheckFuncs checks that each function can be evaluated on all values of the columns
+// it consumes, see Func.Columns: funcs[i] on the i-th column by default, and each
+// axis of a multivariate function on its column.
+func (s Schema) CheckFuncs(funcs []Func) (err error) {
+
+	for i, f := range funcs {
+
+		columns := consumedColumns(f.Columns, i)
+
+		for _, c := range columns {
+			if c < 0 || c >= len(s) {
+				return fmt.Errorf("function %d: column %d not in [0, %d)", i, c, len(s))
+			}
+		}
+
+		if len(f.Axes) == 0 {
+
+			if len(columns) != 1 {
+				return fmt.Errorf("function %d: univariate function on %d columns", i, len(columns))
+			}
+
+			if err = s[columns[0]].CheckFunc(f); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		if len(columns) != len(f.Axes) {
+			return fmt.Errorf("function %d: multivariate function on %d axes but %d columns", i, len(f.Axes), len(columns))
+		}
+
+		for k, a := range f.Axes {
+			if err = s[columns[k]].CheckFunc(Func{Interval: a.Interval, Points: a.Points, Categorical: a.Categorical}); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// CheckTestVectors checks that the test vectors carry no plaintext metadata beyond the
+// schema: the i-th test vector must consume the i-th column on its public grid, see
+// Column.Grid, with the policy of the column, and be neither interpolated nor
+// multivariate, so that its function, e.g.
+// the bounds of a selection interval, is only encoded in the encrypted coefficients.
+func (s Schema) CheckTestVectors(tvs TestVectors) (err error) {
+
+	if len(tvs) != len(s) {
+		return fmt.Errorf("#TestVectors=%d but #columns=%d", len(tvs), len(s))
+	}
+
+	for i, tv := range tvs {
+
+		if len(tv.Columns) > 1 || len(tv.Columns) == 1 && tv.Columns[0] != i {
+			return fmt.Errorf("TestVectors[%d] declares the columns %v", i, tv.Columns)
+		}
+
+		if len(tv.Axes) != 0 || len(tv.Slope) != 0 {
+			return fmt.Errorf("TestVectors[%d] is multivariate or interpolated", i)
+		}
+
+		if policy, _ := s.policy([]int{i}); tv.Policy != PolicyDefault && tv.Policy != policy {
+			return fmt.Errorf("column %q: TestVectors[%d] has the policy %s but the schema %s", s[i].Name, i, tv.Policy, policy)
+		}
+
+		grid := s[i].Grid()
+
+		if tv.Interval != grid.Interval || tv.Points != grid.Points || tv.Categorical != grid.Categorical {
+			return fmt.Errorf("column %q: TestVectors[%d] is not on the public grid of the column", s[i].Name, i)
+		}
+	}
+
+	return
+}
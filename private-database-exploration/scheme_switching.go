@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/utils"
 )
 
 // SchemeSwitch takes Enc(m(X)) at modulus Q[0] and returns Enc(Encode(m(X))) at modulus Q[L].
@@ -54,3 +56,50 @@ func (eval BootstrappingEvaluator) SchemeSwitch(input *rlwe.Ciphertext) (output0
 
 	return
 }
+
+// SchemeSwitch emulates in plaintext the scheme-switching of the BootstrappingEvaluator:
+// it decrypts Enc(m(X)) and returns Enc(Encode(m(X))) at the output level, split in
+// the first and last N/2 coefficients of m(X), with the same scaling and the same
+// bit-reversed order of the slots.
+func (btp SecretKeyBootstrapper) SchemeSwitch(input *rlwe.Ciphertext) (output0, output1 *rlwe.Ciphertext, err error) {
+
+	if err = btp.check(input); err != nil {
+		return nil, nil, err
+	}
+
+	params := btp.Parameters
+
+	pt := btp.Decryptor.DecryptNew(input)
+	pt.IsBatched = false
+
+	coeffs := make([]float64, params.N())
+	if err = btp.Encoder.Decode(pt, coeffs); err != nil {
+		return nil, nil, fmt.Errorf("btp.Encoder.Decode: %w", err)
+	}
+
+	for i := range coeffs {
+		coeffs[i] *= float64(Scaling)
+	}
+
+	slots := params.N() >> 1
+
+	outputs := make([]*rlwe.Ciphertext, 2)
+
+	for i := range outputs {
+
+		pt := hefloat.NewPlaintext(params, btp.OutputLevel())
+
+		values := coeffs[i*slots : (i+1)*slots]
+		utils.BitReverseInPlaceSlice(values, slots)
+
+		if err = btp.Encoder.Encode(values, pt); err != nil {
+			return nil, nil, fmt.Errorf("btp.Encoder.Encode: %w", err)
+		}
+
+		if outputs[i], err = btp.Encryptor.EncryptNew(pt); err != nil {
+			return nil, nil, fmt.Errorf("btp.Encryptor.EncryptNew: %w", err)
+		}
+	}
+
+	return outputs[0], outputs[1], nil
+}
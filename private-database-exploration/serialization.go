@@ -0,0 +1,1736 @@
+package pde
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/mhe"
+	"github.com/tuneinsight/lattigo/v5/utils"
+	"github.com/tuneinsight/lattigo/v5/utils/buffer"
+)
+
+// SerializationVersion is the version of the binary format of the
+// objects exchanged between the client, the hospitals and the aggregator.
+//
+// Each exchanged object (Request, EvaluationKeys, TestVectors, PrivateThreshold,
+// PartialCount, Query, the response and the shares of the multiparty keys and of
+// the threshold decryption) starts with a header of 5 bytes: the magic
+// "PDE", the version and the kind of the object. The header is checked when
+// reading, so that an object of an other version or kind is rejected.
+//
+// The objects are written and read with the WriteTo and ReadFrom methods, which
+// stream the keys without a copy of the whole object in memory. As in lattigo,
+// the io.Writer (resp. io.Reader) is wrapped in a bufio.Writer (resp. bufio.Reader)
+// unless it implements buffer.Writer (resp. buffer.Reader). Since a bufio.Reader
+// can read ahead, several objects read from the same io.Reader must be read from
+// the same buffer.Reader.
+const SerializationVersion = 5
+
+var serializationMagic = [3]byte{'P', 'D', 'E'}
+
+const headerSize = 5
+
+type objectKind uint8
+
+const (
+	kindRequest = objectKind(iota + 1)
+	kindEvaluationKeys
+	kindTestVectors
+	kindPrivateThreshold
+	kindPartialCount
+	kindResponse
+	kindQuery
+	kindCheckpoint
+	kindKeyShares
+	kindRelinearizationShare
+	kindDecryptionShare
+)
+
+func (k objectKind) String() string {
+	switch k {
+	case kindRequest:
+		return "Request"
+	case kindEvaluationKeys:
+		return "EvaluationKeys"
+	case kindTestVectors:
+		return "TestVectors"
+	case kindPrivateThreshold:
+		return "PrivateThreshold"
+	case kindPartialCount:
+		return "PartialCount"
+	case kindResponse:
+		return "Response"
+	case kindQuery:
+		return "Query"
+	case kindCheckpoint:
+		return "Checkpoint"
+	case kindKeyShares:
+		return "KeyShares"
+	case kindRelinearizationShare:
+		return "RelinearizationShare"
+	case kindDecryptionShare:
+		return "DecryptionShare"
+	default:
+		return fmt.Sprintf("objectKind(%d)", uint8(k))
+	}
+}
+
+func writeHeader(w buffer.Writer, kind objectKind) (n int64, err error) {
+	header := []byte{serializationMagic[0], serializationMagic[1], serializationMagic[2], SerializationVersion, uint8(kind)}
+	return buffer.Write(w, header)
+}
+
+func readHeader(r buffer.Reader, kind objectKind) (n int64, err error) {
+
+	header := make([]byte, headerSize)
+
+	if n, err = buffer.Read(r, header); err != nil {
+		return n, fmt.Errorf("buffer.Read: %w", err)
+	}
+
+	if [3]byte(header[:3]) != serializationMagic {
+		return n, fmt.Errorf("invalid header: not a pde object")
+	}
+
+	if header[3] != SerializationVersion {
+		return n, fmt.Errorf("invalid header: unsupported version %d, expected %d", header[3], SerializationVersion)
+	}
+
+	if objectKind(header[4]) != kind {
+		return n, fmt.Errorf("invalid header: object is a %v, expected a %v", objectKind(header[4]), kind)
+	}
+
+	return
+}
+
+// newBytesReader returns a buffer.Reader on p. Unlike buffer.Buffer,
+// it reports truncated inputs with an error.
+func newBytesReader(p []byte) buffer.Reader {
+	return bufio.NewReaderSize(bytes.NewReader(p), 1<<16)
+}
+
+func writeBool(w buffer.Writer, b bool) (n int64, err error) {
+	if b {
+		return buffer.WriteUint8(w, 1)
+	}
+	return buffer.WriteUint8(w, 0)
+}
+
+func readBool(r buffer.Reader, b *bool) (n int64, err error) {
+
+	var c uint8
+	if n, err = buffer.ReadUint8(r, &c); err != nil {
+		return
+	}
+
+	if c > 1 {
+		return n, fmt.Errorf("invalid boolean: %d", c)
+	}
+
+	*b = c == 1
+
+	return
+}
+
+func writeBytes(w buffer.Writer, b []byte) (n int64, err error) {
+
+	if n, err = buffer.WriteAsUint64[int](w, len(b)); err != nil {
+		return
+	}
+
+	var inc int64
+	inc, err = buffer.Write(w, b)
+
+	return n + inc, err
+}
+
+func readBytes(r buffer.Reader, b *[]byte) (n int64, err error) {
+
+	var size int
+	if n, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+		return
+	}
+
+	// The byte strings are the parameters and the identifiers of the hospitals
+	if size < 0 || size > 1<<20 {
+		return n, fmt.Errorf("invalid size: %d", size)
+	}
+
+	*b = make([]byte, size)
+
+	var inc int64
+	inc, err = buffer.Read(r, *b)
+
+	return n + inc, err
+}
+
+// writeInts writes a slice of non-negative integers.
+func writeInts(w buffer.Writer, v []int) (n int64, err error) {
+
+	if n, err = buffer.WriteAsUint64[int](w, len(v)); err != nil {
+		return
+	}
+
+	for _, x := range v {
+		var inc int64
+		if inc, err = buffer.WriteAsUint64[int](w, x); err != nil {
+			return n + inc, err
+		}
+		n += inc
+	}
+
+	return
+}
+
+// readInts reads a slice written with writeInts, or nil if it is empty.
+func readInts(r buffer.Reader, v *[]int) (n int64, err error) {
+
+	var size int
+	if n, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+		return
+	}
+
+	// The integers are the columns of a function
+	if size < 0 || size > 1<<10 {
+		return n, fmt.Errorf("invalid size: %d", size)
+	}
+
+	*v = nil
+
+	for i := 0; i < size; i++ {
+
+		var x int
+		var inc int64
+		if inc, err = buffer.ReadAsUint64[int](r, &x); err != nil {
+			return n + inc, err
+		}
+		n += inc
+
+		*v = append(*v, x)
+	}
+
+	return
+}
+
+// writeOptional writes a flag and, if v is not nil, v.
+func writeOptional[T any, P interface {
+	*T
+	io.WriterTo
+}](w buffer.Writer, v P) (n int64, err error) {
+
+	if n, err = writeBool(w, v != nil); err != nil || v == nil {
+		return
+	}
+
+	var inc int64
+	inc, err = v.WriteTo(w)
+
+	return n + inc, err
+}
+
+// readOptional reads a flag and, if it is set, a new value into *v, else sets *v to nil.
+func readOptional[T any, P interface {
+	*T
+	io.ReaderFrom
+}](r buffer.Reader, v *P) (n int64, err error) {
+
+	var present bool
+	if n, err = readBool(r, &present); err != nil {
+		return
+	}
+
+	if !present {
+		*v = nil
+		return
+	}
+
+	*v = new(T)
+
+	var inc int64
+	inc, err = (*v).ReadFrom(r)
+
+	return n + inc, err
+}
+
+// optionalSize returns the size of the object written with writeOptional.
+func optionalSize[T any, P interface {
+	*T
+	BinarySize() int
+}](v P) int {
+	if v == nil {
+		return 1
+	}
+	return 1 + v.BinarySize()
+}
+
+// writeSlice writes the length of the slice and its elements.
+func writeSlice[T io.WriterTo](w buffer.Writer, v []T) (n int64, err error) {
+
+	if n, err = buffer.WriteAsUint64[int](w, len(v)); err != nil {
+		return
+	}
+
+	for i := range v {
+		var inc int64
+		if inc, err = v[i].WriteTo(w); err != nil {
+			return n + inc, err
+		}
+		n += inc
+	}
+
+	return
+}
+
+// readSlice reads a slice written with writeSlice, or nil if it is empty.
+func readSlice[T any, P interface {
+	*T
+	io.ReaderFrom
+}](r buffer.Reader, v *[]T) (n int64, err error) {
+
+	var size int
+	if n, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+		return
+	}
+
+	// The elements are the shares of the keys of a ring degree
+	if size < 0 || size > 1<<10 {
+		return n, fmt.Errorf("invalid size: %d", size)
+	}
+
+	*v = nil
+
+	for i := 0; i < size; i++ {
+
+		var x T
+		var inc int64
+		if inc, err = P(&x).ReadFrom(r); err != nil {
+			return n + inc, err
+		}
+		n += inc
+
+		*v = append(*v, x)
+	}
+
+	return
+}
+
+// sliceSize returns the size of the slice written with writeSlice.
+func sliceSize[T interface{ BinarySize() int }](v []T) (size int) {
+	size = 8
+	for i := range v {
+		size += v[i].BinarySize()
+	}
+	return
+}
+
+// writeShamirPoints writes a slice of Shamir points.
+func writeShamirPoints(w buffer.Writer, v []mhe.ShamirPublicPoint) (n int64, err error) {
+
+	ints := make([]int, len(v))
+	for i := range v {
+		ints[i] = int(v[i])
+	}
+
+	return writeInts(w, ints)
+}
+
+// readShamirPoints reads a slice written with writeShamirPoints.
+func readShamirPoints(r buffer.Reader, v *[]mhe.ShamirPublicPoint) (n int64, err error) {
+
+	var ints []int
+	if n, err = readInts(r, &ints); err != nil {
+		return
+	}
+
+	*v = nil
+	for _, x := range ints {
+		*v = append(*v, mhe.ShamirPublicPoint(x))
+	}
+
+	return
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (a Axis) BinarySize() (size int) {
+	return 25
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (a Axis) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		for _, x := range a.Interval {
+			if inc, err = buffer.WriteAsUint64[float64](w, x); err != nil {
+				return n + inc, fmt.Errorf("buffer.WriteAsUint64[float64]: %w", err)
+			}
+			n += inc
+		}
+
+		if inc, err = buffer.WriteAsUint64[int](w, a.Points); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeBool(w, a.Categorical); err != nil {
+			return n + inc, fmt.Errorf("writeBool: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return a.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (a *Axis) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		for i := range a.Interval {
+			if inc, err = buffer.ReadAsUint64[float64](r, &a.Interval[i]); err != nil {
+				return n + inc, fmt.Errorf("buffer.ReadAsUint64[float64]: %w", err)
+			}
+			n += inc
+		}
+
+		if inc, err = buffer.ReadAsUint64[int](r, &a.Points); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if inc, err = readBool(r, &a.Categorical); err != nil {
+			return n + inc, fmt.Errorf("readBool: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return a.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (tv TestVector) BinarySize() (size int) {
+
+	size = tv.Value.BinarySize() + 43 + 8*len(tv.Columns)
+
+	for _, a := range tv.Axes {
+		size += a.BinarySize()
+	}
+
+	if len(tv.Slope) != 0 {
+		size += tv.Slope.BinarySize()
+	}
+
+	return
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (tv TestVector) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = tv.Value.WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("tv.Value.WriteTo: %w", err)
+		}
+		n += inc
+
+		for _, x := range tv.Interval {
+			if inc, err = buffer.WriteAsUint64[float64](w, x); err != nil {
+				return n + inc, fmt.Errorf("buffer.WriteAsUint64[float64]: %w", err)
+			}
+			n += inc
+		}
+
+		if inc, err = buffer.WriteAsUint64[int](w, tv.Points); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeBool(w, tv.Categorical); err != nil {
+			return n + inc, fmt.Errorf("writeBool: %w", err)
+		}
+		n += inc
+
+		// The slope is only written for interpolated functions
+		if inc, err = writeBool(w, len(tv.Slope) != 0); err != nil {
+			return n + inc, fmt.Errorf("writeBool: %w", err)
+		}
+		n += inc
+
+		if len(tv.Slope) != 0 {
+			if inc, err = tv.Slope.WriteTo(w); err != nil {
+				return n + inc, fmt.Errorf("tv.Slope.WriteTo: %w", err)
+			}
+			n += inc
+		}
+
+		if inc, err = writeInts(w, tv.Columns); err != nil {
+			return n + inc, fmt.Errorf("writeInts: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[int](w, len(tv.Axes)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		for i := range tv.Axes {
+			if inc, err = tv.Axes[i].WriteTo(w); err != nil {
+				return n + inc, fmt.Errorf("tv.Axes[%d].WriteTo: %w", i, err)
+			}
+			n += inc
+		}
+
+		if inc, err = buffer.WriteUint8(w, uint8(tv.Policy)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteUint8: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return tv.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (tv *TestVector) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = tv.Value.ReadFrom(r); err != nil {
+			return n + inc, fmt.Errorf("tv.Value.ReadFrom: %w", err)
+		}
+		n += inc
+
+		for i := range tv.Interval {
+			if inc, err = buffer.ReadAsUint64[float64](r, &tv.Interval[i]); err != nil {
+				return n + inc, fmt.Errorf("buffer.ReadAsUint64[float64]: %w", err)
+			}
+			n += inc
+		}
+
+		if inc, err = buffer.ReadAsUint64[int](r, &tv.Points); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if inc, err = readBool(r, &tv.Categorical); err != nil {
+			return n + inc, fmt.Errorf("readBool: %w", err)
+		}
+		n += inc
+
+		var interpolate bool
+		if inc, err = readBool(r, &interpolate); err != nil {
+			return n + inc, fmt.Errorf("readBool: %w", err)
+		}
+		n += inc
+
+		tv.Slope = nil
+
+		if interpolate {
+			if inc, err = tv.Slope.ReadFrom(r); err != nil {
+				return n + inc, fmt.Errorf("tv.Slope.ReadFrom: %w", err)
+			}
+			n += inc
+		}
+
+		if inc, err = readInts(r, &tv.Columns); err != nil {
+			return n + inc, fmt.Errorf("readInts: %w", err)
+		}
+		n += inc
+
+		var axes int
+		if inc, err = buffer.ReadAsUint64[int](r, &axes); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if axes < 0 || axes > 1<<10 {
+			return n, fmt.Errorf("invalid #Axis: %d", axes)
+		}
+
+		tv.Axes = nil
+
+		for i := 0; i < axes; i++ {
+
+			var a Axis
+			if inc, err = a.ReadFrom(r); err != nil {
+				return n + inc, fmt.Errorf("tv.Axes[%d].ReadFrom: %w", i, err)
+			}
+			n += inc
+
+			tv.Axes = append(tv.Axes, a)
+		}
+
+		var policy uint8
+		if inc, err = buffer.ReadUint8(r, &policy); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadUint8: %w", err)
+		}
+		n += inc
+
+		if tv.Policy = ValuePolicy(policy); tv.Policy > PolicySkip {
+			return n, fmt.Errorf("invalid policy: %d", policy)
+		}
+
+		return
+
+	default:
+		return tv.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (tv TestVectors) BinarySize() (size int) {
+
+	size = headerSize + 8
+
+	for _, v := range tv {
+		size += v.BinarySize()
+	}
+
+	return
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (tv TestVectors) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindTestVectors); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[int](w, len(tv)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		for i := range tv {
+			if inc, err = tv[i].WriteTo(w); err != nil {
+				return n + inc, fmt.Errorf("tv[%d].WriteTo: %w", i, err)
+			}
+			n += inc
+		}
+
+		return n, w.Flush()
+
+	default:
+		return tv.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (tv *TestVectors) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindTestVectors); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		var size int
+		if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if size < 0 {
+			return n, fmt.Errorf("invalid #TestVector: %d", size)
+		}
+
+		*tv = make(TestVectors, size)
+
+		for i := range *tv {
+			if inc, err = (*tv)[i].ReadFrom(r); err != nil {
+				return n + inc, fmt.Errorf("tv[%d].ReadFrom: %w", i, err)
+			}
+			n += inc
+		}
+
+		return
+
+	default:
+		return tv.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// MarshalBinary encodes the object into a binary form on a newly allocated slice of bytes.
+func (tv TestVectors) MarshalBinary() (p []byte, err error) {
+	buf := buffer.NewBufferSize(tv.BinarySize())
+	_, err = tv.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a slice of bytes generated by
+// MarshalBinary or WriteTo on the object.
+func (tv *TestVectors) UnmarshalBinary(p []byte) (err error) {
+	_, err = tv.ReadFrom(newBytesReader(p))
+	return
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (p PrivateThreshold) BinarySize() (size int) {
+	return headerSize + 8 + optionalSize(p.Threshold) + optionalSize(p.Normalization)
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (p PrivateThreshold) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindPrivateThreshold); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[float64](w, p.Max); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[float64]: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeOptional(w, p.Threshold); err != nil {
+			return n + inc, fmt.Errorf("p.Threshold.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeOptional(w, p.Normalization); err != nil {
+			return n + inc, fmt.Errorf("p.Normalization.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return p.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (p *PrivateThreshold) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindPrivateThreshold); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.ReadAsUint64[float64](r, &p.Max); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[float64]: %w", err)
+		}
+		n += inc
+
+		if inc, err = readOptional(r, &p.Threshold); err != nil {
+			return n + inc, fmt.Errorf("p.Threshold.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = readOptional(r, &p.Normalization); err != nil {
+			return n + inc, fmt.Errorf("p.Normalization.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return p.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// MarshalBinary encodes the object into a binary form on a newly allocated slice of bytes.
+func (p PrivateThreshold) MarshalBinary() (data []byte, err error) {
+	buf := buffer.NewBufferSize(p.BinarySize())
+	_, err = p.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a slice of bytes generated by
+// MarshalBinary or WriteTo on the object.
+func (p *PrivateThreshold) UnmarshalBinary(data []byte) (err error) {
+	_, err = p.ReadFrom(newBytesReader(data))
+	return
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (dp DifferentialPrivacy) BinarySize() (size int) {
+	return 17
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (dp DifferentialPrivacy) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = buffer.WriteUint8(w, uint8(dp.Noise)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteUint8: %w", err)
+		}
+		n += inc
+
+		for _, x := range []float64{dp.Epsilon, dp.Delta} {
+			if inc, err = buffer.WriteAsUint64[float64](w, x); err != nil {
+				return n + inc, fmt.Errorf("buffer.WriteAsUint64[float64]: %w", err)
+			}
+			n += inc
+		}
+
+		return n, w.Flush()
+
+	default:
+		return dp.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (dp *DifferentialPrivacy) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var noise uint8
+		var inc int64
+		if inc, err = buffer.ReadUint8(r, &noise); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadUint8: %w", err)
+		}
+		n += inc
+
+		dp.Noise = NoiseDistribution(noise)
+
+		for _, x := range []*float64{&dp.Epsilon, &dp.Delta} {
+			if inc, err = buffer.ReadAsUint64[float64](r, x); err != nil {
+				return n + inc, fmt.Errorf("buffer.ReadAsUint64[float64]: %w", err)
+			}
+			n += inc
+		}
+
+		return
+
+	default:
+		return dp.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (rpk RepackEvaluationKeySet) BinarySize() (size int) {
+
+	size += 8
+	for _, LogN := range utils.GetSortedKeys(rpk.Parameters) {
+		data, err := rpk.Parameters[LogN].MarshalBinary()
+		// Sanity check, this error should not happen
+		if err != nil {
+			panic(err)
+		}
+		size += 16 + len(data)
+	}
+
+	size += 8
+	for _, keys := range rpk.RingSwitchingKeys {
+		size += 16
+		for _, evk := range keys {
+			size += 8 + evk.BinarySize()
+		}
+	}
+
+	size += 8
+	for _, evk := range rpk.RepackKeys {
+		// Sanity check, this error should not happen
+		mem, ok := evk.(*rlwe.MemEvaluationKeySet)
+		if !ok {
+			panic(fmt.Errorf("cannot BinarySize: RepackKeys must be *rlwe.MemEvaluationKeySet but is %T", evk))
+		}
+		size += 8 + mem.BinarySize()
+	}
+
+	return
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+// The RepackKeys must be of type *rlwe.MemEvaluationKeySet.
+func (rpk RepackEvaluationKeySet) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = buffer.WriteAsUint64[int](w, len(rpk.Parameters)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		for _, LogN := range utils.GetSortedKeys(rpk.Parameters) {
+
+			var data []byte
+			if data, err = rpk.Parameters[LogN].MarshalBinary(); err != nil {
+				return n, fmt.Errorf("rpk.Parameters[%d].MarshalBinary: %w", LogN, err)
+			}
+
+			if inc, err = buffer.WriteAsUint64[int](w, LogN); err != nil {
+				return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+			}
+			n += inc
+
+			if inc, err = writeBytes(w, data); err != nil {
+				return n + inc, fmt.Errorf("writeBytes: %w", err)
+			}
+			n += inc
+		}
+
+		if inc, err = buffer.WriteAsUint64[int](w, len(rpk.RingSwitchingKeys)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		for _, i := range utils.GetSortedKeys(rpk.RingSwitchingKeys) {
+
+			if inc, err = buffer.WriteAsUint64[int](w, i); err != nil {
+				return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+			}
+			n += inc
+
+			if inc, err = buffer.WriteAsUint64[int](w, len(rpk.RingSwitchingKeys[i])); err != nil {
+				return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+			}
+			n += inc
+
+			for _, j := range utils.GetSortedKeys(rpk.RingSwitchingKeys[i]) {
+
+				if inc, err = buffer.WriteAsUint64[int](w, j); err != nil {
+					return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+				}
+				n += inc
+
+				if inc, err = rpk.RingSwitchingKeys[i][j].WriteTo(w); err != nil {
+					return n + inc, fmt.Errorf("rpk.RingSwitchingKeys[%d][%d].WriteTo: %w", i, j, err)
+				}
+				n += inc
+			}
+		}
+
+		if inc, err = buffer.WriteAsUint64[int](w, len(rpk.RepackKeys)); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		for _, LogN := range utils.GetSortedKeys(rpk.RepackKeys) {
+
+			mem, ok := rpk.RepackKeys[LogN].(*rlwe.MemEvaluationKeySet)
+			if !ok {
+				return n, fmt.Errorf("rpk.RepackKeys[%d] must be *rlwe.MemEvaluationKeySet but is %T", LogN, rpk.RepackKeys[LogN])
+			}
+
+			if inc, err = buffer.WriteAsUint64[int](w, LogN); err != nil {
+				return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+			}
+			n += inc
+
+			if inc, err = mem.WriteTo(w); err != nil {
+				return n + inc, fmt.Errorf("rpk.RepackKeys[%d].WriteTo: %w", LogN, err)
+			}
+			n += inc
+		}
+
+		return n, w.Flush()
+
+	default:
+		return rpk.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (rpk *RepackEvaluationKeySet) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		var size int
+		if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		rpk.Parameters = map[int]*hefloat.Parameters{}
+
+		for k := 0; k < size; k++ {
+
+			var LogN int
+			if inc, err = buffer.ReadAsUint64[int](r, &LogN); err != nil {
+				return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+			}
+			n += inc
+
+			var data []byte
+			if inc, err = readBytes(r, &data); err != nil {
+				return n + inc, fmt.Errorf("readBytes: %w", err)
+			}
+			n += inc
+
+			p := new(hefloat.Parameters)
+			if err = p.UnmarshalBinary(data); err != nil {
+				return n, fmt.Errorf("rpk.Parameters[%d].UnmarshalBinary: %w", LogN, err)
+			}
+
+			if p.LogN() != LogN {
+				return n, fmt.Errorf("invalid rpk.Parameters[%d]: LogN=%d", LogN, p.LogN())
+			}
+
+			rpk.Parameters[LogN] = p
+		}
+
+		if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		rpk.RingSwitchingKeys = map[int]map[int]*rlwe.EvaluationKey{}
+
+		for k := 0; k < size; k++ {
+
+			var i, keys int
+			if inc, err = buffer.ReadAsUint64[int](r, &i); err != nil {
+				return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+			}
+			n += inc
+
+			if inc, err = buffer.ReadAsUint64[int](r, &keys); err != nil {
+				return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+			}
+			n += inc
+
+			rpk.RingSwitchingKeys[i] = map[int]*rlwe.EvaluationKey{}
+
+			for l := 0; l < keys; l++ {
+
+				var j int
+				if inc, err = buffer.ReadAsUint64[int](r, &j); err != nil {
+					return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+				}
+				n += inc
+
+				evk := new(rlwe.EvaluationKey)
+				if inc, err = evk.ReadFrom(r); err != nil {
+					return n + inc, fmt.Errorf("rpk.RingSwitchingKeys[%d][%d].ReadFrom: %w", i, j, err)
+				}
+				n += inc
+
+				rpk.RingSwitchingKeys[i][j] = evk
+			}
+		}
+
+		if inc, err = buffer.ReadAsUint64[int](r, &size); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		rpk.RepackKeys = map[int]rlwe.EvaluationKeySet{}
+
+		for k := 0; k < size; k++ {
+
+			var LogN int
+			if inc, err = buffer.ReadAsUint64[int](r, &LogN); err != nil {
+				return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+			}
+			n += inc
+
+			mem := new(rlwe.MemEvaluationKeySet)
+			if inc, err = mem.ReadFrom(r); err != nil {
+				return n + inc, fmt.Errorf("rpk.RepackKeys[%d].ReadFrom: %w", LogN, err)
+			}
+			n += inc
+
+			rpk.RepackKeys[LogN] = mem
+		}
+
+		return
+
+	default:
+		return rpk.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// bootstrappingKeys returns pointers to the optional keys of the bootstrapping keys, in the order of serialization.
+func (evk *EvaluationKeys) bootstrappingKeys() []**rlwe.EvaluationKey {
+	return []**rlwe.EvaluationKey{
+		&evk.EvkN1ToN2,
+		&evk.EvkN2ToN1,
+		&evk.EvkRealToCmplx,
+		&evk.EvkCmplxToReal,
+		&evk.EvkDenseToSparse,
+		&evk.EvkSparseToDense,
+	}
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (evk EvaluationKeys) BinarySize() (size int) {
+
+	size = headerSize + evk.RepackEvaluationKeySet.BinarySize()
+
+	for _, k := range evk.bootstrappingKeys() {
+		size += optionalSize(*k)
+	}
+
+	return size + optionalSize(evk.MemEvaluationKeySet)
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (evk EvaluationKeys) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindEvaluationKeys); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = evk.RepackEvaluationKeySet.WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("evk.RepackEvaluationKeySet.WriteTo: %w", err)
+		}
+		n += inc
+
+		for i, k := range evk.bootstrappingKeys() {
+			if inc, err = writeOptional(w, *k); err != nil {
+				return n + inc, fmt.Errorf("bootstrapping key %d: WriteTo: %w", i, err)
+			}
+			n += inc
+		}
+
+		if inc, err = writeOptional(w, evk.MemEvaluationKeySet); err != nil {
+			return n + inc, fmt.Errorf("evk.MemEvaluationKeySet.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return evk.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (evk *EvaluationKeys) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindEvaluationKeys); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = evk.RepackEvaluationKeySet.ReadFrom(r); err != nil {
+			return n + inc, fmt.Errorf("evk.RepackEvaluationKeySet.ReadFrom: %w", err)
+		}
+		n += inc
+
+		for i, k := range evk.bootstrappingKeys() {
+			if inc, err = readOptional(r, k); err != nil {
+				return n + inc, fmt.Errorf("bootstrapping key %d: ReadFrom: %w", i, err)
+			}
+			n += inc
+		}
+
+		if inc, err = readOptional(r, &evk.MemEvaluationKeySet); err != nil {
+			return n + inc, fmt.Errorf("evk.MemEvaluationKeySet.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return evk.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// MarshalBinary encodes the object into a binary form on a newly allocated slice of bytes.
+func (evk EvaluationKeys) MarshalBinary() (p []byte, err error) {
+	buf := buffer.NewBufferSize(evk.BinarySize())
+	_, err = evk.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a slice of bytes generated by
+// MarshalBinary or WriteTo on the object.
+func (evk *EvaluationKeys) UnmarshalBinary(p []byte) (err error) {
+	_, err = evk.ReadFrom(newBytesReader(p))
+	return
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (r Request) BinarySize() (size int) {
+	return headerSize +
+		optionalSize(r.EvaluationKeys) +
+		optionalSize(r.TestVectors) +
+		optionalSize(r.PrivateThreshold0) +
+		optionalSize(r.PrivateThreshold1) +
+		optionalSize(r.Privacy)
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+// Each field of the request is optional, so that the evaluation keys
+// can be sent once and the queries without them.
+func (r Request) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindRequest); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeOptional(w, r.EvaluationKeys); err != nil {
+			return n + inc, fmt.Errorf("r.EvaluationKeys.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeOptional(w, r.TestVectors); err != nil {
+			return n + inc, fmt.Errorf("r.TestVectors.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeOptional(w, r.PrivateThreshold0); err != nil {
+			return n + inc, fmt.Errorf("r.PrivateThreshold0.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeOptional(w, r.PrivateThreshold1); err != nil {
+			return n + inc, fmt.Errorf("r.PrivateThreshold1.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeOptional(w, r.Privacy); err != nil {
+			return n + inc, fmt.Errorf("r.Privacy.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return r.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (r *Request) ReadFrom(rd io.Reader) (n int64, err error) {
+	switch rd := rd.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(rd, kindRequest); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = readOptional(rd, &r.EvaluationKeys); err != nil {
+			return n + inc, fmt.Errorf("r.EvaluationKeys.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = readOptional(rd, &r.TestVectors); err != nil {
+			return n + inc, fmt.Errorf("r.TestVectors.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = readOptional(rd, &r.PrivateThreshold0); err != nil {
+			return n + inc, fmt.Errorf("r.PrivateThreshold0.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = readOptional(rd, &r.PrivateThreshold1); err != nil {
+			return n + inc, fmt.Errorf("r.PrivateThreshold1.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = readOptional(rd, &r.Privacy); err != nil {
+			return n + inc, fmt.Errorf("r.Privacy.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return r.ReadFrom(bufio.NewReader(rd))
+	}
+}
+
+// MarshalBinary encodes the object into a binary form on a newly allocated slice of bytes.
+func (r Request) MarshalBinary() (p []byte, err error) {
+	buf := buffer.NewBufferSize(r.BinarySize())
+	_, err = r.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a slice of bytes generated by
+// MarshalBinary or WriteTo on the object.
+func (r *Request) UnmarshalBinary(p []byte) (err error) {
+	_, err = r.ReadFrom(newBytesReader(p))
+	return
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (p PartialCount) BinarySize() (size int) {
+	return headerSize + 8 + len(p.Hospital) + 8 + optionalSize(p.Count)
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (p PartialCount) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindPartialCount); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeBytes(w, []byte(p.Hospital)); err != nil {
+			return n + inc, fmt.Errorf("writeBytes: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[int](w, p.Rows); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeOptional(w, p.Count); err != nil {
+			return n + inc, fmt.Errorf("p.Count.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return p.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (p *PartialCount) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindPartialCount); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		var hospital []byte
+		if inc, err = readBytes(r, &hospital); err != nil {
+			return n + inc, fmt.Errorf("readBytes: %w", err)
+		}
+		n += inc
+
+		p.Hospital = string(hospital)
+
+		if inc, err = buffer.ReadAsUint64[int](r, &p.Rows); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[int]: %w", err)
+		}
+		n += inc
+
+		if inc, err = readOptional(r, &p.Count); err != nil {
+			return n + inc, fmt.Errorf("p.Count.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return p.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// MarshalBinary encodes the object into a binary form on a newly allocated slice of bytes.
+func (p PartialCount) MarshalBinary() (data []byte, err error) {
+	buf := buffer.NewBufferSize(p.BinarySize())
+	_, err = p.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a slice of bytes generated by
+// MarshalBinary or WriteTo on the object.
+func (p *PartialCount) UnmarshalBinary(data []byte) (err error) {
+	_, err = p.ReadFrom(newBytesReader(data))
+	return
+}
+
+// WriteResponse writes the response of the server to a request,
+// i.e. the ciphertext returned by Server.ProcessRequest or Server.Aggregate.
+func WriteResponse(w io.Writer, score *rlwe.Ciphertext) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		if score == nil {
+			return 0, fmt.Errorf("invalid response: score is nil")
+		}
+
+		var inc int64
+		if inc, err = writeHeader(w, kindResponse); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = score.WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("score.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return WriteResponse(bufio.NewWriter(w), score)
+	}
+}
+
+// ReadResponse reads a response written with WriteResponse.
+func ReadResponse(r io.Reader) (score *rlwe.Ciphertext, n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindResponse); err != nil {
+			return nil, n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		score = new(rlwe.Ciphertext)
+		if inc, err = score.ReadFrom(r); err != nil {
+			return nil, n + inc, fmt.Errorf("score.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return ReadResponse(bufio.NewReader(r))
+	}
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (ks KeyShares) BinarySize() (size int) {
+	return headerSize + 8 + sliceSize(ks.PublicKeys) + sliceSize(ks.RingSwitchingKeys) + sliceSize(ks.RepackKeys) +
+		sliceSize(ks.SparseKeys) + sliceSize(ks.BootstrappingKeys) + ks.Relinearization.BinarySize()
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (ks KeyShares) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindKeyShares); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[mhe.ShamirPublicPoint](w, ks.ID); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[mhe.ShamirPublicPoint]: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeSlice(w, ks.PublicKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.PublicKeys.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeSlice(w, ks.RingSwitchingKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.RingSwitchingKeys.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeSlice(w, ks.RepackKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.RepackKeys.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeSlice(w, ks.SparseKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.SparseKeys.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeSlice(w, ks.BootstrappingKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.BootstrappingKeys.WriteTo: %w", err)
+		}
+		n += inc
+
+		if inc, err = ks.Relinearization.WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("ks.Relinearization.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return ks.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (ks *KeyShares) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindKeyShares); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.ReadAsUint64[mhe.ShamirPublicPoint](r, &ks.ID); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[mhe.ShamirPublicPoint]: %w", err)
+		}
+		n += inc
+
+		if inc, err = readSlice(r, &ks.PublicKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.PublicKeys.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = readSlice(r, &ks.RingSwitchingKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.RingSwitchingKeys.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = readSlice(r, &ks.RepackKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.RepackKeys.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = readSlice(r, &ks.SparseKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.SparseKeys.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = readSlice(r, &ks.BootstrappingKeys); err != nil {
+			return n + inc, fmt.Errorf("ks.BootstrappingKeys.ReadFrom: %w", err)
+		}
+		n += inc
+
+		if inc, err = ks.Relinearization.ReadFrom(r); err != nil {
+			return n + inc, fmt.Errorf("ks.Relinearization.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return ks.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// MarshalBinary encodes the object into a binary form on a newly allocated slice of bytes.
+func (ks KeyShares) MarshalBinary() (p []byte, err error) {
+	buf := buffer.NewBufferSize(ks.BinarySize())
+	_, err = ks.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a slice of bytes generated by
+// MarshalBinary or WriteTo on the object.
+func (ks *KeyShares) UnmarshalBinary(p []byte) (err error) {
+	_, err = ks.ReadFrom(newBytesReader(p))
+	return
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (rs RelinearizationShare) BinarySize() (size int) {
+	return headerSize + 8 + rs.Share.BinarySize()
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (rs RelinearizationShare) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindRelinearizationShare); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[mhe.ShamirPublicPoint](w, rs.ID); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[mhe.ShamirPublicPoint]: %w", err)
+		}
+		n += inc
+
+		if inc, err = rs.Share.WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("rs.Share.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return rs.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (rs *RelinearizationShare) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindRelinearizationShare); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.ReadAsUint64[mhe.ShamirPublicPoint](r, &rs.ID); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[mhe.ShamirPublicPoint]: %w", err)
+		}
+		n += inc
+
+		if inc, err = rs.Share.ReadFrom(r); err != nil {
+			return n + inc, fmt.Errorf("rs.Share.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return rs.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// MarshalBinary encodes the object into a binary form on a newly allocated slice of bytes.
+func (rs RelinearizationShare) MarshalBinary() (p []byte, err error) {
+	buf := buffer.NewBufferSize(rs.BinarySize())
+	_, err = rs.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a slice of bytes generated by
+// MarshalBinary or WriteTo on the object.
+func (rs *RelinearizationShare) UnmarshalBinary(p []byte) (err error) {
+	_, err = rs.ReadFrom(newBytesReader(p))
+	return
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (ds DecryptionShare) BinarySize() (size int) {
+	return headerSize + 8 + 8 + 8*len(ds.Active) + ds.Share.BinarySize()
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (ds DecryptionShare) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindDecryptionShare); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.WriteAsUint64[mhe.ShamirPublicPoint](w, ds.ID); err != nil {
+			return n + inc, fmt.Errorf("buffer.WriteAsUint64[mhe.ShamirPublicPoint]: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeShamirPoints(w, ds.Active); err != nil {
+			return n + inc, fmt.Errorf("writeShamirPoints: %w", err)
+		}
+		n += inc
+
+		if inc, err = ds.Share.WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("ds.Share.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return ds.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (ds *DecryptionShare) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindDecryptionShare); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = buffer.ReadAsUint64[mhe.ShamirPublicPoint](r, &ds.ID); err != nil {
+			return n + inc, fmt.Errorf("buffer.ReadAsUint64[mhe.ShamirPublicPoint]: %w", err)
+		}
+		n += inc
+
+		if inc, err = readShamirPoints(r, &ds.Active); err != nil {
+			return n + inc, fmt.Errorf("readShamirPoints: %w", err)
+		}
+		n += inc
+
+		if inc, err = ds.Share.ReadFrom(r); err != nil {
+			return n + inc, fmt.Errorf("ds.Share.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return ds.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// MarshalBinary encodes the object into a binary form on a newly allocated slice of bytes.
+func (ds DecryptionShare) MarshalBinary() (p []byte, err error) {
+	buf := buffer.NewBufferSize(ds.BinarySize())
+	_, err = ds.WriteTo(buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a slice of bytes generated by
+// MarshalBinary or WriteTo on the object.
+func (ds *DecryptionShare) UnmarshalBinary(p []byte) (err error) {
+	_, err = ds.ReadFrom(newBytesReader(p))
+	return
+}
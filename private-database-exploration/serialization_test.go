@@ -0,0 +1,217 @@
+package pde
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat/bootstrapping"
+	"github.com/tuneinsight/lattigo/v5/ring"
+	"github.com/tuneinsight/lattigo/v5/utils/buffer"
+)
+
+// newTestKeys returns a client with the ring degrees of ProfileTestInsecure, but
+// parameters of degree 2^{LogNEval} with a single level, and its ring switching
+// and repacking keys, with a subset of the bootstrapping keys.
+func newTestKeys(t *testing.T) (client Client, evk EvaluationKeys, sk *rlwe.SecretKey) {
+
+	cfg, err := NewConfig(ProfileTestInsecure)
+	require.NoError(t, err)
+
+	cfg.Parameters = hefloat.ParametersLiteral{
+		LogN:            cfg.LogNEval,
+		LogQ:            []int{60, 45},
+		LogP:            []int{61},
+		LogDefaultScale: 45,
+		Xs:              ring.Ternary{H: 192},
+	}
+
+	paramsEval, err := cfg.ParametersEval()
+	require.NoError(t, err)
+
+	client = NewClient()
+	client.Config = cfg
+
+	kgen := rlwe.NewKeyGenerator(paramsEval)
+	sk = kgen.GenSecretKeyNew()
+
+	rpk := RepackEvaluationKeySet{}
+	client.Ski, err = rpk.GenRingSwitchingKeys(paramsEval, sk, cfg.LogNPack, cfg.EvaluationKeys)
+	require.NoError(t, err)
+	rpk.GenRepackEvaluationKeys(rpk.Parameters[cfg.LogNPack], client.Ski[cfg.LogNPack], cfg.EvaluationKeys)
+	client.Parameters = rpk.Parameters
+
+	evk = EvaluationKeys{
+		RepackEvaluationKeySet: rpk,
+		EvaluationKeys: bootstrapping.EvaluationKeys{
+			EvkDenseToSparse:    kgen.GenEvaluationKeyNew(sk, kgen.GenSecretKeyNew()),
+			MemEvaluationKeySet: rlwe.NewMemEvaluationKeySet(kgen.GenRelinearizationKeyNew(sk)),
+		},
+	}
+
+	return
+}
+
+func TestSerialization(t *testing.T) {
+
+	client, evk, sk := newTestKeys(t)
+
+	paramsEval := *client.Parameters[client.Config.LogNEval]
+
+	// Only the parameters of LogNPack are needed to generate the test vectors
+	funcs := []Func{
+		NewScoringFunction([2]float64{0, 4}, 2<<client.Config.LogNPack, 1/float64(Scaling)),
+		NewCategoricalScoringFunction([]float64{0, 1, 2}),
+		{F: math.Sin, Interval: [2]float64{0, 8}, Points: 64, Max: 1, Interpolate: true, Policy: PolicyMissing, Missing: 0.5},
+		{
+			Joint:   func(x []float64) float64 { return x[0] * x[1] },
+			Columns: []int{0, 1},
+			Axes:    []Axis{{Interval: [2]float64{0, 4}, Points: 16}, {Points: 3, Categorical: true}},
+			Max:     8,
+		},
+	}
+
+	tvs, err := client.GenEncryptedFunction(funcs)
+	require.NoError(t, err)
+
+	ecd := hefloat.NewEncoder(paramsEval)
+	enc := rlwe.NewEncryptor(paramsEval, sk)
+
+	encrypt := func(v float64) *rlwe.Ciphertext {
+		pt := hefloat.NewPlaintext(paramsEval, paramsEval.MaxLevel())
+		require.NoError(t, ecd.Encode([]float64{v}, pt))
+		ct, err := enc.EncryptNew(pt)
+		require.NoError(t, err)
+		return ct
+	}
+
+	privThresh0 := PrivateThreshold{Threshold: encrypt(12), Normalization: encrypt(0.1), Max: 10}
+	privThresh1 := PrivateThreshold{Threshold: encrypt(100)}
+
+	request := Request{
+		EvaluationKeys:    &evk,
+		TestVectors:       &tvs,
+		PrivateThreshold0: &privThresh0,
+		PrivateThreshold1: &privThresh1,
+		Privacy:           &DifferentialPrivacy{Noise: DiscreteGaussian, Epsilon: 0.5, Delta: 1e-6},
+	}
+
+	partial := PartialCount{Hospital: "hospital-0", Count: encrypt(42), Rows: 1024}
+
+	t.Run("TestVectors", func(t *testing.T) {
+		buffer.RequireSerializerCorrect(t, &tvs)
+	})
+
+	t.Run("PrivateThreshold", func(t *testing.T) {
+		buffer.RequireSerializerCorrect(t, &privThresh0)
+
+		// Without normalization
+		data, err := privThresh1.MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, privThresh1.BinarySize(), len(data))
+
+		have := PrivateThreshold{Normalization: encrypt(0)}
+		require.NoError(t, have.UnmarshalBinary(data))
+		require.True(t, privThresh1.Threshold.Equal(have.Threshold))
+		require.Nil(t, have.Normalization)
+	})
+
+	t.Run("PartialCount", func(t *testing.T) {
+		buffer.RequireSerializerCorrect(t, &partial)
+	})
+
+	t.Run("Request", func(t *testing.T) {
+
+		data, err := request.MarshalBinary()
+		require.NoError(t, err)
+		require.Equal(t, request.BinarySize(), len(data))
+
+		have := Request{}
+		require.NoError(t, have.UnmarshalBinary(data))
+
+		for LogN := range request.Parameters {
+			require.True(t, request.Parameters[LogN].Equal(have.Parameters[LogN]))
+		}
+
+		require.Equal(t, *request.Privacy, *have.Privacy)
+		require.Nil(t, have.EvkN1ToN2)
+		require.NotNil(t, have.EvkDenseToSparse)
+
+		// The encoding is deterministic
+		again, err := have.MarshalBinary()
+		require.NoError(t, err)
+		require.True(t, bytes.Equal(data, again))
+
+		// A query without the evaluation keys
+		query := request
+		query.EvaluationKeys = nil
+
+		data, err = query.MarshalBinary()
+		require.NoError(t, err)
+
+		have = Request{}
+		require.NoError(t, have.UnmarshalBinary(data))
+		require.Nil(t, have.EvaluationKeys)
+		require.NotNil(t, have.TestVectors)
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+
+		// Several objects on the same stream
+		var stream bytes.Buffer
+
+		n, err := request.WriteTo(&stream)
+		require.NoError(t, err)
+		require.Equal(t, int64(request.BinarySize()), n)
+
+		_, err = partial.WriteTo(&stream)
+		require.NoError(t, err)
+
+		_, err = WriteResponse(&stream, partial.Count)
+		require.NoError(t, err)
+
+		r := bufio.NewReader(&stream)
+
+		var haveRequest Request
+		n, err = haveRequest.ReadFrom(r)
+		require.NoError(t, err)
+		require.Equal(t, int64(request.BinarySize()), n)
+
+		var havePartial PartialCount
+		_, err = havePartial.ReadFrom(r)
+		require.NoError(t, err)
+		require.Equal(t, partial.Hospital, havePartial.Hospital)
+		require.Equal(t, partial.Rows, havePartial.Rows)
+
+		score, _, err := ReadResponse(r)
+		require.NoError(t, err)
+		require.True(t, partial.Count.Equal(score))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+
+		data, err := partial.MarshalBinary()
+		require.NoError(t, err)
+
+		// Wrong kind
+		require.Error(t, new(Request).UnmarshalBinary(data))
+
+		// Wrong version
+		data[3]++
+		require.Error(t, new(PartialCount).UnmarshalBinary(data))
+		data[3]--
+
+		// Wrong magic
+		data[0] = 'X'
+		require.Error(t, new(PartialCount).UnmarshalBinary(data))
+		data[0] = 'P'
+
+		// Truncated
+		require.Error(t, new(PartialCount).UnmarshalBinary(data[:len(data)-1]))
+		require.NoError(t, new(PartialCount).UnmarshalBinary(data))
+	})
+}
@@ -1,14 +1,17 @@
 package pde
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/bits"
-	"runtime"
+	"sync"
 
 	"github.com/tuneinsight/lattigo/v5/core/rlwe"
 	"github.com/tuneinsight/lattigo/v5/he/hefloat"
 	"github.com/tuneinsight/lattigo/v5/schemes/ckks"
 	"github.com/tuneinsight/lattigo/v5/utils"
+	"github.com/tuneinsight/lattigo/v5/utils/structs"
 )
 
 type Server struct {
@@ -22,90 +25,525 @@ type Server struct {
 	Bootstrapper
 	ParamsPack hefloat.Parameters
 	ParamsEval hefloat.Parameters
+
+	// Config is the configuration of the request being processed.
+	Config Config
+
+	// Hospital identifies the database of the server in its partial counts.
+	Hospital string
+	// Accountant keeps track of the privacy budget of the hospitals,
+	// it is required to answer requests with a noisy count.
+	Accountant *PrivacyAccountant
+
+	// MemoryBudget is the approximate maximum number of bytes of the ciphertexts
+	// buffered by ProcessPartialRequest for a chunk of rows, excluding the request,
+	// the keys and the bootstrapping. It bounds the number of lookup tables evaluated
+	// before they are packed, see Server.LookupBatchSize. If zero, the lookup tables
+	// of 2^{LogNPack} rows are buffered at once.
+	MemoryBudget int
+
+	// Workers is the number of goroutines evaluating ProcessPartialRequest, each with
+	// its own evaluators and buffers, see Server.ShallowCopy. The chunks of rows are
+	// evaluated Workers at a time and their local thresholds are summed in the order
+	// of the rows, so that the result does not depend on Workers. If zero, the request
+	// is evaluated on a single goroutine.
+	Workers int
+
+	// Checkpoint is the work directory in which the intermediate ciphertexts of
+	// ProcessPartialRequest are saved, so that a restarted server can resume the
+	// request, if not nil. The state of the request is removed once it is done.
+	Checkpoint *Checkpoint
+
+	// Observer receives the progress events of ProcessPartialRequest and Aggregate, if not nil.
+	Observer Observer
+
+	// Policies counts the rows whose missing or out of grid values were handled by
+	// the policies of the test vectors, see ValuePolicy, if not nil. The counts are
+	// kept by the hospital and are not revealed to the client. The rows of a request
+	// are counted once it is done, including the rows of a resumed Checkpoint.
+	Policies *PolicyCounts
+
+	// progress is the progress of the request being processed.
+	progress *progress
+
+	// localThreshold is the sign polynomial of the local threshold of the
+	// request being processed, see Config.LocalThresholdPolynomial.
+	localThreshold SignPolynomial
 }
 
 func NewServer() Server {
 	return Server{}
 }
 
-func (s Server) ProcessRequest(r Request, db *Database, btp Bootstrapper) (score *rlwe.Ciphertext, err error) {
+// ProcessRequest evaluates the request on the database with the configuration
+// cfg, which must be the configuration of the keys of the client.
+// The evaluation stops with the error of ctx once ctx is done.
+func (s Server) ProcessRequest(ctx context.Context, cfg Config, r Request, db *Database, btp Bootstrapper) (score *rlwe.Ciphertext, err error) {
 
-	s.Bootstrapper = btp
+	var partial PartialCount
+	if partial, err = s.ProcessPartialRequest(ctx, cfg, r, db, btp); err != nil {
+		return nil, fmt.Errorf("s.ProcessPartialRequest: %w", err)
+	}
 
-	s.ParamsPack = hefloat.Parameters{Parameters: ckks.Parameters{Parameters: *r.Parameters[LogNPack].GetRLWEParameters()}}
-	s.ParamsEval = hefloat.Parameters{Parameters: ckks.Parameters{Parameters: *r.Parameters[LogNEval].GetRLWEParameters()}}
+	if score, err = s.Aggregate(ctx, cfg, r, []PartialCount{partial}, btp); err != nil {
+		return nil, fmt.Errorf("s.Aggregate: %w", err)
+	}
 
-	paramsPack := s.ParamsPack
-	paramsEval := s.ParamsEval
+	return
+}
 
-	s.EvalRepack = NewRepackEvaluator(&r.EvaluationKeys.RepackEvaluationKeySet)
+// ProcessPartialRequest evaluates the request on the database up to the
+// aggregated local threshold and returns the encrypted number of patients
+// of the database meeting the criteria, see Server.Aggregate.
+//
+// The rows are streamed: each chunk of 2^{LogNEval} rows is looked up, packed,
+// merged, scheme-switched and added to the local threshold before the next
+// chunks are read, so that the memory does not grow with the size of the database,
+// see Server.MemoryBudget. With s.Workers goroutines, s.Workers chunks are
+// evaluated at a time, see Server.Workers.
+//
+// ctx is checked between the stages of each chunk, and the progress of each
+// stage is reported to s.Observer, see Progress. With s.Checkpoint, the state of
+// the request is saved after each stage and resumed by the next call.
+func (s Server) ProcessPartialRequest(ctx context.Context, cfg Config, r Request, db *Database, btp Bootstrapper) (partial PartialCount, err error) {
+
+	if s, err = s.setup(cfg, r, btp); err != nil {
+		return partial, fmt.Errorf("s.setup: %w", err)
+	}
+
+	if r.PrivateThreshold0 == nil {
+		return partial, fmt.Errorf("missing the PrivateThreshold0")
+	}
 
-	if sk, ok := s.SkDebug[LogNEval]; ok {
-		s.DecEval = hefloat.NewDecryptor(paramsEval, sk)
-		s.EcdEval = hefloat.NewEncoder(paramsEval)
+	if err = cfg.CheckLocalThreshold(r.PrivateThreshold0.Max); err != nil {
+		return partial, fmt.Errorf("cfg.CheckLocalThreshold: %w", err)
 	}
 
-	if sk, ok := s.SkDebug[LogNPack]; ok {
-		s.DecPack = hefloat.NewDecryptor(paramsPack, sk)
-		s.EcdPack = hefloat.NewEncoder(paramsPack)
+	// The test vectors with PolicyDefault take the policies of the schema
+	var tvs TestVectors
+	if tvs, err = r.TestVectors.withPolicies(db.Schema); err != nil {
+		return partial, fmt.Errorf("r.TestVectors.withPolicies: %w", err)
+	}
+
+	r.TestVectors = &tvs
+
+	// The rows are counted for the request only, and saved with its checkpoint,
+	// so that the rows evaluated again or before a resume are counted once
+	policies := s.Policies
+	s.Policies = &PolicyCounts{}
+
+	var batch int
+	if batch, err = s.LookupBatchSize(); err != nil {
+		return partial, fmt.Errorf("s.LookupBatchSize: %w", err)
 	}
 
 	rows, cols := db.Dims()
 
+	NPack := s.ParamsPack.N()
+	NEval := s.ParamsEval.N()
+	ratio := NEval / NPack
+
+	s.progress = newProgress(s.Observer, map[Stage]int{
+		StageLookupPacking:   (rows + NPack - 1) / NPack,
+		StageMerging:         (rows + NEval - 1) / NEval,
+		StageSchemeSwitching: (rows + NEval - 1) / NEval,
+		StageInnerSum:        1,
+	})
+
 	m := db.RawMatrix().Data
+	stride := db.RawMatrix().Stride
 
-	for i := 0; i < 4; i++ {
+	for i := 0; i < utils.Min(4, rows); i++ {
 		for j := 0; j < cols; j++ {
-			fmt.Printf("%7.4f ", m[i*cols+j])
+			fmt.Printf("%7.4f ", m[i*stride+j])
 		}
 		fmt.Printf("...\n")
 	}
 	fmt.Println()
 
-	// ENCRYPTED LOOKUP-TABLES
-	// RING-PACKING
-	var res []*rlwe.Ciphertext
-	if res, err = s.EncryptedLookupTablesAndRingPacking(db, r.TestVectors); err != nil {
-		return nil, fmt.Errorf("s.EncryptedLookupTablesAndRingPacking: %w", err)
-	}
+	t0 := r.PrivateThreshold0.Threshold
+	c := r.PrivateThreshold0.Normalization
+
+	paramsEval := s.ParamsEval
+	count := hefloat.NewCiphertext(paramsEval, 1, paramsEval.MaxLevel())
 
-	r.TestVectors = nil
-	db = nil
-	runtime.GC()
+	workers := s.workers()
+	window := len(workers) * NEval
+
+	// CHECKPOINT
+	var state checkpointState
+	var resumed bool
+
+	if s.Checkpoint != nil {
+
+		if state.Fingerprint, err = checkpointFingerprint(r, db, window); err != nil {
+			return partial, fmt.Errorf("checkpointFingerprint: %w", err)
+		}
 
-	for i := range res {
-		s.PrintDebug(fmt.Sprintf("Repack f(xi) [%d]", i), res[i], float64(Scaling))
+		if resumed, err = s.Checkpoint.resume(&state, window, rows); err != nil {
+			return partial, fmt.Errorf("s.Checkpoint.resume: %w", err)
+		}
+
+		if resumed {
+			count = &state.Count
+			s.Policies.addCounts(state.Policies)
+		}
 	}
 
-	// RING MERGING
-	var resMerged []*rlwe.Ciphertext
-	if resMerged, err = s.RingMerging(res); err != nil {
-		return nil, fmt.Errorf("s.RingMerging: %w", err)
+	// save saves the state of the request once the stage of the chunks of rows starting at row is completed
+	save := func(row int, stage Stage, folded int, cts []*rlwe.Ciphertext) (err error) {
+
+		if s.Checkpoint == nil {
+			return
+		}
+
+		state.Row, state.Stage, state.Folded, state.Count = row, stage, folded, *count
+		state.Policies = s.Policies.counts()
+
+		state.Cts = make(structs.Vector[rlwe.Ciphertext], len(cts))
+		for i := range cts {
+			state.Cts[i] = *cts[i]
+		}
+
+		if err = s.Checkpoint.save(&state); err != nil {
+			return fmt.Errorf("s.Checkpoint.save: %w", err)
+		}
+
+		return
 	}
 
-	for i := range resMerged {
-		s.PrintDebug(fmt.Sprintf("Merged f(xi) [%d]", i), resMerged[i], float64(Scaling))
+	for i := state.Row; i < rows; i += window {
+
+		end := utils.Min(i+window, rows)
+
+		var res, merged []*rlwe.Ciphertext
+		var folded int
+
+		if resumed {
+
+			cts := make([]*rlwe.Ciphertext, len(state.Cts))
+			for j := range cts {
+				cts[j] = &state.Cts[j]
+			}
+
+			switch state.Stage {
+			case StageLookupPacking:
+				res = cts
+			case StageMerging:
+				merged = cts
+			case StageSchemeSwitching:
+				merged, folded = cts, state.Folded
+			}
+
+			resumed = false
+		}
+
+		if merged == nil {
+
+			if res == nil {
+
+				// ENCRYPTED LOOKUP-TABLES
+				// RING-PACKING
+				res = make([]*rlwe.Ciphertext, (end-i+NPack-1)/NPack)
+
+				if err = runParallel(ctx, workers, len(res), func(ctx context.Context, s Server, j int) (err error) {
+
+					start := i + j*NPack
+
+					if res[j], err = s.EncryptedLookupTablesAndRingPacking(ctx, db, r.TestVectors, start, utils.Min(start+NPack, end), batch); err != nil {
+						return fmt.Errorf("s.EncryptedLookupTablesAndRingPacking: %w", err)
+					}
+
+					s.PrintDebug(fmt.Sprintf("Repack f(xi) [%d]", start/NPack), res[j], float64(Scaling))
+
+					return
+				}); err != nil {
+					return partial, err
+				}
+
+				if err = save(i, StageLookupPacking, 0, res); err != nil {
+					return partial, err
+				}
+			}
+
+			// RING MERGING
+			merged = make([]*rlwe.Ciphertext, (len(res)+ratio-1)/ratio)
+
+			if err = runParallel(ctx, workers, len(merged), func(ctx context.Context, s Server, j int) (err error) {
+
+				if merged[j], err = s.RingMerging(ctx, res[j*ratio:utils.Min((j+1)*ratio, len(res))]); err != nil {
+					return fmt.Errorf("s.RingMerging: %w", err)
+				}
+
+				s.PrintDebug(fmt.Sprintf("Merged f(xi) [%d]", i/NEval+j), merged[j], float64(Scaling))
+
+				return
+			}); err != nil {
+				return partial, err
+			}
+
+			// The packed ciphertexts are not needed anymore
+			res = nil
+
+			if err = save(i, StageMerging, 0, merged); err != nil {
+				return partial, err
+			}
+		}
+
+		// SCHEME-SWITCHING
+		// LOCAL-THRESHOLD
+		counts := make([]*rlwe.Ciphertext, len(merged)-folded)
+
+		if err = runParallel(ctx, workers, len(counts), func(ctx context.Context, s Server, j int) (err error) {
+
+			// The scheme-switching modifies its input, which must stay
+			// unchanged in the checkpoint until it is added to the count
+			input := merged[folded+j]
+			if s.Checkpoint != nil {
+				input = input.CopyNew()
+			}
+
+			counts[j] = hefloat.NewCiphertext(paramsEval, 1, paramsEval.MaxLevel())
+
+			if err = s.SchemeSwitchingAndLocalThreshold(ctx, i/NEval+folded+j, input, t0, c, counts[j]); err != nil {
+				return fmt.Errorf("s.SchemeSwitchingAndLocalThreshold: %w", err)
+			}
+
+			return
+		}); err != nil {
+			return partial, err
+		}
+
+		// The local thresholds are summed in the order of the
+		// rows, so that the result does not depend on s.Workers
+		for j := range counts {
+
+			if err = s.GetEvaluator().Add(count, counts[j], count); err != nil {
+				return partial, fmt.Errorf("eval.Add: %w", err)
+			}
+
+			if err = save(i, StageSchemeSwitching, folded+j+1, merged); err != nil {
+				return partial, err
+			}
+		}
 	}
 
-	// SCHEME-SWITCHING
-	// LOCAL-THRESHOLD
 	// AGGREGATION
-	t0 := r.PrivateThreshold0.Threshold
-	c := r.PrivateThreshold0.Normalization
-	if score, err = s.SchemeSwitchingAndAggregatedLocalThreshold(resMerged, t0, c); err != nil {
-		return nil, fmt.Errorf("s.SchemeSwitchingAndAggregatedLocalThreshold: %w", err)
+	if err = s.InnerSum(ctx, count); err != nil {
+		return partial, fmt.Errorf("s.InnerSum: %w", err)
+	}
+
+	if err = s.ExcludeRows(ctx, count, t0, c, s.excludedRows(rows)); err != nil {
+		return partial, fmt.Errorf("s.ExcludeRows: %w", err)
+	}
+
+	s.PrintDebug("Aggregated Local-Threshold", count, 1.0)
+
+	if s.Checkpoint != nil {
+		if err = s.Checkpoint.Remove(); err != nil {
+			return partial, fmt.Errorf("s.Checkpoint.Remove: %w", err)
+		}
+	}
+
+	policies.addCounts(s.Policies.counts())
+
+	return PartialCount{Hospital: s.Hospital, Count: count, Rows: rows}, nil
+}
+
+// LookupBatchSize returns the number of rows whose lookup tables are buffered
+// at once by each worker in Server.EncryptedLookupTablesAndRingPacking to keep
+// the ciphertexts of the chunks of rows evaluated in parallel within s.MemoryBudget.
+func (s Server) LookupBatchSize() (batch int, err error) {
+
+	paramsPack := s.ParamsPack
+	paramsEval := s.ParamsEval
+
+	NPack := paramsPack.N()
+
+	if s.MemoryBudget == 0 {
+		return NPack, nil
+	}
+
+	workers := utils.Max(s.Workers, 1)
+
+	sizePack := hefloat.NewCiphertext(paramsPack, 1, paramsPack.MaxLevel()).BinarySize()
+	sizeEval := hefloat.NewCiphertext(paramsEval, 1, paramsEval.MaxLevel()).BinarySize()
+
+	// For each worker: the packed ciphertexts of a chunk and the intermediate
+	// ciphertexts of their merging, the accumulator of the packing, the local
+	// threshold and the two outputs of the scheme-switching.
+	fixed := workers * (2*(paramsEval.N()/NPack)*sizePack + sizePack + 3*sizeEval)
+
+	// The packing needs at least two ciphertexts
+	if min := fixed + 2*workers*sizePack; s.MemoryBudget < min {
+		return 0, fmt.Errorf("MemoryBudget=%d is smaller than the minimum of %d bytes for %d workers", s.MemoryBudget, min, workers)
+	}
+
+	return utils.Min((s.MemoryBudget-fixed)/(workers*sizePack), NPack), nil
+}
+
+// ShallowCopy creates a shallow copy of the server in which the keys are shared
+// with the receiver. The returned server can be used concurrently with the receiver.
+func (s Server) ShallowCopy() Server {
+
+	cpy := s
+
+	if s.EvalRepack != nil {
+		cpy.EvalRepack = s.EvalRepack.ShallowCopy()
+	}
+
+	if s.Bootstrapper != nil {
+		cpy.Bootstrapper = s.Bootstrapper.ShallowCopy()
 	}
 
-	s.PrintDebug("Aggregated Local-Threshold", score, 1.0)
+	if s.DecEval != nil {
+		cpy.DecEval = s.DecEval.ShallowCopy()
+		cpy.EcdEval = s.EcdEval.ShallowCopy()
+	}
 
-	// GLOBAL THRESHOLD
-	if score, err = s.GlobalThreshold(score, r.PrivateThreshold1.Threshold, rows); err != nil {
-		return nil, fmt.Errorf("s.GlobalThreshold: %w", err)
+	if s.DecPack != nil {
+		cpy.DecPack = s.DecPack.ShallowCopy()
+		cpy.EcdPack = s.EcdPack.ShallowCopy()
 	}
 
-	s.PrintDebug("Global Threshold", score, 1.0)
+	return cpy
+}
+
+// workers returns the s.Workers servers evaluating a request: the receiver
+// and shallow copies of the receiver.
+func (s Server) workers() (workers []Server) {
 
-	return score, nil
+	workers = make([]Server, utils.Max(s.Workers, 1))
+	workers[0] = s
+
+	for i := 1; i < len(workers); i++ {
+		workers[i] = s.ShallowCopy()
+	}
+
+	return
+}
+
+// runParallel calls f(ctx, s, i) for each i in [0, n) on one goroutine per worker,
+// where s is the worker running the call, until ctx is done. The context passed to f
+// is canceled on the first error, so that the other calls stop at their next check
+// of the context. It returns the error of the smallest i which is not caused by
+// this cancellation, so that the error depends as little as possible on the
+// scheduling of the goroutines.
+func runParallel(ctx context.Context, workers []Server, n int, f func(ctx context.Context, s Server, i int) (err error)) (err error) {
+
+	parent := ctx
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, n)
+
+	call := func(s Server, i int) (err error) {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		if err = f(ctx, s, i); err != nil {
+			cancel()
+		}
+
+		return
+	}
+
+	if len(workers) == 1 {
+		for i := 0; i < n; i++ {
+			if err = call(workers[0], i); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func(s Server) {
+			defer wg.Done()
+			for j := range jobs {
+				errs[j] = call(s, j)
+			}
+		}(workers[i])
+	}
+
+	// The remaining calls are not dispatched once ctx is done
+	var dispatched int
+	for ; dispatched < n && ctx.Err() == nil; dispatched++ {
+		jobs <- dispatched
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	var canceled error
+	for i := range errs {
+
+		if errs[i] == nil {
+			continue
+		}
+
+		// The calls stopped by the cancellation of an other call
+		if parent.Err() == nil && errors.Is(errs[i], context.Canceled) {
+			if canceled == nil {
+				canceled = errs[i]
+			}
+			continue
+		}
+
+		return errs[i]
+	}
+
+	if canceled != nil {
+		return canceled
+	}
+
+	if dispatched < n {
+		return ctx.Err()
+	}
+
+	return
+}
+
+// setup returns a copy of the server instantiated for the request.
+func (s Server) setup(cfg Config, r Request, btp Bootstrapper) (Server, error) {
+
+	if r.EvaluationKeys == nil {
+		return s, fmt.Errorf("missing the evaluation keys")
+	}
+
+	if err := cfg.CheckParameters(r.Parameters); err != nil {
+		return s, fmt.Errorf("cfg.CheckParameters: %w", err)
+	}
+
+	s.Config = cfg
+	s.Bootstrapper = btp
+
+	if r.PrivateThreshold0 != nil {
+		s.localThreshold = cfg.LocalThresholdPolynomial(r.PrivateThreshold0.Max)
+	}
+
+	s.ParamsPack = hefloat.Parameters{Parameters: ckks.Parameters{Parameters: *r.Parameters[cfg.LogNPack].GetRLWEParameters()}}
+	s.ParamsEval = hefloat.Parameters{Parameters: ckks.Parameters{Parameters: *r.Parameters[cfg.LogNEval].GetRLWEParameters()}}
+
+	s.EvalRepack = NewRepackEvaluator(&r.EvaluationKeys.RepackEvaluationKeySet)
+
+	if sk, ok := s.SkDebug[cfg.LogNEval]; ok {
+		s.DecEval = hefloat.NewDecryptor(s.ParamsEval, sk)
+		s.EcdEval = hefloat.NewEncoder(s.ParamsEval)
+	}
+
+	if sk, ok := s.SkDebug[cfg.LogNPack]; ok {
+		s.DecPack = hefloat.NewDecryptor(s.ParamsPack, sk)
+		s.EcdPack = hefloat.NewEncoder(s.ParamsPack)
+	}
+
+	return s, nil
 }
 
 func (s Server) PrintDebug(msg string, input *rlwe.Ciphertext, scaling float64) {
@@ -134,112 +572,175 @@ func (s Server) PrintDebug(msg string, input *rlwe.Ciphertext, scaling float64)
 	fmt.Printf("%s: %v\n", msg, v)
 }
 
-func (s Server) SchemeSwitchingAndAggregatedLocalThreshold(resMerged []*rlwe.Ciphertext, t0, c *rlwe.Ciphertext) (score *rlwe.Ciphertext, err error) {
+// SchemeSwitchingAndLocalThreshold scheme-switches the i-th merged ciphertext
+// and adds the local threshold of its two halves to score.
+func (s Server) SchemeSwitchingAndLocalThreshold(ctx context.Context, i int, merged, t0, c, score *rlwe.Ciphertext) (err error) {
 
-	paramsEval := s.ParamsEval
-	score = hefloat.NewCiphertext(paramsEval, 1, paramsEval.MaxLevel())
+	if err = ctx.Err(); err != nil {
+		return
+	}
 
 	btp := s.Bootstrapper
-	eval := btp.GetEvaluator()
 
-	for i := range resMerged {
+	var real, imag *rlwe.Ciphertext
 
-		ri := resMerged[i]
+	if err = RunTimed(fmt.Sprintf("Scheme-Switch ct[%d]", i), func() (err error) {
+		if real, imag, err = btp.SchemeSwitch(merged); err != nil {
+			return fmt.Errorf("btp.SchemeSwitch: %w", err)
+		}
+		return
+	}); err != nil {
+		return
+	}
 
-		var real, imag *rlwe.Ciphertext
+	s.PrintDebug(fmt.Sprintf("Scheme-Switch ct[%d][:N/2]", i), real, 1.0)
+	s.PrintDebug(fmt.Sprintf("Scheme-Switch ct[%d][N/2:]", i), imag, 1.0)
 
-		if err = RunTimed(fmt.Sprintf("Scheme-Switch ct[%d]", i), func() (err error) {
-			if real, imag, err = btp.SchemeSwitch(ri); err != nil {
-				return fmt.Errorf("btp.SchemeSwitch: %w", err)
-			}
-			return
-		}); err != nil {
-			return nil, err
+	if err = RunTimed(fmt.Sprintf("Local-Threshold: ct[%d] (real)", i), func() (err error) {
+		if err = s.LocalThreshold(real, t0, c, score); err != nil {
+			return fmt.Errorf("s.LocalThreshold: %w", err)
 		}
+		return
+	}); err != nil {
+		return
+	}
 
-		s.PrintDebug(fmt.Sprintf("Scheme-Switch ct[%d][:N/2]", i), real, 1.0)
-		s.PrintDebug(fmt.Sprintf("Scheme-Switch ct[%d][N/2:]", i), imag, 1.0)
+	s.PrintDebug(fmt.Sprintf("Score + Local-Threshold ct[%d][:N/2]", i), score, 1.0)
 
-		if err = RunTimed(fmt.Sprintf("Local-Threshold: ct[%d] (real)", i), func() (err error) {
-			if err = s.LocalThreshold(real, t0, c, score); err != nil {
-				return fmt.Errorf("s.LocalThreshold: %w", err)
-			}
-			return
-		}); err != nil {
-			return nil, err
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	if err = RunTimed(fmt.Sprintf("Local-Threshold: ct[%d] (imag)", i), func() (err error) {
+		if err = s.LocalThreshold(imag, t0, c, score); err != nil {
+			return fmt.Errorf("s.LocalThreshold: %w", err)
 		}
+		return
+	}); err != nil {
+		return
+	}
 
-		s.PrintDebug(fmt.Sprintf("Score + Local-Threshold ct[%d][:N/2]", i), score, 1.0)
+	s.PrintDebug(fmt.Sprintf("Score + Local-Threshold ct[%d][N/2:]", i), score, 1.0)
 
-		if err = RunTimed(fmt.Sprintf("Local-Threshold: ct[%d] (imag)", i), func() (err error) {
-			if err = s.LocalThreshold(imag, t0, c, score); err != nil {
-				return fmt.Errorf("s.LocalThreshold: %w", err)
-			}
-			return
-		}); err != nil {
-			return nil, err
-		}
+	s.progress.step(StageSchemeSwitching)
 
-		s.PrintDebug(fmt.Sprintf("Score + Local-Threshold ct[%d][N/2:]", i), score, 1.0)
+	return
+}
+
+// InnerSum sums the slots of score, i.e. the local thresholds of all the rows.
+func (s Server) InnerSum(ctx context.Context, score *rlwe.Ciphertext) (err error) {
+
+	if err = ctx.Err(); err != nil {
+		return
 	}
 
-	// InnerSum
 	if err = RunTimed("Inner-Sum", func() (err error) {
-		if err = eval.InnerSum(score, 1, score.Slots(), score); err != nil {
-			return fmt.Errorf("cmpEval.InnerSum: %w", err)
+		if err = s.GetEvaluator().InnerSum(score, 1, score.Slots(), score); err != nil {
+			return fmt.Errorf("eval.InnerSum: %w", err)
 		}
 		return
 	}); err != nil {
-		return nil, err
+		return
 	}
 
+	s.progress.step(StageInnerSum)
+
 	return
 }
 
-func (s Server) RingMerging(res []*rlwe.Ciphertext) (resMerged []*rlwe.Ciphertext, err error) {
-
-	paramsPack := s.ParamsPack
-	paramsEval := s.ParamsEval
+// excludedRows returns the number of rows of score zero of a request on the given
+// number of rows, see Server.ExcludeRows: the padding of the last chunk of rows and
+// the rows skipped by PolicySkip, counted in s.Policies.
+func (s Server) excludedRows(rows int) int {
+	NEval := s.ParamsEval.N()
+	return (rows+NEval-1)/NEval*NEval - rows + int(s.Policies.Skipped.Load())
+}
 
-	if err = RunTimed(fmt.Sprintf("Ring Merging LogN%d x %d -> LogN%d x %d", paramsPack.LogN(), len(res), paramsEval.LogN(), len(resMerged)), func() (err error) {
+// ExcludeRows subtracts from the count, after the inner sum, the local thresholds of
+// n rows of score zero: the padding of the last chunk of rows and the rows skipped by
+// PolicySkip, which the local threshold counts if t0 <= 1/2. The local threshold of
+// a score of zero is evaluated once, on an encryption of zero.
+func (s Server) ExcludeRows(ctx context.Context, count, t0, c *rlwe.Ciphertext, n int) (err error) {
 
-		ratio := paramsEval.N() / paramsPack.N()
+	if n == 0 {
+		return
+	}
 
-		resMerged = make([]*rlwe.Ciphertext, (len(res)+ratio-1)/ratio)
+	if err = ctx.Err(); err != nil {
+		return
+	}
 
-		for i := range resMerged {
+	eval := s.GetEvaluator()
 
-			start := i * ratio
-			end := start + ratio
+	zero := t0.CopyNew()
+	if err = eval.Sub(zero, t0, zero); err != nil {
+		return fmt.Errorf("eval.Sub: %w", err)
+	}
 
-			if end > len(res) {
-				end = len(res)
-			}
+	indicators := hefloat.NewCiphertext(s.ParamsEval, 1, s.ParamsEval.MaxLevel())
 
-			if resMerged[i], err = s.Merge(res[start:end], s.EvalRepack); err != nil {
-				return fmt.Errorf("eval.Merge: %w", err)
-			}
+	if err = RunTimed("Exclude-Rows", func() (err error) {
+		if err = s.LocalThreshold(zero, t0, c, indicators); err != nil {
+			return fmt.Errorf("s.LocalThreshold: %w", err)
 		}
+		return
+	}); err != nil {
+		return
+	}
+
+	if err = eval.Mul(indicators, n, indicators); err != nil {
+		return fmt.Errorf("eval.Mul: %w", err)
+	}
 
+	if err = eval.Sub(count, indicators, count); err != nil {
+		return fmt.Errorf("eval.Sub: %w", err)
+	}
+
+	return
+}
+
+// RingMerging merges the packed ciphertexts of a chunk of rows, at most
+// 2^{LogNEval-LogNPack}, into a single ciphertext of degree 2^{LogNEval}.
+func (s Server) RingMerging(ctx context.Context, res []*rlwe.Ciphertext) (merged *rlwe.Ciphertext, err error) {
+
+	paramsPack := s.ParamsPack
+	paramsEval := s.ParamsEval
+
+	if err = RunTimed(fmt.Sprintf("Ring Merging LogN%d x %d -> LogN%d", paramsPack.LogN(), len(res), paramsEval.LogN()), func() (err error) {
+		if merged, err = s.Merge(ctx, res, s.EvalRepack); err != nil {
+			return fmt.Errorf("eval.Merge: %w", err)
+		}
 		return
 	}); err != nil {
 		return nil, err
 	}
 
+	s.progress.step(StageMerging)
+
 	return
 }
 
-func (s Server) EncryptedLookupTablesAndRingPacking(db *Database, fi *TestVectors) (res []*rlwe.Ciphertext, err error) {
+// EncryptedLookupTablesAndRingPacking evaluates the test vectors on the rows
+// [start, end) of the database, at most 2^{LogNPack}, and packs them into a
+// single ciphertext of degree 2^{LogNPack}. The lookup tables are evaluated
+// and packed by batches of batch rows, and the packed batches are summed.
+func (s Server) EncryptedLookupTablesAndRingPacking(ctx context.Context, db *Database, fi *TestVectors, start, end, batch int) (res *rlwe.Ciphertext, err error) {
 
 	eval := s.EvalRepack
 	paramsPack := s.ParamsPack
-	rows, cols := db.Dims()
+	N := paramsPack.N()
 
-	if err = RunTimed(fmt.Sprintf("Evaluating %dx%d TestVectors & Packing", rows, cols), func() (err error) {
+	if end-start > N {
+		return nil, fmt.Errorf("cannot pack %d rows into a ciphertext of degree %d", end-start, N)
+	}
+
+	if batch < 2 {
+		return nil, fmt.Errorf("invalid batch: %d < 2", batch)
+	}
 
-		N := paramsPack.N()
+	if err = RunTimed(fmt.Sprintf("Evaluating [%d, %d)x%d TestVectors & Packing", start, end, len(*fi)), func() (err error) {
 
-		buffCts := make([]*rlwe.Ciphertext, N)
+		buffCts := make([]*rlwe.Ciphertext, utils.Min(batch, end-start))
 
 		for i := range buffCts {
 			buffCts[i] = hefloat.NewCiphertext(paramsPack, 1, paramsPack.MaxLevel())
@@ -247,34 +748,53 @@ func (s Server) EncryptedLookupTablesAndRingPacking(db *Database, fi *TestVector
 
 		buffPoly := paramsPack.RingQ().NewPoly()
 
-		res = make([]*rlwe.Ciphertext, (rows+N-1)/N)
+		ringQ := paramsPack.RingQ().AtLevel(paramsPack.MaxLevel())
+
+		res = hefloat.NewCiphertext(paramsPack, 1, paramsPack.MaxLevel())
 
-		for i := range res {
+		for i := 0; i < end-start; i += batch {
 
+			if err = ctx.Err(); err != nil {
+				return
+			}
+
+			// The batch is packed from X^{0}, since the packing only
+			// zeroes the garbage slots of the ciphertexts above X^{0}
 			tmp := map[int]*rlwe.Ciphertext{}
 
-			for j := 0; j < utils.Min(N, rows-i*N); j++ {
+			for j := 0; j < utils.Min(batch, end-start-i); j++ {
 
-				if err = fi.Evaluate(paramsPack, db.GetRow(i*N+j), buffPoly, buffCts[j]); err != nil {
+				var applied policySet
+				if applied, err = fi.evaluate(paramsPack, db.GetRow(start+i+j), buffPoly, buffCts[j]); err != nil {
 					return fmt.Errorf("fi.Evaluate: %w", err)
 				}
 
+				s.Policies.add(applied)
+
 				tmp[j] = buffCts[j]
 			}
 
-			if _, err = eval.Pack(tmp); err != nil {
+			// The packing needs at least two ciphertexts
+			if len(tmp) == 1 {
+				tmp[1] = hefloat.NewCiphertext(paramsPack, 1, paramsPack.MaxLevel())
+			}
+
+			var ct *rlwe.Ciphertext
+			if ct, err = eval.Pack(tmp); err != nil {
 				return fmt.Errorf("eval.Pack: %w", err)
 			}
 
-			res[i] = tmp[0].CopyNew()
-		}
+			*res.MetaData = *ct.MetaData
+
+			// res += ct * X^{i}
+			buffPoly.Zero()
+			for k := range buffPoly.Coeffs {
+				buffPoly.Coeffs[k][i] = 1
+			}
+			ringQ.NTT(buffPoly, buffPoly)
 
-		for i := range res {
-			ri := res[i]
-			ri.IsBatched = false
-			ri.Scale = paramsPack.DefaultScale()
-			ri.LogDimensions.Rows = 0
-			ri.LogDimensions.Cols = paramsPack.LogN() - 1
+			ringQ.MulCoeffsBarrettThenAdd(ct.Value[0], buffPoly, res.Value[0])
+			ringQ.MulCoeffsBarrettThenAdd(ct.Value[1], buffPoly, res.Value[1])
 		}
 
 		return nil
@@ -282,12 +802,22 @@ func (s Server) EncryptedLookupTablesAndRingPacking(db *Database, fi *TestVector
 		return nil, err
 	}
 
+	res.IsBatched = false
+	res.Scale = paramsPack.DefaultScale()
+	res.LogDimensions.Rows = 0
+	res.LogDimensions.Cols = paramsPack.LogN() - 1
+
+	s.progress.step(StageLookupPacking)
+
 	return
 }
 
+// LocalThreshold adds Step((input - t0 + 0.5) * c) to output, evaluated with the
+// sign polynomial of Config.LocalThresholdPolynomial for the PrivateThreshold0.Max
+// of the request. The input is modified.
 func (s Server) LocalThreshold(input, t0, c *rlwe.Ciphertext, output *rlwe.Ciphertext) (err error) {
 
-	polysThreshold0 := hefloat.NewMinimaxCompositePolynomial(MinimaxCompositePolynomialForSignThreshold0)
+	polysThreshold0 := s.localThreshold.Polynomial()
 
 	eval := hefloat.NewComparisonEvaluator(s.ParamsEval, s.GetEvaluator(), s.Bootstrapper, polysThreshold0)
 
@@ -318,11 +848,18 @@ func (s Server) LocalThreshold(input, t0, c *rlwe.Ciphertext, output *rlwe.Ciphe
 	return
 }
 
-func (s Server) GlobalThreshold(input, t1 *rlwe.Ciphertext, rows int) (output *rlwe.Ciphertext, err error) {
+// GlobalThreshold returns Step((input - t1 + 0.5) / rows), evaluated with the sign
+// polynomial of Config.GlobalThresholdPolynomial for the number of rows, which is
+// generated on its first use. The input is modified.
+func (s Server) GlobalThreshold(ctx context.Context, input, t1 *rlwe.Ciphertext, rows int) (output *rlwe.Ciphertext, err error) {
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
 
 	if err = RunTimed("Global-Threshold", func() (err error) {
 
-		polysThreshold1 := hefloat.NewMinimaxCompositePolynomial(MinimaxCompositePolynomialForSignThreshold1)
+		polysThreshold1 := s.Config.GlobalThresholdPolynomial(rows).Polynomial()
 
 		eval := hefloat.NewComparisonEvaluator(s.ParamsEval, s.GetEvaluator(), s.Bootstrapper, polysThreshold1)
 
@@ -361,13 +898,18 @@ func (s Server) GlobalThreshold(input, t1 *rlwe.Ciphertext, rows int) (output *r
 	return
 }
 
-func (s Server) Merge(cts []*rlwe.Ciphertext, eval *RepackEvaluator) (ct *rlwe.Ciphertext, err error) {
+func (s Server) Merge(ctx context.Context, cts []*rlwe.Ciphertext, eval *RepackEvaluator) (ct *rlwe.Ciphertext, err error) {
 
 	if len(cts) > 1<<(eval.MaxLogN()-bits.Len64(uint64(len(cts[0].Value[0].Coeffs[0])-1))) {
 		return nil, fmt.Errorf("too many ciphertexts")
 	}
 
 	for len(cts) != 1 {
+
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
 		for i := 0; i < len(cts)>>1; i++ {
 			if cts[i], err = eval.MergeNew(cts[2*i], cts[2*i+1]); err != nil {
 				return nil, fmt.Errorf("eval.MergeNew(cts[2*i], cts[2*i+1]): %w", err)
@@ -0,0 +1,42 @@
+package pde
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunParallel(t *testing.T) {
+
+	workers := make([]Server, 4)
+
+	t.Run("CancelOnError", func(t *testing.T) {
+
+		failure := errors.New("failure")
+
+		// The other calls wait for the cancellation of their context
+		err := runParallel(context.Background(), workers, 64, func(ctx context.Context, s Server, i int) (err error) {
+			if i == 3 {
+				return failure
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		require.ErrorIs(t, err, failure)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := runParallel(ctx, workers, 64, func(ctx context.Context, s Server, i int) (err error) {
+			return nil
+		})
+
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,699 @@
+package pde
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/utils/buffer"
+)
+
+const (
+	// RouteKeys is the route on which clients register their evaluation keys.
+	RouteKeys = "/keys"
+
+	// RouteSchema is the route returning the schema of the database.
+	RouteSchema = "/schema"
+
+	// RouteQueries is the route on which clients submit their queries.
+	RouteQueries = "/queries"
+
+	// RouteJobs is the route prefix of the status, RouteJobs+"{id}",
+	// and of the result, RouteJobs+"{id}/result", of the jobs.
+	// A DELETE on RouteJobs+"{id}" cancels the job.
+	RouteJobs = "/jobs/"
+
+	// DefaultMaxKeySize is the default maximum size in bytes of registered evaluation keys.
+	// The bootstrapping keys of Profile128 are a few gigabytes.
+	DefaultMaxKeySize = 1 << 34
+
+	// DefaultMaxQuerySize is the default maximum size in bytes of a query.
+	DefaultMaxQuerySize = 1 << 30
+)
+
+// ErrUnknownKey is returned when a query references evaluation keys that were not registered.
+var ErrUnknownKey = errors.New("unknown key ID")
+
+// ErrUnknownJob is returned when a job ID was not issued by the service.
+var ErrUnknownJob = errors.New("unknown job ID")
+
+// ErrJobNotDone is returned when the result of a job that is not done is requested.
+var ErrJobNotDone = errors.New("job not done")
+
+// Query is a request submitted to a Service, evaluated with the evaluation
+// keys registered under KeyID. The Request must not carry evaluation keys.
+type Query struct {
+	KeyID   string
+	Request Request
+}
+
+// BinarySize returns the serialized size of the object in bytes.
+func (q Query) BinarySize() (size int) {
+	return headerSize + 8 + len(q.KeyID) + q.Request.BinarySize()
+}
+
+// WriteTo writes the object on an io.Writer. It implements the io.WriterTo
+// interface, and will write exactly object.BinarySize() bytes on w.
+func (q Query) WriteTo(w io.Writer) (n int64, err error) {
+	switch w := w.(type) {
+	case buffer.Writer:
+
+		var inc int64
+		if inc, err = writeHeader(w, kindQuery); err != nil {
+			return n + inc, fmt.Errorf("writeHeader: %w", err)
+		}
+		n += inc
+
+		if inc, err = writeBytes(w, []byte(q.KeyID)); err != nil {
+			return n + inc, fmt.Errorf("writeBytes: %w", err)
+		}
+		n += inc
+
+		if inc, err = q.Request.WriteTo(w); err != nil {
+			return n + inc, fmt.Errorf("q.Request.WriteTo: %w", err)
+		}
+		n += inc
+
+		return n, w.Flush()
+
+	default:
+		return q.WriteTo(bufio.NewWriter(w))
+	}
+}
+
+// ReadFrom reads on the object from an io.Reader. It implements the
+// io.ReaderFrom interface.
+func (q *Query) ReadFrom(r io.Reader) (n int64, err error) {
+	switch r := r.(type) {
+	case buffer.Reader:
+
+		var inc int64
+		if inc, err = readHeader(r, kindQuery); err != nil {
+			return n + inc, fmt.Errorf("readHeader: %w", err)
+		}
+		n += inc
+
+		var keyID []byte
+		if inc, err = readBytes(r, &keyID); err != nil {
+			return n + inc, fmt.Errorf("readBytes: %w", err)
+		}
+		n += inc
+
+		q.KeyID = string(keyID)
+
+		if inc, err = q.Request.ReadFrom(r); err != nil {
+			return n + inc, fmt.Errorf("q.Request.ReadFrom: %w", err)
+		}
+		n += inc
+
+		return
+
+	default:
+		return q.ReadFrom(bufio.NewReader(r))
+	}
+}
+
+// JobState is the state of a job.
+type JobState string
+
+const (
+	JobPending  = JobState("pending")
+	JobRunning  = JobState("running")
+	JobDone     = JobState("done")
+	JobFailed   = JobState("failed")
+	JobCanceled = JobState("canceled")
+)
+
+// JobStatus is the public status of a job.
+type JobStatus struct {
+	ID        string    `json:"id"`
+	KeyID     string    `json:"key_id"`
+	State     JobState  `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	Submitted time.Time `json:"submitted"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished"`
+
+	// Progress is the last progress event of a running job.
+	Progress *Progress `json:"progress,omitempty"`
+}
+
+// ServiceParameters is a struct storing the limits of a Service.
+// Zero values are replaced by their default.
+type ServiceParameters struct {
+	// MaxKeySize is the maximum size in bytes of registered evaluation keys.
+	MaxKeySize int64
+	// MaxQuerySize is the maximum size in bytes of a query.
+	MaxQuerySize int64
+	// MaxConcurrentJobs is the number of jobs evaluated concurrently,
+	// additional jobs stay pending. Defaults to 1, since a job over
+	// the parameters of Profile128 needs about 22GB of RAM.
+	MaxConcurrentJobs int
+	// PublicGrids requires the test vectors of the queries to be tabulated on the
+	// public grids of the schema of the database, see Schema.CheckTestVectors, so
+	// that the selection criteria are only encoded in encrypted coefficients.
+	PublicGrids bool
+}
+
+// Service is a struct exposing a Server and the database of a hospital
+// over HTTP. Clients register their evaluation keys once and then submit
+// queries, which are evaluated asynchronously as jobs. It implements
+// http.Handler.
+type Service struct {
+	ServiceParameters
+	Config   Config
+	Server   Server
+	Database *Database
+
+	mux   *http.ServeMux
+	slots chan struct{}
+
+	mu   sync.RWMutex
+	keys map[string]*serviceKeys
+	jobs map[string]*serviceJob
+
+	// The stages of a job, which the tests replace by cheaper ones
+	newBootstrapper func(r Request) (Bootstrapper, error)
+	process         func(ctx context.Context, s Server, r Request, db *Database, btp Bootstrapper) (*rlwe.Ciphertext, error)
+}
+
+type serviceKeys struct {
+	// mu serializes the jobs using the keys, since the bootstrapper is not safe for concurrent use.
+	mu  sync.Mutex
+	evk *EvaluationKeys
+	btp Bootstrapper
+}
+
+type serviceJob struct {
+	status   JobStatus
+	result   *rlwe.Ciphertext
+	cancel   context.CancelFunc
+	policies PolicyCounts
+}
+
+// NewService instantiates a new Service evaluating the queries on the database
+// with the configuration cfg, which the keys of the clients must match.
+func NewService(cfg Config, server Server, db *Database, sp ServiceParameters) *Service {
+
+	if sp.MaxKeySize == 0 {
+		sp.MaxKeySize = DefaultMaxKeySize
+	}
+
+	if sp.MaxQuerySize == 0 {
+		sp.MaxQuerySize = DefaultMaxQuerySize
+	}
+
+	if sp.MaxConcurrentJobs == 0 {
+		sp.MaxConcurrentJobs = 1
+	}
+
+	s := &Service{
+		ServiceParameters: sp,
+		Config:            cfg,
+		Server:            server,
+		Database:          db,
+		slots:             make(chan struct{}, sp.MaxConcurrentJobs),
+		keys:              map[string]*serviceKeys{},
+		jobs:              map[string]*serviceJob{},
+		newBootstrapper: func(r Request) (Bootstrapper, error) {
+			return server.NewBootstrapper(cfg, r)
+		},
+		process: func(ctx context.Context, s Server, r Request, db *Database, btp Bootstrapper) (*rlwe.Ciphertext, error) {
+			return s.ProcessRequest(ctx, cfg, r, db, btp)
+		},
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST "+RouteKeys, s.handleKeys)
+	s.mux.HandleFunc("GET "+RouteSchema, s.handleSchema)
+	s.mux.HandleFunc("POST "+RouteQueries, s.handleQueries)
+	s.mux.HandleFunc("GET "+RouteJobs+"{id}", s.handleStatus)
+	s.mux.HandleFunc("GET "+RouteJobs+"{id}/result", s.handleResult)
+	s.mux.HandleFunc("DELETE "+RouteJobs+"{id}", s.handleCancel)
+
+	return s
+}
+
+// RegisterKeys checks and stores the evaluation keys and returns their key ID.
+// The bootstrapper is instantiated from the keys by the first job using them.
+func (s *Service) RegisterKeys(evk *EvaluationKeys) (id string, err error) {
+
+	if evk == nil {
+		return "", fmt.Errorf("evaluation keys cannot be nil")
+	}
+
+	if err = s.Config.CheckParameters(evk.Parameters); err != nil {
+		return "", fmt.Errorf("invalid evaluation keys: %w", err)
+	}
+
+	if _, ok := evk.RepackKeys[s.Config.LogNPack]; !ok {
+		return "", fmt.Errorf("invalid evaluation keys: missing the repacking keys of LogN=%d", s.Config.LogNPack)
+	}
+
+	if id, err = newID(); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.keys[id] = &serviceKeys{evk: evk}
+	s.mu.Unlock()
+
+	return
+}
+
+// Schema returns the schema of the database, nil for synthetic databases.
+func (s *Service) Schema() Schema {
+	return s.Database.Schema
+}
+
+// Submit checks the query and starts a job evaluating it on the
+// database with the registered evaluation keys. It returns the
+// ID of the job, see Status and Result.
+func (s *Service) Submit(q Query) (id string, err error) {
+
+	s.mu.RLock()
+	keys, ok := s.keys[q.KeyID]
+	s.mu.RUnlock()
+
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	if err = s.checkRequest(q.Request, keys.evk); err != nil {
+		return "", err
+	}
+
+	if id, err = newID(); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	job := &serviceJob{
+		status: JobStatus{
+			ID:        id,
+			KeyID:     q.KeyID,
+			State:     JobPending,
+			Submitted: time.Now(),
+		},
+		cancel: cancel,
+	}
+
+	s.mu.Lock()
+	s.jobs[id] = job
+	s.mu.Unlock()
+
+	go s.run(ctx, job, keys, q.Request)
+
+	return
+}
+
+// Status returns the status of the job.
+func (s *Service) Status(id string) (status JobStatus, err error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return status, ErrUnknownJob
+	}
+
+	return job.status, nil
+}
+
+// Result returns the encrypted result of the job.
+func (s *Service) Result(id string) (score *rlwe.Ciphertext, err error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+
+	if job.status.State != JobDone {
+		return nil, fmt.Errorf("%w: job is %s", ErrJobNotDone, job.status.State)
+	}
+
+	return job.result, nil
+}
+
+// PolicyCounts returns the numbers of rows of the database whose values were handled
+// by the policies of the request of the job, see Server.Policies. They are only
+// available to the hospital, and are not served to the client.
+func (s *Service) PolicyCounts(id string) (counts *PolicyCounts, err error) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+
+	return &job.policies, nil
+}
+
+// Cancel cancels the job. A pending job is canceled before it starts and a
+// running job at its next check of the context, see Server.ProcessRequest.
+// Canceling a job that is done or failed has no effect.
+func (s *Service) Cancel(id string) (err error) {
+
+	s.mu.RLock()
+	job, ok := s.jobs[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		return ErrUnknownJob
+	}
+
+	job.cancel()
+
+	return
+}
+
+// run evaluates the request of the job once a slot is available, until ctx is done.
+func (s *Service) run(ctx context.Context, job *serviceJob, keys *serviceKeys, r Request) {
+
+	defer job.cancel()
+
+	select {
+	case s.slots <- struct{}{}:
+	case <-ctx.Done():
+		s.setJobState(job, JobCanceled, nil, ctx.Err())
+		return
+	}
+
+	defer func() { <-s.slots }()
+
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		s.setJobState(job, JobCanceled, nil, err)
+		return
+	}
+
+	s.setJobState(job, JobRunning, nil, nil)
+
+	// The progress of the job is reported in its status
+	server := s.Server
+	server.Policies = &job.policies
+	server.Observer = ObserverFunc(func(p Progress) {
+
+		if s.Server.Observer != nil {
+			s.Server.Observer.Observe(p)
+		}
+
+		s.mu.Lock()
+		job.status.Progress = &p
+		s.mu.Unlock()
+	})
+
+	score, err := func() (score *rlwe.Ciphertext, err error) {
+
+		// The evaluation panics on some invalid inputs (e.g. missing Galois keys)
+		defer func() {
+			if r := recover(); r != nil {
+				score = nil
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+
+		r.EvaluationKeys = keys.evk
+
+		if keys.btp == nil {
+			if keys.btp, err = s.newBootstrapper(r); err != nil {
+				return nil, fmt.Errorf("s.NewBootstrapper: %w", err)
+			}
+		}
+
+		return s.process(ctx, server, r, s.Database, keys.btp)
+	}()
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		s.setJobState(job, JobCanceled, nil, err)
+		return
+	case err != nil:
+		s.setJobState(job, JobFailed, nil, err)
+		return
+	}
+
+	s.setJobState(job, JobDone, score, nil)
+}
+
+func (s *Service) setJobState(job *serviceJob, state JobState, result *rlwe.Ciphertext, err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.status.State = state
+
+	switch state {
+	case JobRunning:
+		job.status.Started = time.Now()
+	case JobDone, JobFailed, JobCanceled:
+		job.status.Finished = time.Now()
+	}
+
+	if err != nil {
+		job.status.Error = err.Error()
+	}
+
+	job.result = result
+}
+
+// checkRequest checks that the request is well formed for the database and the evaluation keys.
+func (s *Service) checkRequest(r Request, evk *EvaluationKeys) (err error) {
+
+	if r.EvaluationKeys != nil {
+		return fmt.Errorf("invalid query: the evaluation keys must be registered, not sent with the query")
+	}
+
+	if r.TestVectors == nil || len(*r.TestVectors) == 0 {
+		return fmt.Errorf("invalid query: missing the test vectors")
+	}
+
+	_, cols := s.Database.Dims()
+
+	if _, err = r.TestVectors.withPolicies(s.Database.Schema); err != nil {
+		return fmt.Errorf("invalid query: %w", err)
+	}
+
+	if s.PublicGrids {
+
+		if s.Database.Schema == nil {
+			return fmt.Errorf("invalid query: the public grids require the schema of the database")
+		}
+
+		if err = s.Database.Schema.CheckTestVectors(*r.TestVectors); err != nil {
+			return fmt.Errorf("invalid query: %w", err)
+		}
+	}
+
+	paramsPack := evk.Parameters[s.Config.LogNPack]
+	paramsEval := evk.Parameters[s.Config.LogNEval]
+
+	checkCiphertext := func(ct *rlwe.Ciphertext, N, MaxLevel int) bool {
+		return ct != nil && ct.MetaData != nil && ct.Degree() == 1 && ct.Level() <= MaxLevel && ct.Value[0].N() == N && ct.Value[1].N() == N
+	}
+
+	for i, tv := range *r.TestVectors {
+
+		if len(tv.Value) == 0 || tv.Points <= 0 {
+			return fmt.Errorf("invalid query: TestVectors[%d] is empty", i)
+		}
+
+		// The missing index is after the grid
+		points := tv.Points
+		if tv.Policy == PolicyMissing {
+			points++
+		}
+
+		if points > len(tv.Value)*paramsPack.N() {
+			return fmt.Errorf("invalid query: TestVectors[%d] has %d points but %d ciphertexts", i, points, len(tv.Value))
+		}
+
+		columns := consumedColumns(tv.Columns, i)
+
+		for _, c := range columns {
+			if c < 0 || c >= cols {
+				return fmt.Errorf("invalid query: TestVectors[%d] consumes the column %d but the database has %d columns", i, c, cols)
+			}
+		}
+
+		if len(tv.Axes) == 0 && len(columns) != 1 || len(tv.Axes) != 0 && (len(columns) != len(tv.Axes) || !tv.Categorical || tv.Points != jointPoints(tv.Axes)) {
+			return fmt.Errorf("invalid query: TestVectors[%d] has invalid columns or axes", i)
+		}
+
+		for j := range tv.Value {
+			if !checkCiphertext(&tv.Value[j], paramsPack.N(), paramsPack.MaxLevel()) {
+				return fmt.Errorf("invalid query: TestVectors[%d][%d] is a malformed ciphertext", i, j)
+			}
+		}
+
+		if len(tv.Slope) != 0 && (tv.Categorical || len(tv.Slope) != len(tv.Value)) {
+			return fmt.Errorf("invalid query: TestVectors[%d] has an invalid slope", i)
+		}
+
+		for j := range tv.Slope {
+			if !checkCiphertext(&tv.Slope[j], paramsPack.N(), paramsPack.MaxLevel()) {
+				return fmt.Errorf("invalid query: TestVectors[%d].Slope[%d] is a malformed ciphertext", i, j)
+			}
+		}
+	}
+
+	if r.PrivateThreshold0 == nil || !checkCiphertext(r.PrivateThreshold0.Threshold, paramsEval.N(), paramsEval.MaxLevel()) || !checkCiphertext(r.PrivateThreshold0.Normalization, paramsEval.N(), paramsEval.MaxLevel()) {
+		return fmt.Errorf("invalid query: missing or malformed PrivateThreshold0")
+	}
+
+	if r.Privacy != nil {
+
+		if s.Server.Accountant == nil {
+			return fmt.Errorf("invalid query: the service does not answer noisy counts")
+		}
+
+		if err = s.Server.Accountant.Check(*r.Privacy); err != nil {
+			return fmt.Errorf("invalid query: %w", err)
+		}
+
+	} else if r.PrivateThreshold1 == nil || !checkCiphertext(r.PrivateThreshold1.Threshold, paramsEval.N(), paramsEval.MaxLevel()) {
+		return fmt.Errorf("invalid query: missing or malformed PrivateThreshold1")
+	}
+
+	return
+}
+
+func newID() (id string, err error) {
+
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		return "", fmt.Errorf("rand.Read: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Service) handleKeys(w http.ResponseWriter, r *http.Request) {
+
+	evk := &EvaluationKeys{}
+	if _, err := evk.ReadFrom(http.MaxBytesReader(w, r.Body, s.MaxKeySize)); err != nil {
+		httpError(w, fmt.Errorf("evk.ReadFrom: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.RegisterKeys(evk)
+	if err != nil {
+		httpError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, id)
+}
+
+func (s *Service) handleSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.Schema()); err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+	}
+}
+
+func (s *Service) handleQueries(w http.ResponseWriter, r *http.Request) {
+
+	var q Query
+	if _, err := q.ReadFrom(http.MaxBytesReader(w, r.Body, s.MaxQuerySize)); err != nil {
+		httpError(w, fmt.Errorf("q.ReadFrom: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.Submit(q)
+
+	switch {
+	case errors.Is(err, ErrUnknownKey):
+		httpError(w, err, http.StatusNotFound)
+		return
+	case err != nil:
+		httpError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, id)
+}
+
+func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
+
+	status, err := s.Status(r.PathValue("id"))
+	if err != nil {
+		httpError(w, err, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(status); err != nil {
+		httpError(w, err, http.StatusInternalServerError)
+	}
+}
+
+func (s *Service) handleResult(w http.ResponseWriter, r *http.Request) {
+
+	score, err := s.Result(r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, ErrUnknownJob):
+		httpError(w, err, http.StatusNotFound)
+		return
+	case errors.Is(err, ErrJobNotDone):
+		httpError(w, err, http.StatusConflict)
+		return
+	case err != nil:
+		httpError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	buf := bufio.NewWriter(w)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err = WriteResponse(buf, score); err != nil {
+		httpError(w, fmt.Errorf("WriteResponse: %w", err), http.StatusInternalServerError)
+		return
+	}
+
+	if err = buf.Flush(); err != nil {
+		httpError(w, fmt.Errorf("buf.Flush: %w", err), http.StatusInternalServerError)
+	}
+}
+
+func (s *Service) handleCancel(w http.ResponseWriter, r *http.Request) {
+	if err := s.Cancel(r.PathValue("id")); err != nil {
+		httpError(w, err, http.StatusNotFound)
+	}
+}
+
+// httpError replies with the error, using http.StatusRequestEntityTooLarge
+// if the request body exceeded its limit.
+func httpError(w http.ResponseWriter, err error, code int) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		code = http.StatusRequestEntityTooLarge
+	}
+	http.Error(w, err.Error(), code)
+}
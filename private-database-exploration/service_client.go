@@ -0,0 +1,258 @@
+package pde
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+)
+
+// RemoteClient is a struct wrapping a Client to register its
+// evaluation keys and submit queries to a Service over HTTP.
+type RemoteClient struct {
+	*Client
+	URL        string
+	HTTPClient *http.Client
+	KeyID      string
+
+	// PollInterval is the interval between two status requests of Wait.
+	PollInterval time.Duration
+}
+
+// NewRemoteClient instantiates a new RemoteClient for the service at the given URL.
+func NewRemoteClient(client *Client, url string) *RemoteClient {
+	return &RemoteClient{
+		Client:       client,
+		URL:          strings.TrimSuffix(url, "/"),
+		HTTPClient:   http.DefaultClient,
+		PollInterval: time.Second,
+	}
+}
+
+// Init generates the keys of the client with the configuration of the
+// service, see Client.Init, and registers the evaluation keys to the service.
+func (c *RemoteClient) Init(cfg Config) (err error) {
+
+	var evk EvaluationKeys
+	if evk, err = c.Client.Init(cfg); err != nil {
+		return fmt.Errorf("c.Client.Init: %w", err)
+	}
+
+	return c.RegisterKeys(evk)
+}
+
+// RegisterKeys uploads the evaluation keys to the service and stores the returned
+// key ID. The keys are streamed, without a copy of the serialized keys in memory.
+func (c *RemoteClient) RegisterKeys(evk EvaluationKeys) (err error) {
+
+	resp, err := c.post(RouteKeys, evk)
+	if err != nil {
+		return err
+	}
+
+	c.KeyID = string(resp)
+
+	return
+}
+
+// Schema returns the schema of the database of the service, nil for synthetic databases.
+func (c RemoteClient) Schema() (schema Schema, err error) {
+
+	resp, err := c.get(RouteSchema)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(resp, &schema); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	if schema != nil {
+		if err = schema.Check(); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// Submit submits the request, without its evaluation keys, to be evaluated
+// with the registered evaluation keys, and returns the ID of the job.
+func (c RemoteClient) Submit(r Request) (id string, err error) {
+
+	r.EvaluationKeys = nil
+
+	resp, err := c.post(RouteQueries, Query{KeyID: c.KeyID, Request: r})
+	if err != nil {
+		return "", err
+	}
+
+	return string(resp), nil
+}
+
+// SubmitFunctions encrypts the scoring functions and the two thresholds,
+// see Client.GenEncryptedFunction and Client.GenPrivateThreshold, and
+// submits them to the service.
+func (c RemoteClient) SubmitFunctions(funcs []Func, threshold0, threshold1 float64) (id string, err error) {
+
+	var tvs TestVectors
+	if tvs, err = c.GenEncryptedFunction(funcs); err != nil {
+		return "", fmt.Errorf("c.GenEncryptedFunction: %w", err)
+	}
+
+	var t0, t1 PrivateThreshold
+	if t0, err = c.GenPrivateThreshold(threshold0, funcs); err != nil {
+		return "", fmt.Errorf("c.GenPrivateThreshold: %w", err)
+	}
+
+	if t1, err = c.GenPrivateThreshold(threshold1, nil); err != nil {
+		return "", fmt.Errorf("c.GenPrivateThreshold: %w", err)
+	}
+
+	return c.Submit(Request{TestVectors: &tvs, PrivateThreshold0: &t0, PrivateThreshold1: &t1})
+}
+
+// SubmitCriteria encrypts the criteria, see Client.GenEncryptedCriteria,
+// and submits them to the service.
+func (c RemoteClient) SubmitCriteria(criteria Criteria, minPatients int) (id string, err error) {
+
+	tvs, t0, t1, err := c.GenEncryptedCriteria(criteria, minPatients)
+	if err != nil {
+		return "", fmt.Errorf("c.GenEncryptedCriteria: %w", err)
+	}
+
+	return c.Submit(Request{TestVectors: &tvs, PrivateThreshold0: &t0, PrivateThreshold1: &t1})
+}
+
+// Status returns the status of the job.
+func (c RemoteClient) Status(id string) (status JobStatus, err error) {
+
+	resp, err := c.get(RouteJobs + id)
+	if err != nil {
+		return status, err
+	}
+
+	if err = json.Unmarshal(resp, &status); err != nil {
+		return status, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return
+}
+
+// Result returns the encrypted result of a job that is done.
+func (c RemoteClient) Result(id string) (score *rlwe.Ciphertext, err error) {
+
+	resp, err := c.get(RouteJobs + id + "/result")
+	if err != nil {
+		return nil, err
+	}
+
+	if score, _, err = ReadResponse(newBytesReader(resp)); err != nil {
+		return nil, fmt.Errorf("ReadResponse: %w", err)
+	}
+
+	return
+}
+
+// Cancel cancels the job, see Service.Cancel.
+func (c RemoteClient) Cancel(id string) (err error) {
+
+	req, err := http.NewRequest(http.MethodDelete, c.URL+RouteJobs+id, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequest: %w", err)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("c.HTTPClient.Do: %w", err)
+	}
+
+	_, err = readResponse(resp)
+
+	return
+}
+
+// Wait polls the status of the job every c.PollInterval until it is done,
+// failed or canceled, and returns its decrypted result, see Client.Decrypt.
+func (c RemoteClient) Wait(id string) (v []complex128, err error) {
+
+	for {
+
+		var status JobStatus
+		if status, err = c.Status(id); err != nil {
+			return nil, err
+		}
+
+		switch status.State {
+		case JobDone:
+
+			var score *rlwe.Ciphertext
+			if score, err = c.Result(id); err != nil {
+				return nil, err
+			}
+
+			return c.Decrypt(score)
+
+		case JobFailed:
+			return nil, fmt.Errorf("job %s failed: %s", id, status.Error)
+
+		case JobCanceled:
+			return nil, fmt.Errorf("job %s canceled", id)
+		}
+
+		time.Sleep(c.PollInterval)
+	}
+}
+
+// post streams the object to the route and returns the body of the response.
+func (c RemoteClient) post(route string, object io.WriterTo) (p []byte, err error) {
+
+	body, w := io.Pipe()
+
+	go func() {
+		_, err := object.WriteTo(w)
+		w.CloseWithError(err)
+	}()
+
+	resp, err := c.HTTPClient.Post(c.URL+route, "application/octet-stream", body)
+
+	// Unblocks the writer if the request failed before the body was read
+	body.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("c.HTTPClient.Post: %w", err)
+	}
+
+	return readResponse(resp)
+}
+
+func (c RemoteClient) get(route string) (p []byte, err error) {
+
+	resp, err := c.HTTPClient.Get(c.URL + route)
+	if err != nil {
+		return nil, fmt.Errorf("c.HTTPClient.Get: %w", err)
+	}
+
+	return readResponse(resp)
+}
+
+func readResponse(resp *http.Response) (p []byte, err error) {
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("%s: %s", resp.Status, string(bytes.TrimSpace(msg)))
+	}
+
+	if p, err = io.ReadAll(resp.Body); err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+
+	return
+}
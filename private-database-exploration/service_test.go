@@ -0,0 +1,281 @@
+package pde
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tuneinsight/lattigo/v5/core/rlwe"
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestService(t *testing.T) {
+
+	// Small parameters, without bootstrapping
+	client, evk, _ := newTestKeys(t)
+	cfg := client.Config
+
+	schema := Schema{
+		{Name: "age", Interval: [2]float64{0, 120}},
+		{Name: "sex", Type: Categorical, Categories: []string{"F", "M"}},
+	}
+
+	db := Database{
+		Dense:  mat.NewDense(4, 2, []float64{20, 0, 45, 1, 60, 1, 80, 0}),
+		Schema: schema,
+	}
+
+	criteria, err := CompileCriteria("age > 40 AND sex = M", schema, 64)
+	require.NoError(t, err)
+
+	// The stages of a job return the global threshold of the request
+	release := make(chan struct{})
+	close(release)
+
+	service := NewService(cfg, NewServer(), &db, ServiceParameters{})
+	service.newBootstrapper = func(r Request) (Bootstrapper, error) {
+		return BootstrappingEvaluator{}, nil
+	}
+
+	process := func(ctx context.Context, s Server, r Request, db *Database, btp Bootstrapper) (*rlwe.Ciphertext, error) {
+		<-release
+		return r.PrivateThreshold1.Threshold, nil
+	}
+
+	service.process = process
+
+	ts := httptest.NewServer(service)
+	defer ts.Close()
+
+	remote := NewRemoteClient(&client, ts.URL)
+	remote.PollInterval = 10 * time.Millisecond
+	require.NoError(t, remote.RegisterKeys(evk))
+
+	have, err := remote.Schema()
+	require.NoError(t, err)
+	require.Equal(t, schema, have)
+
+	t.Run("Functions", func(t *testing.T) {
+
+		id, err := remote.SubmitFunctions(criteria.Funcs, criteria.Threshold, 3)
+		require.NoError(t, err)
+
+		v, err := remote.Wait(id)
+		require.NoError(t, err)
+		require.InDelta(t, 3, real(v[0]), 1e-3)
+
+		status, err := remote.Status(id)
+		require.NoError(t, err)
+		require.Equal(t, JobDone, status.State)
+		require.Equal(t, remote.KeyID, status.KeyID)
+		require.False(t, status.Finished.Before(status.Started))
+	})
+
+	t.Run("Criteria", func(t *testing.T) {
+
+		id, err := remote.SubmitCriteria(criteria, 2)
+		require.NoError(t, err)
+
+		v, err := remote.Wait(id)
+		require.NoError(t, err)
+		require.InDelta(t, 2, real(v[0]), 1e-3)
+	})
+
+	t.Run("Pending", func(t *testing.T) {
+
+		release = make(chan struct{})
+
+		id, err := remote.SubmitCriteria(criteria, 2)
+		require.NoError(t, err)
+
+		status, err := remote.Status(id)
+		require.NoError(t, err)
+		require.Contains(t, []JobState{JobPending, JobRunning}, status.State)
+
+		_, err = remote.Result(id)
+		require.ErrorContains(t, err, "409")
+
+		close(release)
+
+		_, err = remote.Wait(id)
+		require.NoError(t, err)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+
+		defer func() { service.process = process }()
+
+		service.process = func(ctx context.Context, s Server, r Request, db *Database, btp Bootstrapper) (*rlwe.Ciphertext, error) {
+			return nil, fmt.Errorf("evaluation error")
+		}
+
+		id, err := remote.SubmitCriteria(criteria, 2)
+		require.NoError(t, err)
+
+		_, err = remote.Wait(id)
+		require.ErrorContains(t, err, "evaluation error")
+
+		service.process = func(ctx context.Context, s Server, r Request, db *Database, btp Bootstrapper) (*rlwe.Ciphertext, error) {
+			panic("evaluation panic")
+		}
+
+		id, err = remote.SubmitCriteria(criteria, 2)
+		require.NoError(t, err)
+
+		_, err = remote.Wait(id)
+		require.ErrorContains(t, err, "evaluation panic")
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+
+		defer func() { service.process = process }()
+
+		service.process = func(ctx context.Context, s Server, r Request, db *Database, btp Bootstrapper) (*rlwe.Ciphertext, error) {
+			s.Observer.Observe(Progress{Stage: StageLookupPacking, Done: 1, Total: 2})
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+
+		id, err := remote.SubmitCriteria(criteria, 2)
+		require.NoError(t, err)
+
+		// The progress of the running job is in its status
+		var status JobStatus
+		require.Eventually(t, func() bool {
+			status, err = remote.Status(id)
+			return err == nil && status.Progress != nil
+		}, 10*time.Second, remote.PollInterval)
+
+		require.Equal(t, JobRunning, status.State)
+		require.Equal(t, StageLookupPacking, status.Progress.Stage)
+		require.Equal(t, 1, status.Progress.Done)
+		require.Equal(t, 2, status.Progress.Total)
+
+		require.NoError(t, remote.Cancel(id))
+
+		_, err = remote.Wait(id)
+		require.ErrorContains(t, err, "canceled")
+
+		status, err = remote.Status(id)
+		require.NoError(t, err)
+		require.Equal(t, JobCanceled, status.State)
+
+		require.ErrorContains(t, remote.Cancel("unknown"), "404")
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		other := *remote
+		other.KeyID = "unknown"
+		_, err := other.SubmitCriteria(criteria, 2)
+		require.ErrorContains(t, err, "404")
+	})
+
+	t.Run("UnknownJob", func(t *testing.T) {
+		_, err := remote.Status("unknown")
+		require.ErrorContains(t, err, "404")
+
+		_, err = remote.Result("unknown")
+		require.ErrorContains(t, err, "404")
+	})
+
+	t.Run("InvalidQuery", func(t *testing.T) {
+
+		// A function on a column which is not in the database
+		f := criteria.Funcs[0]
+		f.Columns = []int{len(criteria.Funcs)}
+		_, err := remote.SubmitFunctions([]Func{f}, criteria.Threshold, 2)
+		require.ErrorContains(t, err, "400")
+
+		tvs, t0, t1, err := client.GenEncryptedCriteria(criteria, 2)
+		require.NoError(t, err)
+
+		r := Request{TestVectors: &tvs, PrivateThreshold0: &t0, PrivateThreshold1: &t1}
+
+		// The keys must be registered
+		withKeys := r
+		withKeys.EvaluationKeys = &evk
+		_, err = service.Submit(Query{KeyID: remote.KeyID, Request: withKeys})
+		require.Error(t, err)
+
+		// The service has no privacy accountant
+		noisy := r
+		noisy.Privacy = &DifferentialPrivacy{Noise: DiscreteLaplace, Epsilon: 1}
+		_, err = service.Submit(Query{KeyID: remote.KeyID, Request: noisy})
+		require.Error(t, err)
+
+		// The thresholds are encrypted in the ring of cfg.LogNEval
+		swapped := r
+		swapped.PrivateThreshold1 = &PrivateThreshold{Threshold: &tvs[0].Value[0]}
+		_, err = service.Submit(Query{KeyID: remote.KeyID, Request: swapped})
+		require.Error(t, err)
+	})
+
+	t.Run("PublicGrids", func(t *testing.T) {
+
+		public := NewService(cfg, NewServer(), &db, ServiceParameters{PublicGrids: true})
+		public.keys = service.keys
+		public.newBootstrapper = service.newBootstrapper
+		public.process = process
+
+		ts := httptest.NewServer(public)
+		defer ts.Close()
+
+		other := *remote
+		other.URL = ts.URL
+
+		// Criteria on the public grids of the schema
+		onGrids, err := CompileCriteria("age > 40 AND sex = M", schema, 0)
+		require.NoError(t, err)
+
+		id, err := other.SubmitCriteria(onGrids, 2)
+		require.NoError(t, err)
+
+		v, err := other.Wait(id)
+		require.NoError(t, err)
+		require.InDelta(t, 2, real(v[0]), 1e-3)
+
+		// A client-chosen grid
+		_, err = other.SubmitCriteria(criteria, 2)
+		require.ErrorContains(t, err, "400")
+
+		// A selection interval in the clear
+		f := NewScoringFunction([2]float64{40, 65}, 64, 1/float64(Scaling))
+		_, err = other.SubmitFunctions([]Func{f, onGrids.Funcs[1]}, 1, 2)
+		require.ErrorContains(t, err, "400")
+
+		// The i-th function must consume the i-th column
+		age, sex := onGrids.Funcs[0], onGrids.Funcs[1]
+		age.Columns, sex.Columns = []int{0}, []int{1}
+
+		_, err = other.SubmitFunctions([]Func{age, sex}, 1, 2)
+		require.NoError(t, err)
+
+		_, err = other.SubmitFunctions([]Func{sex, age}, 1, 2)
+		require.ErrorContains(t, err, "400")
+	})
+
+	t.Run("InvalidKeys", func(t *testing.T) {
+		other := evk
+		other.RepackEvaluationKeySet.Parameters = map[int]*hefloat.Parameters{cfg.LogNPack: evk.Parameters[cfg.LogNPack]}
+		require.ErrorContains(t, remote.RegisterKeys(other), "400")
+	})
+
+	t.Run("QueryTooLarge", func(t *testing.T) {
+
+		limited := NewService(cfg, NewServer(), &db, ServiceParameters{MaxQuerySize: 1 << 10})
+		limited.keys = service.keys
+
+		ts := httptest.NewServer(limited)
+		defer ts.Close()
+
+		other := *remote
+		other.URL = ts.URL
+
+		_, err := other.SubmitCriteria(criteria, 2)
+		require.ErrorContains(t, err, "413")
+	})
+}
@@ -0,0 +1,141 @@
+package pde
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/tuneinsight/lattigo/v5/he/hefloat"
+	"github.com/tuneinsight/lattigo/v5/utils/bignum"
+)
+
+// signPolynomialPrec is the precision in bits of the Remez algorithm generating the sign polynomials.
+const signPolynomialPrec = 256
+
+// signPolynomialDegree is the degree of each polynomial of a composite sign polynomial.
+const signPolynomialDegree = 15
+
+// SignPolynomial is the specification of a composite minimax polynomial of the sign
+// function on [-1-2^{-LogErr}, -2^{-LogAlpha}] U [2^{-LogAlpha}, 1+2^{-LogErr}],
+// see hefloat.GenMinimaxCompositePolynomialForSign.
+type SignPolynomial struct {
+	// LogAlpha is the resolution of the polynomial: it distinguishes
+	// the inputs x with |x| >= 2^{-LogAlpha}.
+	LogAlpha int
+	// LogErr is the error of the inputs 2^{-LogErr} tolerated by the polynomial.
+	LogErr int
+	// Degrees are the degrees of the composed polynomials.
+	Degrees []int
+}
+
+// NewSignPolynomial returns the specification of the sign polynomial of a threshold
+// on the values in [-bound, bound] normalized by 1/bound, distinguishing the values
+// with a delta of at least 1/2 and tolerating an error of 2^{-logPrecision} of the values.
+// The resolution is rounded up to a multiple of 4 bits, with one polynomial of degree 15
+// for every 4 bits of resolution plus one, so that close bounds share the same polynomial.
+func NewSignPolynomial(bound float64, logPrecision int) SignPolynomial {
+
+	// Smallest delta 1/2 normalized by 1/bound
+	logAlpha := int(math.Ceil(math.Log2(2 * math.Max(bound, 1))))
+	logAlpha = 4 * ((logAlpha + 3) / 4)
+
+	degrees := make([]int, logAlpha/4+1)
+	for i := range degrees {
+		degrees[i] = signPolynomialDegree
+	}
+
+	return SignPolynomial{
+		LogAlpha: logAlpha,
+		LogErr:   logAlpha - 1 + logPrecision,
+		Degrees:  degrees,
+	}
+}
+
+// Depth returns the multiplicative depth of the deepest composed polynomial.
+func (p SignPolynomial) Depth() (depth int) {
+	for _, d := range p.Degrees {
+		depth = int(math.Max(float64(depth), math.Ceil(math.Log2(float64(d)))))
+	}
+	return
+}
+
+func (p SignPolynomial) String() string {
+	return fmt.Sprintf("SignPolynomial{LogAlpha: %d, LogErr: %d, Degrees: %v}", p.LogAlpha, p.LogErr, p.Degrees)
+}
+
+// signPolynomials caches the coefficients of the sign polynomials by specification,
+// starting with the precomputed polynomials of the thresholds of the default configurations.
+// The mutex only guards the map: each polynomial is generated once, outside of it,
+// so that the generation of a polynomial does not block the lookup of the others.
+var signPolynomials = struct {
+	sync.Mutex
+	entries map[string]*signPolynomialEntry
+}{
+	entries: map[string]*signPolynomialEntry{
+		SignPolynomial{8, 12, []int{15, 15, 15}}.String():          {coeffs: MinimaxCompositePolynomialForSignThreshold0},
+		SignPolynomial{16, 20, []int{15, 15, 15, 15, 15}}.String(): {coeffs: MinimaxCompositePolynomialForSignThreshold1},
+	},
+}
+
+// signPolynomialEntry is the cached coefficients of a sign polynomial,
+// which are generated by the first caller if they are not precomputed.
+type signPolynomialEntry struct {
+	once   sync.Once
+	coeffs [][]string
+}
+
+// Coefficients returns the coefficients of the composed polynomials in the Chebyshev basis.
+// They are generated with the Remez algorithm on the first call for a specification, which
+// can take tens of seconds, and are then cached for the lifetime of the process.
+// Concurrent calls for the same specification wait for the first one, and calls
+// for other specifications are not blocked.
+func (p SignPolynomial) Coefficients() [][]string {
+
+	signPolynomials.Lock()
+	entry, ok := signPolynomials.entries[p.String()]
+	if !ok {
+		entry = &signPolynomialEntry{}
+		signPolynomials.entries[p.String()] = entry
+	}
+	signPolynomials.Unlock()
+
+	entry.once.Do(func() {
+		if entry.coeffs == nil {
+			entry.coeffs = p.generate()
+		}
+	})
+
+	return entry.coeffs
+}
+
+// generate generates the coefficients of the polynomial, formatted
+// as by hefloat.GenMinimaxCompositePolynomialForSign.
+func (p SignPolynomial) generate() (coeffs [][]string) {
+
+	polys := hefloat.GenMinimaxCompositePolynomial(signPolynomialPrec, p.LogAlpha, p.LogErr, p.Degrees, bignum.Sign)
+
+	decimals := int(float64(p.LogAlpha)/math.Log2(10)+0.5) + 10
+
+	coeffs = make([][]string, len(polys))
+
+	for i := range polys {
+
+		coeffs[i] = make([]string, len(polys[i]))
+
+		// The sign is odd
+		for j := range polys[i] {
+			if j&1 == 1 {
+				coeffs[i][j] = fmt.Sprintf("%.*f", decimals, polys[i][j])
+			} else {
+				coeffs[i][j] = "0"
+			}
+		}
+	}
+
+	return
+}
+
+// Polynomial returns the composite polynomial, see SignPolynomial.Coefficients.
+func (p SignPolynomial) Polynomial() hefloat.MinimaxCompositePolynomial {
+	return hefloat.NewMinimaxCompositePolynomial(p.Coefficients())
+}
@@ -7,13 +7,14 @@ import (
 	"github.com/tuneinsight/lattigo/v5/ring"
 )
 
+// RunTimed runs f and prints its duration on a single line,
+// so that the stages evaluated in parallel do not interleave.
 func RunTimed(msg string, f func() (err error)) (err error) {
-	fmt.Printf("%s: ", msg)
 	now := time.Now()
 	if err = f(); err != nil {
 		return
 	}
-	fmt.Printf("%s\n", time.Since(now))
+	fmt.Printf("%s: %s\n", msg, time.Since(now))
 	return
 }
 
@@ -23,6 +24,7 @@ func RunTimed(msg string, f func() (err error)) (err error) {
 // of up to 14.0 bits of precision.
 //
 // It was computed with hefloat.GenMinimaxCompositePolynomialForSign(256, 8, 12, []int{15, 15, 15}).
+// It is the precomputed local threshold of the default configurations, see Config.LocalThresholdPolynomial.
 var MinimaxCompositePolynomialForSignThreshold0 = [][]string{
 	{"0", "0.667972070856", "0", "-0.223989523020", "0", "0.136121229346", "0", "-0.099160550898", "0", "0.079224867308", "0", "-0.067250088206", "0", "0.059852569462", "0", "-0.503955481350"},
 	{"0", "0.955669291788", "0", "-0.317870998995", "0", "0.189953989728", "0", "-0.134924463410", "0", "0.104260767625", "0", "-0.084798113265", "0", "0.071534728674", "0", "-0.282024623439"},
@@ -35,6 +37,7 @@ var MinimaxCompositePolynomialForSignThreshold0 = [][]string{
 // of up to 9.4 bits of precision.
 //
 // It was computed with hefloat.GenMinimaxCompositePolynomialForSign(256, 16, 20, []int{15, 15, 15, 15, 15}).
+// It is the precomputed global threshold on 2^{11}+1 to 2^{15} rows, see Config.GlobalThresholdPolynomial.
 var MinimaxCompositePolynomialForSignThreshold1 = [][]string{
 	{"0", "0.637268817143423", "0", "-0.213843858840010", "0", "0.130068019801244", "0", "-0.094901182442864", "0", "0.076054612814770", "0", "-0.064781641895431", "0", "0.057798688832330", "0", "-0.527470371234989"},
 	{"0", "0.638695683522550", "0", "-0.214316818308012", "0", "0.130348567125999", "0", "-0.095098055756955", "0", "0.076204005701363", "0", "-0.064899954787194", "0", "0.057894713727750", "0", "-0.526392177258839"},